@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/serboupal/note/note"
 )
@@ -13,6 +14,10 @@ import (
 func add(args []string) {
 	fl := flag.NewFlagSet("add", flag.ContinueOnError)
 	edit := fl.Bool("edit", false, "open editor to modify before adding note")
+	groups := fl.String("group", "", "comma separated groups of the note")
+	encrypt := fl.Bool("encrypt", false, "encrypt the note with a passphrase")
+	to := fl.String("to", "", "encrypt the note to an encryption key from note key")
+	signed := fl.Bool("sign", false, "sign the note")
 	usg := "[options] NAME"
 
 	fl.Usage = func() {
@@ -60,15 +65,28 @@ func add(args []string) {
 		errExit(err.Error())
 	}
 
+	if *encrypt || *to != "" {
+		buf, err = sealData(buf, *to)
+		if err != nil {
+			errExit(err.Error())
+		}
+	}
 	n, err := note.NewNote(name, "", buf)
 	if err != nil {
 		errExit(err.Error())
 	}
+	if *groups != "" {
+		n.Groups = strings.Split(*groups, ",")
+	}
+	n.Encrypted = *encrypt || *to != ""
 
 	err = backend.Create(n)
 	if err != nil {
 		errExit(err.Error())
 	}
+	if *signed {
+		signNote(n)
+	}
 }
 
 func readPipe() ([]byte, error) {
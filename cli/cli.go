@@ -1,15 +1,24 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
 
-	"github.com/serboupal/note/internal/https"
-	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/internal/cache"
+	"github.com/serboupal/note/internal/e2e"
+	_ "github.com/serboupal/note/internal/folder"
+	_ "github.com/serboupal/note/internal/git"
+	_ "github.com/serboupal/note/internal/https"
+	_ "github.com/serboupal/note/internal/local"
+	_ "github.com/serboupal/note/internal/s3"
+	_ "github.com/serboupal/note/internal/sqlite"
 	"github.com/serboupal/note/note"
 )
 
@@ -17,10 +26,16 @@ const appFolder = "note"
 
 var cmdOut = os.Stderr
 var backend note.Backend
+var remote note.Backend
+var cached *cache.Cache
+var vault locker
+var app *Cli
 
 type cmd struct {
 	fn   func([]string)
 	desc string
+	// nokey commands don't read notes, so an encrypted store isn't unlocked
+	nokey bool
 }
 
 type Cli struct {
@@ -29,18 +44,38 @@ type Cli struct {
 }
 
 type config struct {
-	remote string
-	token  string
+	remote  string
+	token   string
+	timeout string
+	retries string
+	e2e     string
+	author  string
+	store   string
 }
 
 var commands = map[string]cmd{
-	"add":    {fn: add, desc: "add note"},
-	"list":   {fn: list, desc: "list notes"},
-	"view":   {fn: view, desc: "view note content"},
-	"search": {fn: search, desc: "search in note content"},
-	"delete": {fn: delete, desc: "delete note"},
-	"edit":   {fn: edit, desc: "edit note"},
-	"serve":  {fn: serve, desc: "start rest server"},
+	"add":     {fn: add, desc: "add note"},
+	"list":    {fn: list, desc: "list notes"},
+	"view":    {fn: view, desc: "view note content"},
+	"search":  {fn: search, desc: "search in note content"},
+	"delete":  {fn: delete, desc: "delete note"},
+	"edit":    {fn: edit, desc: "edit note"},
+	"serve":   {fn: serve, desc: "start rest server", nokey: true},
+	"token":   {fn: token, desc: "manage api tokens"},
+	"share":   {fn: share, desc: "share notes with other users"},
+	"link":    {fn: link, desc: "publish note with a public link"},
+	"sync":    {fn: sync, desc: "sync local notes with remote server"},
+	"outbox":  {fn: outbox, desc: "show changes waiting for remote server"},
+	"watch":   {fn: watch, desc: "print note changes made on remote server"},
+	"sign":    {fn: sign, desc: "sign the current revision of a note"},
+	"verify":  {fn: verify, desc: "check who signed a note"},
+	"trust":   {fn: trust, desc: "trust the signing key of an author", nokey: true},
+	"key":     {fn: key, desc: "print public keys to receive encrypted notes and check signatures", nokey: true},
+	"init":    {fn: initStore, desc: "initialize the local store", nokey: true},
+	"unlock":  {fn: unlock, desc: "cache the key of the encrypted store", nokey: true},
+	"lock":    {fn: lock, desc: "forget cached keys", nokey: true},
+	"migrate": {fn: migrate, desc: "encrypt notes stored before end-to-end encryption"},
+	"agent":   {fn: runAgent, desc: "run the key cache used by unlock", nokey: true},
 }
 
 var ErrFileEmpty = errors.New("file is empty")
@@ -58,29 +93,105 @@ func NewCli() *Cli {
 	}
 	return &Cli{
 		cfg: config{
-			remote: os.Getenv("NOTE_HTTPS_URL"),
-			token:  os.Getenv("NOTE_HTTPS_TOKEN"),
+			remote:  os.Getenv("NOTE_HTTPS_URL"),
+			token:   os.Getenv("NOTE_HTTPS_TOKEN"),
+			timeout: os.Getenv("NOTE_HTTPS_TIMEOUT"),
+			retries: os.Getenv("NOTE_HTTPS_RETRIES"),
+			e2e:     os.Getenv("NOTE_E2E"),
+			author:  os.Getenv("NOTE_AUTHOR"),
+			store:   os.Getenv("NOTE_STORE"),
 		},
 		configDir: configDir,
 	}
 }
 
-func main() {
-	cli := NewCli()
-	if cli.cfg.remote != "" {
-		if cli.cfg.token == "" {
-			errExit("To use remote service, you need to provide an auth token")
-		}
-		backend = https.NewBackend(cli.cfg.remote, cli.cfg.token)
-	} else {
-		backend = local.NewBackend(appFolder)
+// storeURL returns the store selected by --store, NOTE_STORE or
+// NOTE_HTTPS_URL, the local folder by default.
+func (c *Cli) storeURL() string {
+	switch {
+	case c.cfg.store != "":
+		return c.cfg.store
+	case c.cfg.remote != "":
+		return c.cfg.remote
 	}
+	return c.localStore()
+}
 
-	err := backend.Init()
+// localStore returns the URL of the default local folder.
+func (c *Cli) localStore() string {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		panic(err)
+		errExit(err.Error())
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(home, "."+appFolder))}
+	return u.String()
+}
+
+// configPath returns the path of name in the config folder kept apart for
+// each set of keys, like the cache of each server and token.
+func (c *Cli) configPath(name string, keys ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return filepath.Join(c.configDir, fmt.Sprintf("%s-%x", name, h[:8]))
+}
+
+// isServer reports whether store is a note server, whose notes are cached
+// for offline use.
+func isServer(store string) bool {
+	u, err := url.Parse(store)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http")
+}
+
+// open returns the backend of store. Note servers get the token and options
+// of the environment unless store sets them.
+func (c *Cli) open(store string) note.Backend {
+	u, err := url.Parse(store)
+	if err != nil {
+		errExit("invalid store: " + err.Error())
+	}
+	if isServer(store) {
+		if u.User == nil {
+			if c.cfg.token == "" {
+				errExit("To use remote service, you need to provide an auth token")
+			}
+			u.User = url.User(c.cfg.token)
+		}
+		q := u.Query()
+		if c.cfg.timeout != "" && !q.Has("timeout") {
+			q.Set("timeout", c.cfg.timeout)
+		}
+		if c.cfg.retries != "" && !q.Has("retries") {
+			q.Set("retries", c.cfg.retries)
+		}
+		u.RawQuery = q.Encode()
+	}
+	b, err := note.Open(u.String())
+	if err != nil {
+		errExit(err.Error())
+	}
+	return b
+}
+
+// newE2E returns the end-to-end encryption wrapper of r set by NOTE_E2E,
+// data to encrypt note contents or names to also encrypt names, or nil. Each
+// server has its own copy of the key file.
+func (c *Cli) newE2E(r note.Backend) *e2e.E2E {
+	keyPath := c.configPath("e2e-key", c.storeURL())
+	switch c.cfg.e2e {
+	case "", "0", "false":
+		return nil
+	case "data", "1", "true":
+		return e2e.NewBackend(r, false, keyPath)
+	case "names":
+		return e2e.NewBackend(r, true, keyPath)
 	}
+	errExit("invalid NOTE_E2E, use data or names")
+	return nil
+}
 
+func main() {
+	app = NewCli()
+	flag.StringVar(&app.cfg.store, "store", app.cfg.store,
+		"URL of the notes store, one of "+strings.Join(note.Stores(), ", "))
 	flag.Usage = func() {
 		usage(flag.CommandLine, commands, "")
 	}
@@ -96,6 +207,31 @@ func main() {
 		flag.Usage()
 	}
 
+	store := app.storeURL()
+	b := app.open(store)
+	if isServer(store) {
+		// the cache keeps what the server gets, ciphertext with end-to-end
+		// encryption
+		remote = b
+		cached = cache.NewBackend(remote, app.configPath("cache", store, app.cfg.token))
+		backend = cached
+		if e := app.newE2E(cached); e != nil {
+			vault = e
+			backend = e
+		}
+	} else {
+		vault, _ = b.(locker)
+		backend = b
+	}
+
+	err := backend.Init()
+	if err != nil {
+		panic(err)
+	}
+	if vault != nil && !cmd.nokey {
+		unlockStore(vault)
+	}
+
 	cmd.fn(subcommand[1:])
 }
 
@@ -132,6 +268,34 @@ func printOpt(fs *flag.FlagSet) {
 	}
 }
 
+// direct returns the backend for operations that only make sense against the
+// server itself, bypassing the offline cache.
+func direct() note.Backend {
+	if remote == nil {
+		return backend
+	}
+	if e, ok := vault.(*e2e.E2E); ok {
+		return e.Over(remote)
+	}
+	return remote
+}
+
+// parseInterspersed parses fl allowing options after positional arguments,
+// which are returned.
+func parseInterspersed(fl *flag.FlagSet, args []string) []string {
+	var pos []string
+	for {
+		if err := fl.Parse(args); err != nil {
+			fl.Usage()
+		}
+		if fl.NArg() == 0 {
+			return pos
+		}
+		pos = append(pos, fl.Arg(0))
+		args = fl.Args()[1:]
+	}
+}
+
 func isPipe(p *os.File) bool {
 	sin, _ := p.Stat()
 	if (sin.Mode() & os.ModeCharDevice) == 0 {
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/serboupal/note/internal/agent"
+	"github.com/serboupal/note/internal/e2e"
+	"golang.org/x/term"
+)
+
+const defaultKeyTTL = 15 * time.Minute
+
+// locker is a store encrypted with a passphrase, the local store or the
+// remote one with end-to-end encryption.
+type locker interface {
+	Encrypted() bool
+	Encrypt(pass []byte) ([]byte, error)
+	KeyId() (string, error)
+	DeriveKey(pass []byte) ([]byte, error)
+	Unlock(key []byte) error
+}
+
+func initStore(args []string) {
+	fl := flag.NewFlagSet("init", flag.ContinueOnError)
+	encrypt := fl.Bool("encrypt", false, "encrypt notes with a passphrase, end-to-end with NOTE_E2E")
+	ttl := fl.Duration("ttl", defaultKeyTTL, "how long the key stays cached")
+	usg := "[options]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	if !*encrypt {
+		return
+	}
+	l := vault
+	if l == nil && remote != nil {
+		errExit("set NOTE_E2E to encrypt notes on the remote server")
+	}
+	if l == nil {
+		errExit("store does not support encryption")
+	}
+	if l.Encrypted() {
+		errExit("store is already encrypted")
+	}
+
+	pass, err := readPassword("new passphrase: ")
+	if err != nil {
+		errExit(err.Error())
+	}
+	again, err := readPassword("repeat passphrase: ")
+	if err != nil {
+		errExit(err.Error())
+	}
+	if !bytes.Equal(pass, again) {
+		errExit("passphrases don't match")
+	}
+	if len(pass) == 0 {
+		errExit("empty passphrase")
+	}
+	key, err := l.Encrypt(pass)
+	if err != nil {
+		errExit(err.Error())
+	}
+	cacheKey(l, key, *ttl)
+}
+
+func unlock(args []string) {
+	fl := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	ttl := fl.Duration("ttl", defaultKeyTTL, "how long the key stays cached")
+	usg := "[options]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	l := vault
+	if l == nil || !l.Encrypted() {
+		errExit("store is not encrypted")
+	}
+	pass, err := readPassword("passphrase: ")
+	if err != nil {
+		errExit(err.Error())
+	}
+	key, err := l.DeriveKey(pass)
+	if err != nil {
+		errExit(err.Error())
+	}
+	cacheKey(l, key, *ttl)
+}
+
+func lock(args []string) {
+	fl := flag.NewFlagSet("lock", flag.ContinueOnError)
+	usg := ""
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	err := agent.Lock(agentSocket())
+	if err != nil && !errors.Is(err, agent.ErrNoAgent) {
+		errExit(err.Error())
+	}
+}
+
+// migrate seals the notes left in clear on the server, which reads refuse
+// once end-to-end encryption is set up.
+func migrate(args []string) {
+	fl := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	usg := ""
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	e, ok := vault.(*e2e.E2E)
+	if !ok {
+		errExit("migrate is only used with NOTE_E2E")
+	}
+	n, err := e.Migrate()
+	if err != nil {
+		errExit(err.Error())
+	}
+	fmt.Printf("%d notes encrypted\n", n)
+}
+
+func runAgent(args []string) {
+	fl := flag.NewFlagSet("agent", flag.ContinueOnError)
+	usg := ""
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	err := agent.Serve(agentSocket())
+	if err != nil {
+		errExit(err.Error())
+	}
+}
+
+// unlockStore gives l its key when encrypted, from the agent or asking for
+// the passphrase.
+func unlockStore(l locker) {
+	if !l.Encrypted() {
+		if _, ok := l.(*e2e.E2E); ok {
+			errExit(e2e.ErrNoKey.Error())
+		}
+		return
+	}
+	id, err := l.KeyId()
+	if err != nil {
+		errExit(err.Error())
+	}
+	key, err := agent.Get(agentSocket(), id)
+	if err == nil && l.Unlock(key) == nil {
+		return
+	}
+
+	pass, err := readPassword("passphrase: ")
+	if err != nil {
+		errExit("store is encrypted, run note unlock")
+	}
+	key, err = l.DeriveKey(pass)
+	if err != nil {
+		errExit(err.Error())
+	}
+	err = l.Unlock(key)
+	if err != nil {
+		errExit(err.Error())
+	}
+}
+
+// cacheKey keeps key in the agent, starting it if needed.
+func cacheKey(l locker, key []byte, ttl time.Duration) {
+	id, err := l.KeyId()
+	if err != nil {
+		errExit(err.Error())
+	}
+	sock := agentSocket()
+	err = agent.Set(sock, id, key, ttl)
+	if errors.Is(err, agent.ErrNoAgent) {
+		err = startAgent()
+		if err != nil {
+			errExit(err.Error())
+		}
+		err = agent.Set(sock, id, key, ttl)
+	}
+	if err != nil {
+		errExit(err.Error())
+	}
+}
+
+func startAgent() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "agent")
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	cmd.Process.Release()
+
+	for i := 0; i < 20; i++ {
+		if agent.Running(agentSocket()) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return agent.ErrNoAgent
+}
+
+// agentSocket is kept in its own directory, the agent makes it private.
+func agentSocket() string {
+	return filepath.Join(app.configDir, "agent", "sock")
+}
+
+// readPassword reads a passphrase from the terminal, even when stdin is a
+// pipe.
+func readPassword(prompt string) ([]byte, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return pass, err
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
 	"flag"
+	"fmt"
 )
 
 func edit(args []string) {
 	fl := flag.NewFlagSet("edit", flag.ContinueOnError)
-	usg := "NAME"
+	signed := fl.Bool("sign", false, "sign the new revision")
+	usg := "[options] NAME"
 	fl.Usage = func() { usage(fl, nil, usg) }
 	fl.Parse(args)
 
@@ -22,17 +25,36 @@ func edit(args []string) {
 	}
 
 	var buf []byte
+	data := note.Data
+	var reseal func([]byte) ([]byte, error)
+	if note.Encrypted {
+		data, reseal, err = openData(note)
+		if err != nil {
+			errExit(err.Error())
+		}
+	}
 
-	buf, err = openEditor(note.Data)
+	buf, err = openEditor(data)
 	if err != nil {
 		errExit(err.Error())
 	}
 	if len(buf) == 0 {
 		errExit("Invalid buffer")
 	}
+	if reseal != nil {
+		buf, err = reseal(buf)
+		if err != nil {
+			errExit(err.Error())
+		}
+	}
 
 	err = backend.Update(note.Name, buf)
 	if err != nil {
 		errExit(err.Error())
 	}
+	if *signed {
+		note.Id = fmt.Sprintf("%x", sha256.Sum256(buf))
+		note.Data = buf
+		signNote(&note)
+	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+func link(args []string) {
+	fl := flag.NewFlagSet("link", flag.ContinueOnError)
+	expires := fl.Duration("expires", 24*time.Hour, "link lifetime")
+	revoke := fl.String("revoke", "", "revoke link with `ID`")
+	usg := "[options] [NAME]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	pos := parseInterspersed(fl, args)
+
+	l, ok := direct().(note.Linker)
+	if !ok {
+		errExit("public links need a remote server")
+	}
+
+	if *revoke != "" {
+		err := l.RevokeLink(*revoke)
+		if err != nil {
+			errExit(err.Error())
+		}
+		return
+	}
+
+	switch len(pos) {
+	case 0:
+		links, err := l.Links()
+		if err != nil {
+			errExit(err.Error())
+		}
+		printLinks(links)
+	case 1:
+		link, err := l.CreateLink(pos[0], *expires)
+		if err != nil {
+			errExit(err.Error())
+		}
+		fmt.Println(link.URL)
+	default:
+		fl.Usage()
+	}
+}
+
+func printLinks(links []note.Link) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tNAME\tDATE\tEXPIRES\n")
+	for _, v := range links {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Id, v.Name, v.Date.Format(time.RFC822), v.Expires.Format(time.RFC822))
+	}
+	w.Flush()
+}
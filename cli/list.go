@@ -12,7 +12,8 @@ import (
 
 func list(args []string) {
 	fl := flag.NewFlagSet("list", flag.ContinueOnError)
-	usg := "[EXPRESSION]"
+	shared := fl.Bool("shared", false, "list notes other users shared with you")
+	usg := "[options] [EXPRESSION]"
 	fl.Usage = func() { usage(fl, nil, usg) }
 	fl.Parse(args)
 
@@ -22,7 +23,13 @@ func list(args []string) {
 
 	var data []note.Note
 	var err error
-	if fl.NArg() == 0 {
+	if *shared {
+		s, ok := direct().(sharedLister)
+		if !ok {
+			errExit("backend does not support sharing")
+		}
+		data, err = s.Shared()
+	} else if fl.NArg() == 0 {
 		data, err = backend.List("")
 	} else {
 		data, err = backend.List(fl.Arg(0))
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/serboupal/note/internal/e2e"
+)
+
+func outbox(args []string) {
+	fl := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	drop := fl.Bool("drop", false, "discard the oldest change, the note is kept in the cache")
+	usg := "[options]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	c := cached
+	if c == nil {
+		errExit("outbox is only used with a remote server")
+	}
+
+	if *drop {
+		err := c.Drop()
+		if err != nil {
+			errExit(err.Error())
+		}
+		return
+	}
+
+	ops, err := c.Outbox()
+	if err != nil {
+		errExit(err.Error())
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CHANGE\tNAME\tDATE\n")
+	e, _ := vault.(*e2e.E2E)
+	for _, v := range ops {
+		name := v.Name
+		if e != nil {
+			name = e.PlainName(name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Kind, name, v.Date.Format(time.RFC822))
+	}
+	w.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/serboupal/note/internal/crypt"
+	"github.com/serboupal/note/note"
+)
+
+func key(args []string) {
+	fl := flag.NewFlagSet("key", flag.ContinueOnError)
+	usg := ""
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	priv, err := privateKey(true)
+	if err != nil {
+		errExit(err.Error())
+	}
+	sk, err := signingKey(true)
+	if err != nil {
+		errExit(err.Error())
+	}
+	fmt.Printf("encryption  %s\n", crypt.FormatPublic(priv.PublicKey()))
+	fmt.Printf("signing     %s\n", note.FormatKey(sk.Public().(ed25519.PublicKey)))
+}
+
+// privateKey returns the key notes sealed to our public key are opened
+// with, creating it if create is set.
+func privateKey(create bool) (*ecdh.PrivateKey, error) {
+	path := filepath.Join(app.configDir, "x25519.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, err
+		}
+		return ecdh.X25519().NewPrivateKey(b)
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, err
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(priv.Bytes())+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// sealData encrypts data to the public key to, or with a passphrase when to
+// is empty.
+func sealData(data []byte, to string) ([]byte, error) {
+	if to != "" {
+		pub, err := crypt.ParsePublic(to)
+		if err != nil {
+			return nil, err
+		}
+		return crypt.SealTo(pub, data)
+	}
+
+	pass, err := readPassword("note passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	again, err := readPassword("repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, again) {
+		return nil, errors.New("passphrases don't match")
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return crypt.SealPassphrase(pass, data)
+}
+
+// openData decrypts the content of an encrypted note. It also returns a
+// function sealing new content the same way.
+func openData(n note.Note) ([]byte, func([]byte) ([]byte, error), error) {
+	env, err := crypt.ParseEnvelope(n.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if env.Kind == "x25519" {
+		priv, err := privateKey(false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("no private key to open %s: %w", n.Name, err)
+		}
+		data, err := env.OpenWith(priv)
+		reseal := func(b []byte) ([]byte, error) { return crypt.SealTo(env.Recipient, b) }
+		return data, reseal, err
+	}
+
+	pass, err := readPassword("passphrase for " + n.Name + ": ")
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := env.OpenPassphrase(pass)
+	reseal := func(b []byte) ([]byte, error) { return crypt.SealPassphrase(pass, b) }
+	return data, reseal, err
+}
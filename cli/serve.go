@@ -2,15 +2,195 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/serboupal/note/rest"
 )
 
 func serve(args []string) {
 	fl := flag.NewFlagSet("serve", flag.ContinueOnError)
-	usg := ""
+	usg := "[--log-level LEVEL --log-format text|json --rate N --burst N --max-auth-failures N --lockout D --trusted-proxies CIDR,... --max-note-size N --store URL] [user|webhook ...]"
+	level := fl.String("log-level", "info", "log level: debug, info, warn or error")
+	format := fl.String("log-format", "text", "log format: text or json")
+	rate := fl.Float64("rate", 10, "requests per second per client address and token, 0 disables")
+	burst := fl.Int("burst", 20, "requests allowed at once above the rate")
+	fails := fl.Int("max-auth-failures", 5, "failed authentications before a client address is locked out, 0 disables")
+	lock := fl.Duration("lockout", 15*time.Minute, "lockout duration")
+	proxies := fl.String("trusted-proxies", "", "comma separated addresses or networks of proxies whose X-Forwarded-For is used as client address")
+	size := fl.Int64("max-note-size", rest.DefaultMaxNoteSize, "largest note accepted, in bytes")
+	store := fl.String("store", "", "URL of the store of each user with {user} in place of the user name")
 	fl.Usage = func() { usage(fl, nil, usg) }
 	fl.Parse(args)
 
-	rest.Serve()
+	switch fl.Arg(0) {
+	case "":
+		cfg := rest.Config{
+			LogFormat:       *format,
+			RateLimit:       *rate,
+			RateBurst:       *burst,
+			MaxAuthFailures: *fails,
+			Lockout:         *lock,
+			MaxNoteSize:     *size,
+			Store:           *store,
+		}
+		err := cfg.LogLevel.UnmarshalText([]byte(*level))
+		if err != nil {
+			errExit(err.Error())
+		}
+		if *format != "text" && *format != "json" {
+			errExit("invalid log format " + *format)
+		}
+		cfg.TrustedProxies, err = parsePrefixes(*proxies)
+		if err != nil {
+			errExit("invalid trusted proxies: " + err.Error())
+		}
+		rest.Serve(cfg)
+	case "user":
+		serveUser(fl.Args()[1:])
+	case "webhook":
+		serveWebhook(fl.Args()[1:])
+	default:
+		fl.Usage()
+	}
+}
+
+// parsePrefixes parses a comma separated list of networks, single addresses
+// are networks of one address.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var r []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			r = append(r, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, p.Masked())
+	}
+	return r, nil
+}
+
+func serveUser(args []string) {
+	fl := flag.NewFlagSet("serve user", flag.ContinueOnError)
+	usg := "add|rm NAME | list"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	switch fl.Arg(0) {
+	case "add":
+		if fl.NArg() != 2 {
+			fl.Usage()
+		}
+		tkn, err := rest.AddUser(fl.Arg(1))
+		if err != nil {
+			errExit(err.Error())
+		}
+		fmt.Println(tkn)
+	case "rm":
+		if fl.NArg() != 2 {
+			fl.Usage()
+		}
+		err := rest.RemoveUser(fl.Arg(1))
+		if err != nil {
+			errExit(err.Error())
+		}
+	case "list":
+		users, err := rest.Users()
+		if err != nil {
+			errExit(err.Error())
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "NAME\tDATE\n")
+		for _, v := range users {
+			fmt.Fprintf(w, "%s\t%s\n", v.Name, v.Date.Format(time.RFC822))
+		}
+		w.Flush()
+	default:
+		fl.Usage()
+	}
+}
+
+func serveWebhook(args []string) {
+	fl := flag.NewFlagSet("serve webhook", flag.ContinueOnError)
+	user := fl.String("user", "", "only send changes of this user notes")
+	secret := fl.String("secret", "", "secret used to sign payloads, generated if empty")
+	usg := "add [--user NAME --secret S] URL | rm ID | list | log"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	pos := parseInterspersed(fl, args)
+
+	cmd := ""
+	if len(pos) > 0 {
+		cmd = pos[0]
+	}
+	switch cmd {
+	case "add":
+		if len(pos) != 2 {
+			fl.Usage()
+		}
+		h, err := rest.AddWebhook(pos[1], *user, *secret)
+		if err != nil {
+			errExit(err.Error())
+		}
+		fmt.Printf("%s %s\n", h.Id, h.Secret)
+	case "rm":
+		if len(pos) != 2 {
+			fl.Usage()
+		}
+		err := rest.RemoveWebhook(pos[1])
+		if err != nil {
+			errExit(err.Error())
+		}
+	case "list":
+		hooks, err := rest.Webhooks()
+		if err != nil {
+			errExit(err.Error())
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tUSER\tURL\tDATE\n")
+		for _, v := range hooks {
+			u := v.User
+			if u == "" {
+				u = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Id, u, v.URL, v.Date.Format(time.RFC822))
+		}
+		w.Flush()
+	case "log":
+		log, err := rest.Deliveries()
+		if err != nil {
+			errExit(err.Error())
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "DELIVERY\tHOOK\tEVENT\tNAME\tSTATUS\tATTEMPTS\tDATE\n")
+		for _, v := range log {
+			status := "failed"
+			if v.Ok() {
+				status = "ok"
+			}
+			if v.Status != 0 {
+				status += fmt.Sprintf(" (%d)", v.Status)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", v.Id, v.Hook, v.Event, v.Name,
+				status, v.Attempts, v.Date.Format(time.RFC822))
+		}
+		w.Flush()
+	default:
+		fl.Usage()
+	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/serboupal/note/note"
+)
+
+type sharedLister interface {
+	Shared() ([]note.Note, error)
+}
+
+func share(args []string) {
+	fl := flag.NewFlagSet("share", flag.ContinueOnError)
+	ro := fl.Bool("ro", false, "share read-only")
+	group := fl.Bool("group", false, "NAME is a group, share all its notes")
+	rm := fl.Bool("rm", false, "stop sharing")
+	usg := "[options] [NAME USER]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	pos := parseInterspersed(fl, args)
+
+	s, ok := direct().(note.Sharer)
+	if !ok {
+		errExit("backend does not support sharing")
+	}
+
+	if len(pos) == 0 {
+		grants, err := s.Grants()
+		if err != nil {
+			errExit(err.Error())
+		}
+		printGrants(grants)
+		return
+	}
+	if len(pos) != 2 {
+		fl.Usage()
+	}
+
+	g := note.Grant{User: pos[1], Scope: note.ScopeWrite}
+	if *ro {
+		g.Scope = note.ScopeRead
+	}
+	if *group {
+		g.Group = pos[0]
+	} else {
+		g.Name = pos[0]
+	}
+
+	var err error
+	if *rm {
+		err = s.Unshare(g)
+	} else {
+		err = s.Share(g)
+	}
+	if err != nil {
+		errExit(err.Error())
+	}
+}
+
+func printGrants(grants []note.Grant) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tGROUP\tUSER\tSCOPE\n")
+	for _, v := range grants {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Name, v.Group, v.User, v.Scope)
+	}
+	w.Flush()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+func sign(args []string) {
+	fl := flag.NewFlagSet("sign", flag.ContinueOnError)
+	usg := "NAME"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	if fl.NArg() != 1 {
+		fl.Usage()
+	}
+	n, err := backend.Get(fl.Arg(0))
+	if err != nil {
+		errExit(err.Error())
+	}
+	signNote(&n)
+}
+
+// signNote signs the current revision of n with our signing key.
+func signNote(n *note.Note) {
+	s, ok := direct().(note.Signer)
+	if !ok {
+		errExit("backend does not support signatures")
+	}
+	priv, err := signingKey(true)
+	if err != nil {
+		errExit(err.Error())
+	}
+	sig, err := note.Sign(n, author(), priv)
+	if err != nil {
+		errExit(err.Error())
+	}
+	err = s.AddSignature(n.Name, sig)
+	if err != nil {
+		errExit(err.Error())
+	}
+}
+
+func verify(args []string) {
+	fl := flag.NewFlagSet("verify", flag.ContinueOnError)
+	usg := "NAME"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	if fl.NArg() != 1 {
+		fl.Usage()
+	}
+	s, ok := direct().(note.Signer)
+	if !ok {
+		errExit("backend does not support signatures")
+	}
+	n, err := backend.Get(fl.Arg(0))
+	if err != nil {
+		errExit(err.Error())
+	}
+	sigs, err := s.Signatures(fl.Arg(0))
+	if err != nil {
+		errExit(err.Error())
+	}
+	trusted, err := trustedKeys()
+	if err != nil {
+		errExit(err.Error())
+	}
+
+	id := fmt.Sprintf("%x", sha256.Sum256(n.Data))
+	good := false
+	old := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "AUTHOR\tKEY\tDATE\tSTATUS\n")
+	for _, v := range sigs {
+		if v.Id != id {
+			old++
+			continue
+		}
+		status := "trusted"
+		if err := v.Verify(); err != nil {
+			status = "invalid"
+		} else if trusted[v.Key] == "" {
+			status = "unknown key"
+		} else if trusted[v.Key] != v.Author {
+			status = "key of " + trusted[v.Key]
+		} else {
+			good = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Author, v.Key, v.Date.Format(time.RFC822), status)
+	}
+	w.Flush()
+	if old > 0 {
+		fmt.Printf("%d signatures of older revisions\n", old)
+	}
+	if !good {
+		errExit("no trusted signature for the current revision")
+	}
+}
+
+func trust(args []string) {
+	fl := flag.NewFlagSet("trust", flag.ContinueOnError)
+	usg := "[AUTHOR KEY]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	switch fl.NArg() {
+	case 0:
+		trusted, err := trustedKeys()
+		if err != nil {
+			errExit(err.Error())
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "AUTHOR\tKEY\n")
+		for k, v := range trusted {
+			fmt.Fprintf(w, "%s\t%s\n", v, k)
+		}
+		w.Flush()
+	case 2:
+		if strings.ContainsAny(fl.Arg(0), " \n,") {
+			errExit("invalid author")
+		}
+		if _, err := note.ParseKey(fl.Arg(1)); err != nil {
+			errExit(err.Error())
+		}
+		f, err := os.OpenFile(filepath.Join(app.configDir, "trusted"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			errExit(err.Error())
+		}
+		defer f.Close()
+		_, err = fmt.Fprintf(f, "%s %s\n", fl.Arg(0), fl.Arg(1))
+		if err != nil {
+			errExit(err.Error())
+		}
+	default:
+		fl.Usage()
+	}
+}
+
+// trustedKeys returns the authors of trusted keys by key.
+func trustedKeys() (map[string]string, error) {
+	r := map[string]string{}
+	f, err := os.Open(filepath.Join(app.configDir, "trusted"))
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, key, ok := strings.Cut(scanner.Text(), " ")
+		if ok {
+			r[key] = name
+		}
+	}
+	return r, scanner.Err()
+}
+
+// signingKey returns our signing key, creating it if create is set.
+func signingKey(create bool) (ed25519.PrivateKey, error) {
+	path := filepath.Join(app.configDir, "ed25519.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid signing key %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(priv.Seed())+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// author is the name signatures are made as, NOTE_AUTHOR or the user name.
+func author() string {
+	if app.cfg.author != "" {
+		return app.cfg.author
+	}
+	u, err := user.Current()
+	if err != nil {
+		errExit("set NOTE_AUTHOR to sign notes")
+	}
+	return u.Username
+}
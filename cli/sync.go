@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/serboupal/note/internal/syncer"
+)
+
+func sync(args []string) {
+	fl := flag.NewFlagSet("sync", flag.ContinueOnError)
+	keep := fl.String("keep", "", "resolve conflict of NAME keeping `local` or `remote` version")
+	usg := "[--keep local|remote NAME]"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	pos := parseInterspersed(fl, args)
+
+	if app.cfg.remote == "" || app.cfg.token == "" {
+		errExit("sync needs NOTE_HTTPS_URL and NOTE_HTTPS_TOKEN")
+	}
+	if app.newE2E(nil) != nil {
+		// the content ids of both sides would never match
+		errExit("sync is not supported with NOTE_E2E")
+	}
+	// the local side is the store in use, unless it is a server too
+	store := app.storeURL()
+	if isServer(store) {
+		store = app.localStore()
+	}
+	l := app.open(store)
+	r := app.open(app.cfg.remote)
+	for _, b := range []interface{ Init() error }{l, r} {
+		if err := b.Init(); err != nil {
+			errExit(err.Error())
+		}
+	}
+	if v, ok := l.(locker); ok {
+		unlockStore(v)
+	}
+	// each pair of store and server has its own state
+	state := app.configPath("sync", store, app.cfg.remote)
+	s := syncer.New(l, r, app.cfg.remote, state)
+
+	if *keep != "" {
+		if len(pos) != 1 {
+			fl.Usage()
+		}
+		side := syncer.Local
+		switch *keep {
+		case "local":
+		case "remote":
+			side = syncer.Remote
+		default:
+			fl.Usage()
+		}
+		err := s.Resolve(pos[0], side)
+		if err != nil {
+			errExit(err.Error())
+		}
+		return
+	}
+	if len(pos) != 0 {
+		fl.Usage()
+	}
+
+	changes, err := s.Sync()
+	conflicts := 0
+	for _, c := range changes {
+		fmt.Printf("%s\t%s\n", c.Action, c.Name)
+		if c.Action == syncer.Conflict {
+			conflicts++
+		}
+	}
+	if err != nil {
+		errExit(err.Error())
+	}
+	if conflicts > 0 {
+		errExit(fmt.Sprintf("%d conflicts, resolve them with: sync --keep local|remote NAME", conflicts))
+	}
+}
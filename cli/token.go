@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/serboupal/note/note"
+	"github.com/serboupal/note/rest"
+)
+
+// token manages API tokens. With a remote backend it acts on the tokens of
+// the authenticated user, otherwise on the tokens stored by this host's
+// server.
+func token(args []string) {
+	fl := flag.NewFlagSet("token", flag.ContinueOnError)
+	scope := fl.String("scope", "rw", "token scope: ro, rw or admin")
+	expires := fl.Duration("expires", 0, "token lifetime, never expires if zero")
+	user := fl.String("user", "", "user owning the token when managing local server")
+	usg := "[options] create | revoke ID | list"
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	tm, ok := direct().(note.TokenManager)
+	switch fl.Arg(0) {
+	case "create":
+		sc, err := note.ParseScope(*scope)
+		if err != nil {
+			errExit(err.Error())
+		}
+		var t note.Token
+		if ok {
+			t, err = tm.CreateToken(sc, *expires)
+		} else {
+			if *user == "" {
+				errExit("--user is required to create a token on the local server")
+			}
+			t, err = rest.CreateToken(*user, sc, *expires)
+		}
+		if err != nil {
+			errExit(err.Error())
+		}
+		fmt.Println(t.Secret)
+	case "revoke":
+		if fl.NArg() != 2 {
+			fl.Usage()
+		}
+		var err error
+		if ok {
+			err = tm.RevokeToken(fl.Arg(1))
+		} else {
+			err = rest.RevokeToken(fl.Arg(1))
+		}
+		if err != nil {
+			errExit(err.Error())
+		}
+	case "list":
+		var tokens []note.Token
+		var err error
+		if ok {
+			tokens, err = tm.Tokens()
+		} else {
+			tokens, err = rest.Tokens(*user)
+		}
+		if err != nil {
+			errExit(err.Error())
+		}
+		printTokens(tokens)
+	default:
+		fl.Usage()
+	}
+}
+
+func printTokens(tokens []note.Token) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tUSER\tSCOPE\tDATE\tEXPIRES\n")
+	for _, v := range tokens {
+		exp := "never"
+		if v.Expires != nil {
+			exp = v.Expires.Format(time.RFC822)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Id, v.User, v.Scope, v.Date.Format(time.RFC822), exp)
+	}
+	w.Flush()
+}
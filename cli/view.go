@@ -3,11 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+
+	"github.com/serboupal/note/markdown"
 )
 
 func view(args []string) {
 	fl := flag.NewFlagSet("view", flag.ContinueOnError)
-	usg := "NAME"
+	html := fl.Bool("html", false, "render Markdown note as an HTML page")
+	usg := "[options] NAME"
 	fl.Usage = func() { usage(fl, nil, usg) }
 	fl.Parse(args)
 
@@ -19,5 +23,15 @@ func view(args []string) {
 	if err != nil {
 		errExit(err.Error())
 	}
+	if n.Encrypted {
+		n.Data, _, err = openData(n)
+		if err != nil {
+			errExit(err.Error())
+		}
+	}
+	if *html {
+		os.Stdout.Write(markdown.Document(n.Name, n.Data))
+		return
+	}
 	fmt.Printf("%s", string(n.Data))
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/serboupal/note/internal/https"
+	"github.com/serboupal/note/note"
+)
+
+func watch(args []string) {
+	fl := flag.NewFlagSet("watch", flag.ContinueOnError)
+	usg := ""
+	fl.Usage = func() { usage(fl, nil, usg) }
+	fl.Parse(args)
+
+	w, ok := direct().(note.Watcher)
+	if !ok {
+		errExit("watch is only available with a remote server")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for ctx.Err() == nil {
+		err := w.Watch(ctx, func(e note.Event) {
+			fmt.Printf("%s\t%s\t%s\n", e.Date.Local().Format(time.DateTime), e.Type, e.Name)
+		})
+		var se *https.StatusError
+		if errors.As(err, &se) {
+			errExit(err.Error())
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// the connection dropped, reconnect
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -6,6 +6,7 @@ package dbline
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"os"
 	"strings"
 )
@@ -67,23 +68,32 @@ func AppendEntry[T dbStructElem](path string, item T) error {
 }
 
 func Save[T dbStructElem](path string, data []T) error {
+	os.WriteFile(path, Encode(data), 0600)
+	return nil
+}
+
+// Encode returns the lines of data, for databases not kept in files.
+func Encode[T dbStructElem](data []T) []byte {
 	buf := new(bytes.Buffer)
 	for _, v := range data {
 		buf.WriteString(v.String() + "\n")
 	}
-	os.WriteFile(path, buf.Bytes(), 0600)
-	return nil
+	return buf.Bytes()
 }
 
 func Open[P dbStructElemPointer[T], T any](path string) ([]T, error) {
-	var ret []T
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
+	return Decode[P](f)
+}
 
-	scanner := bufio.NewScanner(f)
+// Decode returns the entries read from the lines of r.
+func Decode[P dbStructElemPointer[T], T any](r io.Reader) ([]T, error) {
+	var ret []T
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		var e P = new(T)
 		err := e.Parse(scanner.Text())
@@ -0,0 +1,230 @@
+// package agent keeps store keys in memory for a while, so encrypted stores
+// are unlocked once instead of on every command. Clients talk to it over a
+// unix socket in a directory only its user can open, one line per request:
+//
+//	set ID KEY TTL
+//	get ID
+//	lock
+//
+// with TTL a duration like 90s or 500ms, and it answers ok, the key, or err
+// followed by a message. The agent exits once it holds no keys.
+package agent
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// startGrace is how long a new agent waits for its first key.
+const startGrace = 10 * time.Second
+
+var (
+	ErrNoAgent = errors.New("agent not running")
+	ErrNoKey   = errors.New("key not cached")
+)
+
+type agent struct {
+	mu     sync.Mutex
+	keys   map[string][]byte
+	timers map[string]*time.Timer
+	done   chan struct{}
+}
+
+// Serve runs the agent on sock until it holds no keys. It keeps running when
+// the terminal that started it is closed. The directory of sock is made
+// private before listening, so other users can't reach the socket at all.
+func Serve(sock string) error {
+	signal.Ignore(syscall.SIGHUP)
+	dir := filepath.Dir(sock)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		return err
+	}
+	os.Remove(sock)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sock)
+	err = os.Chmod(sock, 0600)
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	a := &agent{keys: map[string][]byte{}, timers: map[string]*time.Timer{}, done: make(chan struct{})}
+	go func() {
+		time.Sleep(startGrace)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.exitIfEmpty()
+	}()
+	go func() {
+		<-a.done
+		l.Close()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			select {
+			case <-a.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go a.handle(c)
+	}
+}
+
+func (a *agent) handle(c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(c, a.do(strings.Fields(line)))
+}
+
+func (a *agent) do(req []string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(req) == 0 {
+		return "err empty request"
+	}
+	switch {
+	case req[0] == "set" && len(req) == 4:
+		key, err := hex.DecodeString(req[2])
+		if err != nil {
+			return "err invalid key"
+		}
+		ttl, err := time.ParseDuration(req[3])
+		if err != nil || ttl <= 0 {
+			return "err invalid ttl"
+		}
+		id := req[1]
+		if t, ok := a.timers[id]; ok {
+			t.Stop()
+		}
+		var t *time.Timer
+		t = time.AfterFunc(ttl, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if a.timers[id] != t {
+				// set again meanwhile
+				return
+			}
+			a.forget(id)
+			a.exitIfEmpty()
+		})
+		a.keys[id] = key
+		a.timers[id] = t
+		return "ok"
+	case req[0] == "get" && len(req) == 2:
+		key, ok := a.keys[req[1]]
+		if !ok {
+			return "err " + ErrNoKey.Error()
+		}
+		return hex.EncodeToString(key)
+	case req[0] == "lock" && len(req) == 1:
+		for id := range a.keys {
+			a.forget(id)
+		}
+		a.exitIfEmpty()
+		return "ok"
+	}
+	return "err invalid request"
+}
+
+func (a *agent) forget(id string) {
+	if t, ok := a.timers[id]; ok {
+		t.Stop()
+	}
+	clear(a.keys[id])
+	delete(a.keys, id)
+	delete(a.timers, id)
+}
+
+func (a *agent) exitIfEmpty() {
+	if len(a.keys) > 0 {
+		return
+	}
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+}
+
+// Running reports if an agent answers on sock.
+func Running(sock string) bool {
+	c, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// Set caches key as id for ttl.
+func Set(sock, id string, key []byte, ttl time.Duration) error {
+	_, err := request(sock, fmt.Sprintf("set %s %x %s", id, key, ttl))
+	return err
+}
+
+// Get returns the key cached as id.
+func Get(sock, id string) ([]byte, error) {
+	r, err := request(sock, "get "+id)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(r)
+}
+
+// Lock makes the agent forget every key and exit.
+func Lock(sock string) error {
+	_, err := request(sock, "lock")
+	return err
+}
+
+func request(sock, req string) (string, error) {
+	c, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return "", ErrNoAgent
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = fmt.Fprintln(c, req)
+	if err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if msg, ok := strings.CutPrefix(line, "err "); ok {
+		if msg == ErrNoKey.Error() {
+			return "", ErrNoKey
+		}
+		return "", errors.New(msg)
+	}
+	return line, nil
+}
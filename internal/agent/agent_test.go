@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// start runs an agent and returns its socket and the result of Serve.
+func start(t *testing.T) (string, <-chan error) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "agent", "sock")
+	done := make(chan error, 1)
+	go func() { done <- Serve(sock) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for !Running(sock) {
+		if time.Now().After(deadline) {
+			t.Fatal("agent not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { Lock(sock) })
+	return sock, done
+}
+
+// exited waits for the agent to stop.
+func exited(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent still running")
+	}
+}
+
+func TestAgent(t *testing.T) {
+	sock, done := start(t)
+	key := bytes.Repeat([]byte{7}, 32)
+	if err := Set(sock, "store", key, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Get(sock, "store")
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("get: %x, %v", got, err)
+	}
+	if _, err := Get(sock, "other"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("get unknown id: %v, want %v", err, ErrNoKey)
+	}
+
+	// keys expire on their own, even under a second
+	if err := Set(sock, "short", key, 100*time.Millisecond); err != nil {
+		t.Fatalf("set sub-second ttl: %v", err)
+	}
+	if _, err := Get(sock, "short"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := Get(sock, "short"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("get expired key: %v, want %v", err, ErrNoKey)
+	}
+	if _, err := Get(sock, "store"); err != nil {
+		t.Errorf("other key forgotten: %v", err)
+	}
+
+	if err := Lock(sock); err != nil {
+		t.Fatal(err)
+	}
+	exited(t, done)
+	if Running(sock) {
+		t.Error("agent running after lock")
+	}
+	if _, err := Get(sock, "store"); !errors.Is(err, ErrNoAgent) {
+		t.Errorf("get after lock: %v, want %v", err, ErrNoAgent)
+	}
+}
+
+func TestAgentExpiry(t *testing.T) {
+	sock, done := start(t)
+	if err := Set(sock, "store", []byte{1}, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// the agent exits with its last key
+	exited(t, done)
+}
+
+func TestAgentPrivate(t *testing.T) {
+	sock, _ := start(t)
+	for path, want := range map[string]os.FileMode{filepath.Dir(sock): 0700, sock: 0600} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s mode %v, want %v", path, fi.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestAgentRequests(t *testing.T) {
+	a := &agent{keys: map[string][]byte{}, timers: map[string]*time.Timer{}, done: make(chan struct{})}
+	for _, c := range []struct {
+		req, want string
+	}{
+		{"", "err empty request"},
+		{"set id zz 1s", "err invalid key"},
+		{"set id 00 1", "err invalid ttl"},
+		{"set id 00 0s", "err invalid ttl"},
+		{"set id 00 -1s", "err invalid ttl"},
+		{"set id 00", "err invalid request"},
+		{"get", "err invalid request"},
+		{"get id", "err " + ErrNoKey.Error()},
+		{"set id 00ff 1m", "ok"},
+		{"get id", "00ff"},
+		{"forget id", "err invalid request"},
+		{"lock", "ok"},
+		{"get id", "err " + ErrNoKey.Error()},
+	} {
+		if got := a.do(strings.Fields(c.req)); got != c.want {
+			t.Errorf("%q: %q, want %q", c.req, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// package cache implements an offline first note.Backend. Every read and
+// write goes through a Local replica; when the remote is unreachable reads are
+// served from the replica and writes are queued in an outbox that is replayed,
+// in order, once the remote answers again.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/note"
+)
+
+// offlineFor is how long the remote is not contacted after a network
+// failure, so every command does not wait for the client timeout.
+const offlineFor = 30 * time.Second
+
+var _ = (note.Backend)(&Cache{})
+
+var (
+	ErrOffline  = errors.New("remote unreachable, note not cached")
+	ErrReplay   = errors.New("queued change rejected by remote")
+	ErrConflict = errors.New("note changed on remote since the change was queued")
+)
+
+// Op is a change queued while offline. Base is the id the note had on the
+// remote before the change, empty if it didn't exist.
+type Op struct {
+	Kind string
+	Name string
+	Base string
+	Date *time.Time
+}
+
+func (o *Op) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s", o.Kind, o.Date.Format(time.DateTime), o.Base, o.Name)
+}
+
+func (o *Op) Parse(s string) error {
+	item := strings.SplitN(s, ",", 4)
+	if len(item) != 4 {
+		return fmt.Errorf("invalid outbox string")
+	}
+
+	ti, err := time.Parse(time.DateTime, item[1])
+	if err != nil {
+		return err
+	}
+	o.Kind = item[0]
+	o.Date = &ti
+	o.Base = item[2]
+	o.Name = item[3]
+	return nil
+}
+
+type Cache struct {
+	remote  note.Backend
+	replica *local.Local
+	dir     string
+}
+
+// NewBackend returns a Cache in front of remote keeping the replica, outbox
+// and state under dir.
+func NewBackend(remote note.Backend, dir string) *Cache {
+	return &Cache{remote: remote, replica: local.NewBackendAt(dir), dir: dir}
+}
+
+func (c *Cache) Init() error {
+	err := c.replica.Init()
+	if err != nil {
+		return err
+	}
+	return c.remote.Init()
+}
+
+func (c *Cache) Create(n *note.Note) error {
+	err := c.replay()
+	if err == nil {
+		err = c.remote.Create(n)
+		if !c.offline(err) {
+			if err != nil {
+				return err
+			}
+			c.cache(*n)
+			return nil
+		}
+	} else if !c.offline(err) {
+		return err
+	}
+
+	if _, err := c.replica.Get(n.Name); err == nil {
+		return note.ErrNoteExist
+	}
+	err = c.replica.Create(n)
+	if err != nil {
+		return err
+	}
+	return c.queue("create", n.Name, "")
+}
+
+func (c *Cache) Get(name string) (note.Note, error) {
+	err := c.replay()
+	if err == nil {
+		n, err := c.remote.Get(name)
+		if !c.offline(err) {
+			if err == nil {
+				c.cache(n)
+			}
+			return n, err
+		}
+	}
+	n, err := c.replica.Get(name)
+	if errors.Is(err, note.ErrNotFound) && c.backingOff() {
+		// the remote may have it
+		return n, fmt.Errorf("%w: %w", ErrOffline, err)
+	}
+	return n, err
+}
+
+func (c *Cache) Update(name string, data []byte) error {
+	err := c.replay()
+	if err == nil {
+		err = c.remote.Update(name, data)
+		if !c.offline(err) {
+			if err != nil {
+				return err
+			}
+			c.replica.Update(name, data)
+			return nil
+		}
+	} else if !c.offline(err) {
+		return err
+	}
+
+	old, err := c.replica.Get(name)
+	if errors.Is(err, note.ErrNotFound) {
+		return ErrOffline
+	}
+	err = c.replica.Update(name, data)
+	if err != nil {
+		return err
+	}
+	return c.queue("update", name, old.Id)
+}
+
+func (c *Cache) Delete(n *note.Note) error {
+	err := c.replay()
+	if err == nil {
+		err = c.remote.Delete(n)
+		if !c.offline(err) {
+			if err != nil {
+				return err
+			}
+			c.uncache(n.Name)
+			return nil
+		}
+	} else if !c.offline(err) {
+		return err
+	}
+
+	base := n.Id
+	if old, err := c.replica.Get(n.Name); err == nil {
+		base = old.Id
+	}
+	err = c.uncache(n.Name)
+	if err != nil {
+		return err
+	}
+	return c.queue("delete", n.Name, base)
+}
+
+func (c *Cache) List(name string) ([]note.Note, error) {
+	err := c.replay()
+	if err == nil {
+		list, err := c.remote.List(name)
+		if !c.offline(err) {
+			return list, err
+		}
+	}
+	return c.replica.List(name)
+}
+
+func (c *Cache) Search(query string) ([]note.Note, error) {
+	err := c.replay()
+	if err == nil {
+		list, err := c.remote.Search(query)
+		if !c.offline(err) {
+			return list, err
+		}
+	}
+	return c.replica.Search(query)
+}
+
+// Outbox returns the changes waiting to be sent to the remote.
+func (c *Cache) Outbox() ([]Op, error) {
+	r, err := dbline.Open[*Op](c.dir + "/outbox")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Drop discards the oldest queued change, used when the remote keeps
+// rejecting it. The note stays in the replica.
+func (c *Cache) Drop() error {
+	ops, err := c.Outbox()
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return c.saveOutbox(ops[1:])
+}
+
+// replay sends queued changes to the remote. It returns the network error if
+// the remote is unreachable, or ErrReplay if the remote rejects a change,
+// which is left at the head of the outbox.
+func (c *Cache) replay() error {
+	if c.backingOff() {
+		return ErrOffline
+	}
+	ops, err := c.Outbox()
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		err := c.send(op)
+		if err != nil {
+			if e := c.saveOutbox(ops[i:]); e != nil {
+				return e
+			}
+			if c.offline(err) {
+				return err
+			}
+			return fmt.Errorf("%w: %s %s: %w", ErrReplay, op.Kind, op.Name, err)
+		}
+	}
+	if len(ops) > 0 {
+		return c.saveOutbox(nil)
+	}
+	return nil
+}
+
+// send applies op to the remote using the current replica content. The
+// change is refused with ErrConflict if the remote note is neither the one it
+// was made on nor already the replica content.
+func (c *Cache) send(op Op) error {
+	n, err := c.replica.Get(op.Name)
+	if errors.Is(err, note.ErrNotFound) {
+		// deleted later, its delete is queued too
+		n = note.Note{}
+	} else if err != nil {
+		return err
+	}
+	cur, err := c.remote.Get(op.Name)
+	if errors.Is(err, note.ErrNotFound) {
+		cur = note.Note{}
+	} else if err != nil && !errors.Is(err, note.ErrIntegrityFail) {
+		return err
+	}
+	if cur.Id == n.Id {
+		return nil
+	}
+	if cur.Id != op.Base {
+		return ErrConflict
+	}
+	switch {
+	case n.Id == "":
+		return c.remote.Delete(&cur)
+	case cur.Id == "":
+		return c.remote.Create(&n)
+	}
+	return c.remote.Update(op.Name, n.Data)
+}
+
+// queue appends a change to the outbox. Changes to a note already queued keep
+// the base of the first one, the remote hasn't seen the ones in between.
+func (c *Cache) queue(kind, name, base string) error {
+	ops, err := c.Outbox()
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(ops, func(o Op) bool { return o.Name == name }); i >= 0 {
+		base = ops[i].Base
+	}
+	ti := time.Now()
+	return dbline.AppendEntry(c.dir+"/outbox", &Op{Kind: kind, Name: name, Base: base, Date: &ti})
+}
+
+func (c *Cache) saveOutbox(ops []Op) error {
+	p := make([]*Op, len(ops))
+	for i := range ops {
+		p[i] = &ops[i]
+	}
+	return dbline.Save(c.dir+"/outbox", p)
+}
+
+// cache stores n in the replica unless it already has it or has pending
+// local changes for it.
+func (c *Cache) cache(n note.Note) {
+	ops, err := c.Outbox()
+	if err != nil || slices.ContainsFunc(ops, func(o Op) bool { return o.Name == n.Name }) {
+		return
+	}
+	old, err := c.replica.Get(n.Name)
+	if err == nil {
+		if old.Id != n.Id {
+			c.replica.Update(n.Name, n.Data)
+		}
+		return
+	}
+	if errors.Is(err, note.ErrNotFound) {
+		cp, err := note.NewNote(n.Name, "", n.Data)
+		if err != nil {
+			return
+		}
+		cp.Tags = n.Tags
+		cp.Groups = n.Groups
+		cp.Encrypted = n.Encrypted
+		c.replica.Create(cp)
+	}
+}
+
+func (c *Cache) uncache(name string) error {
+	n, err := c.replica.Get(name)
+	if errors.Is(err, note.ErrNotFound) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, note.ErrIntegrityFail) {
+		return err
+	}
+	return c.replica.Delete(&n)
+}
+
+// offline reports if err means the remote could not be reached, and if so
+// starts the back off period.
+func (c *Cache) offline(err error) bool {
+	var ue *url.Error
+	if errors.Is(err, ErrOffline) {
+		return true
+	}
+	if !errors.As(err, &ue) {
+		return false
+	}
+	os.WriteFile(c.dir+"/offline", nil, 0600)
+	return true
+}
+
+func (c *Cache) backingOff() bool {
+	fi, err := os.Stat(c.dir + "/offline")
+	if err != nil {
+		return false
+	}
+	if time.Since(fi.ModTime()) < offlineFor {
+		return true
+	}
+	os.Remove(c.dir + "/offline")
+	return false
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+func TestBackend(t *testing.T) {
+	notetest.Run(t, func(t *testing.T) note.Backend {
+		c, _ := newTestCache(t)
+		return c
+	})
+}
+
+// remote is a Local that can be taken offline.
+type remote struct {
+	*local.Local
+	down bool
+}
+
+func (r *remote) err() error {
+	if r.down {
+		return &url.Error{Op: "Get", URL: "https://note", Err: errors.New("connection refused")}
+	}
+	return nil
+}
+
+func (r *remote) Create(n *note.Note) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.Local.Create(n)
+}
+
+func (r *remote) Get(name string) (note.Note, error) {
+	if err := r.err(); err != nil {
+		return note.Note{}, err
+	}
+	return r.Local.Get(name)
+}
+
+func (r *remote) Update(name string, data []byte) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.Local.Update(name, data)
+}
+
+func (r *remote) Delete(n *note.Note) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	return r.Local.Delete(n)
+}
+
+func (r *remote) List(name string) ([]note.Note, error) {
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return r.Local.List(name)
+}
+
+func newTestCache(t *testing.T) (*Cache, *remote) {
+	t.Helper()
+	r := &remote{Local: local.NewBackendAt(t.TempDir())}
+	c := NewBackend(r, t.TempDir())
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return c, r
+}
+
+// online brings r back and ends the back off period of c.
+func online(t *testing.T, c *Cache, r *remote) {
+	t.Helper()
+	r.down = false
+	if err := os.Remove(c.dir + "/offline"); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func create(t *testing.T, b note.Backend, name, data string) {
+	t.Helper()
+	n, err := note.NewNote(name, "", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Create(n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func content(t *testing.T, b note.Backend, name string) string {
+	t.Helper()
+	n, err := b.Get(name)
+	if errors.Is(err, note.ErrNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(n.Data)
+}
+
+func kinds(t *testing.T, c *Cache) []string {
+	t.Helper()
+	ops, err := c.Outbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := []string{}
+	for _, op := range ops {
+		r = append(r, op.Kind+" "+op.Name)
+	}
+	return r
+}
+
+func TestOffline(t *testing.T) {
+	c, r := newTestCache(t)
+	create(t, c, "todo", "a")
+	create(t, c, "old", "x")
+
+	r.down = true
+	if err := c.Update("todo", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	create(t, c, "new", "c")
+	old, err := c.Get("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(&old); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update("todo", []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("missing"); !errors.Is(err, ErrOffline) {
+		t.Errorf("get uncached note offline: %v, want %v", err, ErrOffline)
+	}
+	want := "update todo, create new, delete old, update todo"
+	if got := strings.Join(kinds(t, c), ", "); got != want {
+		t.Errorf("outbox %q, want %q", got, want)
+	}
+	if got := content(t, c, "todo"); got != "d" {
+		t.Errorf("offline todo %q, want d", got)
+	}
+	if got := content(t, r.Local, "todo"); got != "a" {
+		t.Errorf("remote todo %q before replay, want a", got)
+	}
+
+	online(t, c, r)
+	if _, err := c.List(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(t, c); len(got) != 0 {
+		t.Errorf("outbox after replay %v, want empty", got)
+	}
+	for name, want := range map[string]string{"todo": "d", "new": "c", "old": ""} {
+		if got := content(t, r.Local, name); got != want {
+			t.Errorf("remote %s %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReplayConflict(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		note   string
+		local  func(c *Cache) error
+		remote func(r *local.Local) error
+		want   string // replica content kept while the change is queued
+	}{
+		{
+			name:   "update changed",
+			note:   "todo",
+			local:  func(c *Cache) error { return c.Update("todo", []byte("local")) },
+			remote: func(r *local.Local) error { return r.Update("todo", []byte("remote")) },
+			want:   "local",
+		},
+		{
+			name:  "update deleted",
+			note:  "todo",
+			local: func(c *Cache) error { return c.Update("todo", []byte("local")) },
+			remote: func(r *local.Local) error {
+				n, err := r.Get("todo")
+				if err != nil {
+					return err
+				}
+				return r.Delete(&n)
+			},
+			want: "local",
+		},
+		{
+			name: "delete changed",
+			note: "todo",
+			local: func(c *Cache) error {
+				n, err := c.replica.Get("todo")
+				if err != nil {
+					return err
+				}
+				return c.Delete(&n)
+			},
+			remote: func(r *local.Local) error { return r.Update("todo", []byte("remote")) },
+			want:   "",
+		},
+		{
+			name: "create created",
+			note: "other",
+			local: func(c *Cache) error {
+				n, _ := note.NewNote("other", "", []byte("local"))
+				return c.Create(n)
+			},
+			remote: func(r *local.Local) error {
+				n, _ := note.NewNote("other", "", []byte("remote"))
+				return r.Create(n)
+			},
+			want: "local",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, r := newTestCache(t)
+			create(t, c, "todo", "a")
+
+			r.down = true
+			if err := tt.local(c); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.remote(r.Local); err != nil {
+				t.Fatal(err)
+			}
+			online(t, c, r)
+
+			name := tt.note
+			before, _ := r.Local.Get(name)
+			err := c.Update("todo", []byte("next"))
+			if !errors.Is(err, ErrReplay) || !errors.Is(err, ErrConflict) {
+				t.Fatalf("update with conflicting outbox: %v, want %v and %v", err, ErrReplay, ErrConflict)
+			}
+			if got := len(kinds(t, c)); got != 1 {
+				t.Errorf("outbox has %d changes, want the rejected one", got)
+			}
+			after, _ := r.Local.Get(name)
+			if before.Id != after.Id {
+				t.Errorf("rejected change written to remote")
+			}
+			// reads fall back to the replica while the change is pending
+			if got := content(t, c, name); got != tt.want {
+				t.Errorf("get %s %q, want %q", name, got, tt.want)
+			}
+
+			if err := c.Drop(); err != nil {
+				t.Fatal(err)
+			}
+			if got := content(t, c, name); got != content(t, r.Local, name) {
+				t.Errorf("get %s after drop %q, want remote version", name, got)
+			}
+		})
+	}
+}
+
+func TestReplayApplied(t *testing.T) {
+	c, r := newTestCache(t)
+	create(t, c, "todo", "a")
+
+	r.down = true
+	if err := c.Update("todo", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	// the same change made elsewhere is not a conflict
+	if err := r.Local.Update("todo", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	online(t, c, r)
+	if _, err := c.List(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(kinds(t, c)); got != 0 {
+		t.Errorf("outbox has %d changes, want 0", got)
+	}
+}
@@ -0,0 +1,172 @@
+// package crypt seals data with AES-256-GCM using keys derived from
+// passphrases with scrypt.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KeySize  = 32
+	SaltSize = 16
+)
+
+var (
+	ErrDecrypt  = errors.New("decryption failed, wrong key or corrupted data")
+	ErrWrongKey = errors.New("wrong passphrase")
+)
+
+// DeriveKey returns the key for pass and salt.
+func DeriveKey(pass, salt []byte) ([]byte, error) {
+	return scrypt.Key(pass, salt, 1<<15, 8, 1, KeySize)
+}
+
+// NewSalt returns a random salt for DeriveKey.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// Seal encrypts and authenticates plain and authenticates ad, which must be
+// given again to Open. The random nonce is prepended to the result.
+func Seal(key, plain, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, ad), nil
+}
+
+// SealDeterministic is Seal with a nonce derived from the inputs, so equal
+// inputs give equal outputs. It is used when the result must be stable, like
+// names looked up on a server, and only reveals which inputs are equal.
+func SealDeterministic(key, plain, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(sha256.New, SubKey(key, "nonce"))
+	m.Write(ad)
+	m.Write([]byte{0})
+	m.Write(plain)
+	nonce := m.Sum(nil)[:gcm.NonceSize()]
+	return gcm.Seal(nonce, nonce, plain, ad), nil
+}
+
+// SubKey derives an independent key for purpose from key.
+func SubKey(key []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// Open decrypts data sealed by Seal or SealDeterministic.
+func Open(key, data, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyFile keeps the salt of a passphrase derived key and a value sealed with
+// it, to tell wrong passphrases apart. It is stored as one dbline entry.
+type KeyFile struct {
+	Salt  []byte
+	Check []byte
+}
+
+// NewKeyFile returns a KeyFile with a new salt and the key derived from pass.
+func NewKeyFile(pass []byte) (KeyFile, []byte, error) {
+	salt, err := NewSalt()
+	if err != nil {
+		return KeyFile{}, nil, err
+	}
+	key, err := DeriveKey(pass, salt)
+	if err != nil {
+		return KeyFile{}, nil, err
+	}
+	check, err := Seal(key, []byte("note"), nil)
+	if err != nil {
+		return KeyFile{}, nil, err
+	}
+	return KeyFile{Salt: salt, Check: check}, key, nil
+}
+
+func (k *KeyFile) String() string {
+	return fmt.Sprintf("scrypt,%x,%x", k.Salt, k.Check)
+}
+
+func (k *KeyFile) Parse(s string) error {
+	item := strings.Split(strings.TrimSpace(s), ",")
+	if len(item) != 3 || item[0] != "scrypt" {
+		return fmt.Errorf("invalid key file")
+	}
+	salt, err := hex.DecodeString(item[1])
+	if err != nil {
+		return err
+	}
+	check, err := hex.DecodeString(item[2])
+	if err != nil {
+		return err
+	}
+	k.Salt = salt
+	k.Check = check
+	return nil
+}
+
+// Id identifies the key, to cache it.
+func (k *KeyFile) Id() string {
+	return fmt.Sprintf("%x", k.Salt)
+}
+
+// Derive returns the key for pass, or ErrWrongKey.
+func (k *KeyFile) Derive(pass []byte) ([]byte, error) {
+	key, err := DeriveKey(pass, k.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return key, k.Verify(key)
+}
+
+// Verify returns ErrWrongKey if key is not the key of k.
+func (k *KeyFile) Verify(key []byte) error {
+	if _, err := Open(key, k.Check, nil); err != nil {
+		return ErrWrongKey
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestSealOpen(t *testing.T) {
+	key := testKey(1)
+	seals := []struct {
+		name string
+		f    func(key, plain, ad []byte) ([]byte, error)
+	}{
+		{"random nonce", Seal},
+		{"deterministic", SealDeterministic},
+	}
+	for _, s := range seals {
+		for _, plain := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("note"), 1000)} {
+			c, err := s.f(key, plain, []byte("ad"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Open(key, c, []byte("ad"))
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("%s: open %d bytes: %q, %v", s.name, len(plain), got, err)
+			}
+		}
+
+		c, err := s.f(key, []byte("secret"), []byte("ad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		flipped := bytes.Clone(c)
+		flipped[len(flipped)-1] ^= 1
+		tests := []struct {
+			name   string
+			key, c []byte
+			ad     []byte
+		}{
+			{"wrong key", testKey(2), c, []byte("ad")},
+			{"wrong ad", key, c, []byte("other")},
+			{"no ad", key, c, nil},
+			{"tampered", key, flipped, []byte("ad")},
+			{"truncated", key, c[:len(c)-1], []byte("ad")},
+			{"short", key, c[:4], []byte("ad")},
+			{"empty", key, nil, []byte("ad")},
+		}
+		for _, tt := range tests {
+			if _, err := Open(tt.key, tt.c, tt.ad); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("%s: %s: %v, want %v", s.name, tt.name, err, ErrDecrypt)
+			}
+		}
+	}
+	if _, err := Seal([]byte("short"), []byte("x"), nil); err == nil {
+		t.Error("sealed with a short key")
+	}
+}
+
+func TestSealDeterministic(t *testing.T) {
+	key := testKey(1)
+	seal := func(key []byte, plain, ad string) string {
+		c, err := SealDeterministic(key, []byte(plain), []byte(ad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(c)
+	}
+	if seal(key, "a", "x") != seal(key, "a", "x") {
+		t.Error("same inputs sealed differently")
+	}
+	for _, c := range [][2]string{
+		{seal(key, "a", "x"), seal(key, "b", "x")},
+		{seal(key, "a", "x"), seal(key, "a", "y")},
+		{seal(key, "a", "x"), seal(testKey(2), "a", "x")},
+		// the separator keeps the split between ad and plain
+		{seal(key, "bc", "a"), seal(key, "c", "ab")},
+	} {
+		if c[0] == c[1] {
+			t.Errorf("different inputs sealed as %x", c[0])
+		}
+	}
+
+	r1, _ := Seal(key, []byte("a"), nil)
+	r2, _ := Seal(key, []byte("a"), nil)
+	if bytes.Equal(r1, r2) {
+		t.Error("Seal reused a nonce")
+	}
+}
+
+func TestSubKey(t *testing.T) {
+	a, b := SubKey(testKey(1), "data"), SubKey(testKey(1), "name")
+	if len(a) != KeySize || bytes.Equal(a, b) || !bytes.Equal(a, SubKey(testKey(1), "data")) {
+		t.Errorf("sub keys %x and %x", a, b)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	k, key, err := NewKeyFile([]byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.Salt) != SaltSize || len(key) != KeySize {
+		t.Fatalf("salt %x key %x", k.Salt, key)
+	}
+
+	parsed := KeyFile{}
+	if err := parsed.Parse(k.String() + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Id() != k.Id() || !bytes.Equal(parsed.Check, k.Check) {
+		t.Errorf("parsed %+v, want %+v", parsed, k)
+	}
+
+	got, err := parsed.Derive([]byte("pass"))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("derive: %x, %v", got, err)
+	}
+	if _, err := parsed.Derive([]byte("wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("derive wrong passphrase: %v, want %v", err, ErrWrongKey)
+	}
+	if err := parsed.Verify(key); err != nil {
+		t.Error(err)
+	}
+	if err := parsed.Verify(testKey(1)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("verify wrong key: %v, want %v", err, ErrWrongKey)
+	}
+
+	for _, s := range []string{"", "scrypt,00", "argon2,00,00", "scrypt,zz,00", "scrypt,00,zz"} {
+		if err := (&KeyFile{}).Parse(s); err == nil {
+			t.Errorf("Parse(%q) gave no error", s)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// envelopes are text, so they can be stored as note contents:
+//
+//	note-secret v1 scrypt SALT
+//	CIPHERTEXT
+//
+//	note-secret v1 x25519 RECIPIENT EPHEMERAL
+//	CIPHERTEXT
+//
+// with every value base64 encoded.
+const envelopeMagic = "note-secret v1 "
+
+// publicPrefix starts the text form of recipient public keys.
+const publicPrefix = "x25519:"
+
+var (
+	ErrNotEnvelope = errors.New("data is not encrypted")
+	ErrPublicKey   = errors.New("invalid public key")
+)
+
+var b64 = base64.RawStdEncoding
+
+// Envelope is the header of sealed data.
+type Envelope struct {
+	// Kind is scrypt for passphrases or x25519 for recipient keys.
+	Kind      string
+	Salt      []byte
+	Recipient *ecdh.PublicKey
+	Ephemeral *ecdh.PublicKey
+	sealed    []byte
+}
+
+// SealPassphrase seals plain with a key derived from pass.
+func SealPassphrase(pass, plain []byte) ([]byte, error) {
+	salt, err := NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := DeriveKey(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Seal(key, plain, []byte(envelopeMagic))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%sscrypt %s\n%s\n", envelopeMagic, b64.EncodeToString(salt), b64.EncodeToString(c))), nil
+}
+
+// SealTo seals plain so only the owner of the private key of to can open it.
+func SealTo(to *ecdh.PublicKey, plain []byte) ([]byte, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sharedKey(eph, to, eph.PublicKey(), to)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Seal(key, plain, []byte(envelopeMagic))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%sx25519 %s %s\n%s\n", envelopeMagic, b64.EncodeToString(to.Bytes()),
+		b64.EncodeToString(eph.PublicKey().Bytes()), b64.EncodeToString(c))), nil
+}
+
+// ParseEnvelope reads the header of data sealed by SealPassphrase or SealTo.
+func ParseEnvelope(data []byte) (Envelope, error) {
+	head, body, ok := bytes.Cut(data, []byte("\n"))
+	if !ok || !bytes.HasPrefix(head, []byte(envelopeMagic)) {
+		return Envelope{}, ErrNotEnvelope
+	}
+	item := strings.Fields(string(head[len(envelopeMagic):]))
+	sealed, err := b64.DecodeString(strings.TrimSpace(string(body)))
+	if err != nil || len(item) == 0 {
+		return Envelope{}, ErrNotEnvelope
+	}
+
+	e := Envelope{Kind: item[0], sealed: sealed}
+	switch {
+	case e.Kind == "scrypt" && len(item) == 2:
+		e.Salt, err = b64.DecodeString(item[1])
+	case e.Kind == "x25519" && len(item) == 3:
+		e.Recipient, err = parsePublic(item[1])
+		if err == nil {
+			e.Ephemeral, err = parsePublic(item[2])
+		}
+	default:
+		err = ErrNotEnvelope
+	}
+	if err != nil {
+		return Envelope{}, ErrNotEnvelope
+	}
+	return e, nil
+}
+
+// OpenPassphrase opens a scrypt envelope.
+func (e *Envelope) OpenPassphrase(pass []byte) ([]byte, error) {
+	if e.Kind != "scrypt" {
+		return nil, ErrNotEnvelope
+	}
+	key, err := DeriveKey(pass, e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := Open(key, e.sealed, []byte(envelopeMagic))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plain, nil
+}
+
+// OpenWith opens an x25519 envelope with the private key of its recipient.
+func (e *Envelope) OpenWith(priv *ecdh.PrivateKey) ([]byte, error) {
+	if e.Kind != "x25519" {
+		return nil, ErrNotEnvelope
+	}
+	if !priv.PublicKey().Equal(e.Recipient) {
+		return nil, fmt.Errorf("sealed for another key %s", FormatPublic(e.Recipient))
+	}
+	key, err := sharedKey(priv, e.Ephemeral, e.Ephemeral, e.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	return Open(key, e.sealed, []byte(envelopeMagic))
+}
+
+func sharedKey(priv *ecdh.PrivateKey, peer, eph, to *ecdh.PublicKey) ([]byte, error) {
+	secret, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	return SubKey(secret, "note-secret"+string(eph.Bytes())+string(to.Bytes())), nil
+}
+
+// FormatPublic returns the text form of pub.
+func FormatPublic(pub *ecdh.PublicKey) string {
+	return publicPrefix + base64.RawURLEncoding.EncodeToString(pub.Bytes())
+}
+
+// ParsePublic reads a key returned by FormatPublic.
+func ParsePublic(s string) (*ecdh.PublicKey, error) {
+	v, ok := strings.CutPrefix(strings.TrimSpace(s), publicPrefix)
+	if !ok {
+		return nil, ErrPublicKey
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, ErrPublicKey
+	}
+	pub, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, ErrPublicKey
+	}
+	return pub, nil
+}
+
+func parsePublic(s string) (*ecdh.PublicKey, error) {
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
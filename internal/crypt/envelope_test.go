@@ -0,0 +1,158 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newPrivate(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestPassphraseEnvelope(t *testing.T) {
+	plain := []byte("the secret\n")
+	data, err := SealPassphrase([]byte("pass"), plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), envelopeMagic+"scrypt ") {
+		t.Errorf("envelope starts with %q", data)
+	}
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.OpenPassphrase([]byte("pass"))
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("open: %q, %v", got, err)
+	}
+	if _, err := e.OpenPassphrase([]byte("wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("open wrong passphrase: %v, want %v", err, ErrWrongKey)
+	}
+	if _, err := e.OpenWith(newPrivate(t)); !errors.Is(err, ErrNotEnvelope) {
+		t.Errorf("open with a key: %v, want %v", err, ErrNotEnvelope)
+	}
+}
+
+func TestRecipientEnvelope(t *testing.T) {
+	priv := newPrivate(t)
+	pub, err := ParsePublic(FormatPublic(priv.PublicKey()))
+	if err != nil || !pub.Equal(priv.PublicKey()) {
+		t.Fatalf("public key read back as %v, %v", pub, err)
+	}
+
+	plain := []byte("for your eyes only")
+	data, err := SealTo(pub, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != "x25519" || !e.Recipient.Equal(pub) {
+		t.Errorf("envelope %+v", e)
+	}
+	got, err := e.OpenWith(priv)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("open: %q, %v", got, err)
+	}
+	if _, err := e.OpenWith(newPrivate(t)); err == nil {
+		t.Error("opened with another key")
+	}
+	if _, err := e.OpenPassphrase([]byte("pass")); !errors.Is(err, ErrNotEnvelope) {
+		t.Errorf("open with a passphrase: %v, want %v", err, ErrNotEnvelope)
+	}
+
+	again, err := SealTo(pub, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, data) {
+		t.Error("ephemeral key reused")
+	}
+}
+
+// TestEnvelopeTampered checks that changing any part of an envelope makes it
+// fail to open.
+func TestEnvelopeTampered(t *testing.T) {
+	priv := newPrivate(t)
+	sealed, err := SealTo(priv.PublicKey(), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, body, _ := strings.Cut(string(sealed), "\n")
+	item := strings.Fields(head)
+	other := b64.EncodeToString(newPrivate(t).PublicKey().Bytes())
+	body = strings.TrimSpace(body)
+	flipped := []byte(body)
+	flipped[len(flipped)/2] ^= 'A' ^ 'B'
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"other ephemeral", strings.Join(append(item[:3:3], item[3], other), " ") + "\n" + body + "\n"},
+		{"ciphertext", head + "\n" + string(flipped) + "\n"},
+	}
+	for _, tt := range tests {
+		e, err := ParseEnvelope([]byte(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := e.OpenWith(priv); err == nil {
+			t.Errorf("%s: opened", tt.name)
+		}
+	}
+}
+
+func TestParseEnvelope(t *testing.T) {
+	key := b64.EncodeToString(newPrivate(t).PublicKey().Bytes())
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"plain text", "hello\nworld\n"},
+		{"no body", envelopeMagic + "scrypt AAAA"},
+		{"unknown kind", envelopeMagic + "rsa AAAA\nAAAA\n"},
+		{"no kind", envelopeMagic + "\nAAAA\n"},
+		{"scrypt without salt", envelopeMagic + "scrypt\nAAAA\n"},
+		{"x25519 without ephemeral", envelopeMagic + "x25519 " + key + "\nAAAA\n"},
+		{"bad key", envelopeMagic + "x25519 AAAA " + key + "\nAAAA\n"},
+		{"bad body", envelopeMagic + "scrypt AAAA\n!!!\n"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseEnvelope([]byte(tt.data)); !errors.Is(err, ErrNotEnvelope) {
+			t.Errorf("%s: %v, want %v", tt.name, err, ErrNotEnvelope)
+		}
+	}
+}
+
+func TestParsePublic(t *testing.T) {
+	pub := newPrivate(t).PublicKey()
+	for _, s := range []string{
+		"",
+		"x25519:",
+		"x25519:!!!",
+		"x25519:AAAA",
+		strings.TrimPrefix(FormatPublic(pub), publicPrefix),
+		"ed25519:" + strings.TrimPrefix(FormatPublic(pub), publicPrefix),
+	} {
+		if _, err := ParsePublic(s); !errors.Is(err, ErrPublicKey) {
+			t.Errorf("ParsePublic(%q): %v, want %v", s, err, ErrPublicKey)
+		}
+	}
+	if got, err := ParsePublic(" " + FormatPublic(pub) + "\n"); err != nil || !got.Equal(pub) {
+		t.Errorf("ParsePublic with spaces: %v, %v", got, err)
+	}
+}
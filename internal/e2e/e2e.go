@@ -0,0 +1,461 @@
+// package e2e encrypts notes before they reach another backend, so a remote
+// server only stores ciphertext. Contents, and optionally names, are sealed
+// deterministically: the same note always gives the same ciphertext, which
+// keeps names addressable and lets the server detect unchanged updates.
+//
+// The salt of the key lives in the reserved note KeyNote. Tags and groups
+// stay in clear, the server needs groups to share notes.
+package e2e
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/serboupal/note/internal/cache"
+	"github.com/serboupal/note/internal/crypt"
+	"github.com/serboupal/note/note"
+)
+
+// KeyNote is the note holding the crypt.KeyFile of the store.
+const KeyNote = ".e2e"
+
+// namePrefix marks encrypted names and magic encrypted contents, so notes
+// stored before encryption was set up can be told apart and migrated.
+const namePrefix = "e2e."
+
+var magic = []byte("NE2E\x01")
+
+var (
+	ErrLocked       = errors.New("notes are end-to-end encrypted, run note unlock")
+	ErrNoKey        = errors.New("no end-to-end key on the server, run note init --encrypt")
+	ErrKeyOffline   = errors.New("end-to-end key not fetched yet, connect to the server once")
+	ErrKeyExist     = errors.New("end-to-end key already on the server")
+	ErrReservedName = errors.New("note name is reserved")
+	ErrUnsealed     = errors.New("note stored without end-to-end encryption, run note migrate")
+)
+
+var _ = (note.Backend)(&E2E{})
+
+type E2E struct {
+	inner   note.Backend
+	names   bool
+	keyPath string
+	data    []byte
+	name    []byte
+}
+
+// NewBackend returns a backend encrypting notes stored in inner, and their
+// names if names is set. A copy of the key file is kept at keyPath so the
+// key can be checked offline.
+func NewBackend(inner note.Backend, names bool, keyPath string) *E2E {
+	return &E2E{inner: inner, names: names, keyPath: keyPath}
+}
+
+// Init also fetches the key file when it isn't kept yet, so it is there once
+// offline.
+func (e *E2E) Init() error {
+	err := e.inner.Init()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(e.keyPath); err == nil {
+		return nil
+	}
+	_, err = e.keyFile()
+	if err != nil && !errors.Is(err, ErrNoKey) && !errors.Is(err, ErrKeyOffline) {
+		return err
+	}
+	return nil
+}
+
+func (e *E2E) keyFile() (crypt.KeyFile, error) {
+	k := crypt.KeyFile{}
+	if data, err := os.ReadFile(e.keyPath); err == nil {
+		return k, k.Parse(string(data))
+	}
+
+	n, err := e.inner.Get(KeyNote)
+	if errors.Is(err, cache.ErrOffline) {
+		return k, ErrKeyOffline
+	}
+	if errors.Is(err, note.ErrNotFound) {
+		return k, ErrNoKey
+	}
+	if err != nil {
+		return k, err
+	}
+	err = k.Parse(string(n.Data))
+	if err != nil {
+		return k, err
+	}
+	return k, os.WriteFile(e.keyPath, n.Data, 0600)
+}
+
+// Encrypted reports if the server has a key, notes can't be read without it.
+// The kept key file answers without the server.
+func (e *E2E) Encrypted() bool {
+	_, err := e.keyFile()
+	return !errors.Is(err, ErrNoKey)
+}
+
+// KeyId identifies the key, to cache it.
+func (e *E2E) KeyId() (string, error) {
+	k, err := e.keyFile()
+	if err != nil {
+		return "", err
+	}
+	return k.Id(), nil
+}
+
+// DeriveKey returns the key for pass.
+func (e *E2E) DeriveKey(pass []byte) ([]byte, error) {
+	k, err := e.keyFile()
+	if err != nil {
+		return nil, err
+	}
+	return k.Derive(pass)
+}
+
+// Unlock makes notes readable with key.
+func (e *E2E) Unlock(key []byte) error {
+	k, err := e.keyFile()
+	if err != nil {
+		return err
+	}
+	if err := k.Verify(key); err != nil {
+		return err
+	}
+	e.data = crypt.SubKey(key, "data")
+	e.name = crypt.SubKey(key, "name")
+	return nil
+}
+
+// Encrypt stores a new key derived from pass on the server and returns it.
+// Notes already on the server are left as they are until Migrate.
+func (e *E2E) Encrypt(pass []byte) ([]byte, error) {
+	if e.Encrypted() {
+		return nil, ErrKeyExist
+	}
+	k, key, err := crypt.NewKeyFile(pass)
+	if err != nil {
+		return nil, err
+	}
+	n, err := note.NewNote(KeyNote, "", []byte(k.String()))
+	if err != nil {
+		return nil, err
+	}
+	err = e.inner.Create(n)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(e.keyPath, n.Data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return key, e.Unlock(key)
+}
+
+func (e *E2E) Create(n *note.Note) error {
+	c, err := e.seal(*n)
+	if err != nil {
+		return err
+	}
+	return e.inner.Create(&c)
+}
+
+func (e *E2E) Get(name string) (note.Note, error) {
+	if name == KeyNote {
+		return note.Note{}, ErrReservedName
+	}
+	sealed, err := e.sealName(name)
+	if err != nil {
+		return note.Note{}, err
+	}
+	c, err := e.inner.Get(sealed)
+	if err != nil {
+		return note.Note{}, err
+	}
+	return e.open(c)
+}
+
+func (e *E2E) Update(name string, data []byte) error {
+	if name == KeyNote {
+		return ErrReservedName
+	}
+	if e.data == nil {
+		return ErrLocked
+	}
+	sealed, err := e.sealName(name)
+	if err != nil {
+		return err
+	}
+	c, err := e.sealData(name, data)
+	if err != nil {
+		return err
+	}
+	return e.inner.Update(sealed, c)
+}
+
+func (e *E2E) Delete(n *note.Note) error {
+	if n.Name == KeyNote {
+		return ErrReservedName
+	}
+	sealed, err := e.sealName(n.Name)
+	if err != nil {
+		return err
+	}
+	// the server keeps the note under the id of its ciphertext
+	c, err := e.inner.Get(sealed)
+	if err != nil {
+		return err
+	}
+	if n.Id != "" {
+		clear, err := e.open(c)
+		if err != nil {
+			return err
+		}
+		if clear.Id != n.Id {
+			return note.ErrNotFound
+		}
+	}
+	return e.inner.Delete(&c)
+}
+
+// List filters names on the client when they are encrypted. Ids are the ids
+// of the ciphertext stored on the server.
+func (e *E2E) List(name string) ([]note.Note, error) {
+	if e.data == nil {
+		return nil, ErrLocked
+	}
+	filter := name
+	if e.names {
+		filter = ""
+	}
+	list, err := e.inner.List(filter)
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Note{}
+	for _, n := range list {
+		if n.Name == KeyNote {
+			continue
+		}
+		n.Name, err = e.openName(n.Name)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(n.Name, name) {
+			r = append(r, n)
+		}
+	}
+	return r, nil
+}
+
+// Search fetches and decrypts every note, the server can't search
+// ciphertext.
+func (e *E2E) Search(query string) ([]note.Note, error) {
+	list, err := e.List("")
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Note{}
+	for _, v := range list {
+		if v.Encrypted {
+			continue
+		}
+		n, err := e.Get(v.Name)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(strings.ToLower(string(n.Data)), strings.ToLower(query)) {
+			r = append(r, n)
+		}
+	}
+	return r, nil
+}
+
+// seal returns the note sent to the server for n.
+func (e *E2E) seal(n note.Note) (note.Note, error) {
+	if n.Name == KeyNote {
+		return note.Note{}, ErrReservedName
+	}
+	if e.data == nil {
+		return note.Note{}, ErrLocked
+	}
+	data, err := e.sealData(n.Name, n.Data)
+	if err != nil {
+		return note.Note{}, err
+	}
+	name, err := e.sealName(n.Name)
+	if err != nil {
+		return note.Note{}, err
+	}
+	c, err := note.NewNote(name, "", data)
+	if err != nil {
+		return note.Note{}, err
+	}
+	c.Tags = n.Tags
+	c.Groups = n.Groups
+	c.Encrypted = n.Encrypted
+	return *c, nil
+}
+
+// open returns the note read from the server as c, with the id of its clear
+// content. Contents not sealed are refused, the server could have written
+// them.
+func (e *E2E) open(c note.Note) (note.Note, error) {
+	if e.data == nil {
+		return note.Note{}, ErrLocked
+	}
+	name, err := e.openName(c.Name)
+	if err != nil {
+		return note.Note{}, err
+	}
+	sealed, ok := bytes.CutPrefix(c.Data, magic)
+	if !ok {
+		return note.Note{}, fmt.Errorf("%w: %s", ErrUnsealed, name)
+	}
+	data, err := crypt.Open(e.data, sealed, []byte(name))
+	if err != nil {
+		return note.Note{}, err
+	}
+	n := c
+	n.Name = name
+	n.Data = data
+	n.Size = len(data)
+	n.Id = fmt.Sprintf("%x", sha256.Sum256(data))
+	return n, nil
+}
+
+func (e *E2E) sealData(name string, data []byte) ([]byte, error) {
+	c, err := crypt.SealDeterministic(e.data, data, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clip(magic), c...), nil
+}
+
+func (e *E2E) sealName(name string) (string, error) {
+	if !e.names {
+		return name, nil
+	}
+	if e.name == nil {
+		return "", ErrLocked
+	}
+	c, err := crypt.SealDeterministic(e.name, []byte(name), nil)
+	if err != nil {
+		return "", err
+	}
+	return namePrefix + base64.RawURLEncoding.EncodeToString(c), nil
+}
+
+// openName decrypts encrypted names. When names are encrypted the ones that
+// aren't are refused, otherwise they are returned as they are.
+func (e *E2E) openName(name string) (string, error) {
+	plain, err := e.decryptName(name)
+	if err != nil && e.names {
+		return "", err
+	}
+	if err != nil {
+		return name, nil
+	}
+	return plain, nil
+}
+
+func (e *E2E) decryptName(name string) (string, error) {
+	s, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsealed, name)
+	}
+	if e.name == nil {
+		return "", ErrLocked
+	}
+	c, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnsealed, name)
+	}
+	plain, err := crypt.Open(e.name, c, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnsealed, name)
+	}
+	return string(plain), nil
+}
+
+// Migrate seals the notes stored before end-to-end encryption was set up, or
+// before names were encrypted, and returns how many were. Their content
+// comes from the server as it is, check them first.
+func (e *E2E) Migrate() (int, error) {
+	if e.data == nil {
+		return 0, ErrLocked
+	}
+	list, err := e.inner.List("")
+	if errors.Is(err, note.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, v := range list {
+		if v.Name == KeyNote {
+			continue
+		}
+		c, err := e.inner.Get(v.Name)
+		if err != nil {
+			return count, err
+		}
+		_, err = e.open(c)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrUnsealed) {
+			return count, err
+		}
+		err = e.migrate(c)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// migrate replaces the note c, stored with a clear name or content, with its
+// sealed version.
+func (e *E2E) migrate(c note.Note) error {
+	n := c
+	if plain, err := e.decryptName(c.Name); err == nil {
+		n.Name = plain
+	} else if errors.Is(err, ErrLocked) {
+		return err
+	}
+	if sealed, ok := bytes.CutPrefix(c.Data, magic); ok {
+		data, err := crypt.Open(e.data, sealed, []byte(n.Name))
+		if err != nil {
+			return err
+		}
+		n.Data = data
+	}
+	s, err := e.seal(n)
+	if err != nil {
+		return err
+	}
+	if s.Name == c.Name {
+		return e.inner.Update(c.Name, s.Data)
+	}
+	err = e.inner.Create(&s)
+	if err != nil {
+		return err
+	}
+	err = e.inner.Delete(&c)
+	if err != nil {
+		return err
+	}
+	return e.moveGrants(c.Name, s.Name)
+}
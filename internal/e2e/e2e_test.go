@@ -0,0 +1,189 @@
+package e2e
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+func TestBackend(t *testing.T) {
+	modes(t, func(t *testing.T, names bool) {
+		notetest.Run(t, func(t *testing.T) note.Backend {
+			e, _ := newTestE2E(t, names)
+			return e
+		})
+	})
+}
+
+// newTestE2E returns an unlocked E2E over a Local holding the notes of
+// legacy, stored before encryption was set up.
+func newTestE2E(t *testing.T, names bool, legacy ...string) (*E2E, *local.Local) {
+	t.Helper()
+	inner := local.NewBackendAt(t.TempDir())
+	e := NewBackend(inner, names, filepath.Join(t.TempDir(), "key"))
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range legacy {
+		create(t, inner, name, "clear "+name)
+	}
+	if _, err := e.Encrypt([]byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+	return e, inner
+}
+
+func create(t *testing.T, b note.Backend, name, data string) {
+	t.Helper()
+	n, err := note.NewNote(name, "", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Create(n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func modes(t *testing.T, f func(t *testing.T, names bool)) {
+	for _, names := range []bool{false, true} {
+		t.Run(map[bool]string{false: "Contents", true: "Names"}[names], func(t *testing.T) {
+			f(t, names)
+		})
+	}
+}
+
+func TestUnsealed(t *testing.T) {
+	modes(t, func(t *testing.T, names bool) {
+		e, inner := newTestE2E(t, names, "old")
+		// with names encrypted the clear name isn't looked up at all
+		want := ErrUnsealed
+		if names {
+			want = note.ErrNotFound
+		}
+		if _, err := e.Get("old"); !errors.Is(err, want) {
+			t.Errorf("get note stored in clear: %v, want %v", err, want)
+		}
+		_, err := e.List("")
+		if names && !errors.Is(err, ErrUnsealed) {
+			t.Errorf("list clear name: %v, want %v", err, ErrUnsealed)
+		}
+		if !names && err != nil {
+			t.Errorf("list: %v", err)
+		}
+
+		// content written by the server under a sealed name
+		create(t, e, "todo", "mine")
+		sealed, err := e.sealName("todo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := inner.Update(sealed, []byte("forged")); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := e.Get("todo"); !errors.Is(err, ErrUnsealed) {
+			t.Errorf("get forged content: %q, %v, want %v", n.Data, err, ErrUnsealed)
+		}
+
+		// ciphertext of another note
+		create(t, e, "other", "theirs")
+		c, err := e.sealData("other", []byte("theirs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := inner.Update(sealed, c); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := e.Get("todo"); err == nil {
+			t.Errorf("get content of another note: %q", n.Data)
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	modes(t, func(t *testing.T, names bool) {
+		e, inner := newTestE2E(t, names, "old", "older")
+		create(t, e, "new", "sealed")
+		if err := inner.Share(note.Grant{Name: "old", User: "bob", Scope: note.ScopeRead}); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := e.Migrate()
+		if err != nil || n != 2 {
+			t.Fatalf("migrate: %d, %v, want 2", n, err)
+		}
+		for name, want := range map[string]string{"old": "clear old", "older": "clear older", "new": "sealed"} {
+			got, err := e.Get(name)
+			if err != nil || string(got.Data) != want {
+				t.Errorf("get %s after migrate: %q, %v", name, got.Data, err)
+			}
+		}
+		list, err := inner.List("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range list {
+			if v.Name == KeyNote {
+				continue
+			}
+			c, err := inner.Get(v.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(c.Data) == "clear old" || (names && v.Name == "old") {
+				t.Errorf("%s left in clear", v.Name)
+			}
+		}
+		grants, err := e.Grants()
+		if err != nil || len(grants) != 1 || grants[0].Name != "old" {
+			t.Errorf("grants after migrate: %v, %v", grants, err)
+		}
+
+		if n, err := e.Migrate(); err != nil || n != 0 {
+			t.Errorf("migrate again: %d, %v, want 0", n, err)
+		}
+	})
+}
+
+func TestInit(t *testing.T) {
+	inner := local.NewBackendAt(t.TempDir())
+	e := NewBackend(inner, false, filepath.Join(t.TempDir(), "key"))
+	// no key yet
+	if err := e.Init(); err != nil {
+		t.Fatalf("init without key: %v", err)
+	}
+	create(t, inner, KeyNote, "not a key file")
+	if err := e.Init(); err == nil {
+		t.Error("init with an invalid key note succeeded")
+	}
+}
+
+func TestDeleteStale(t *testing.T) {
+	modes(t, func(t *testing.T, names bool) {
+		e, _ := newTestE2E(t, names)
+		create(t, e, "todo", "a")
+		old, err := e.Get("todo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Update("todo", []byte("b")); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Delete(&old); !errors.Is(err, note.ErrNotFound) {
+			t.Errorf("delete stale revision: %v, want %v", err, note.ErrNotFound)
+		}
+		cur, err := e.Get("todo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Delete(&cur); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Get("todo"); !errors.Is(err, note.ErrNotFound) {
+			t.Errorf("get after delete: %v", err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+var (
+	ErrNotPublic   = errors.New("end-to-end encrypted notes can't be published, the server can't read them")
+	ErrUnsupported = errors.New("not supported by the remote store")
+)
+
+var _ = (note.Linker)(&E2E{})
+var _ = (note.Sharer)(&E2E{})
+var _ = (note.Watcher)(&E2E{})
+var _ = (note.TokenManager)(&E2E{})
+
+// Over returns a copy of e, with the same keys, storing notes in inner. It
+// gives the server features to a store that is cached locally.
+func (e *E2E) Over(inner note.Backend) *E2E {
+	c := *e
+	c.inner = inner
+	return &c
+}
+
+// PlainName returns the name of the note stored as name, or name if it
+// can't be decrypted.
+func (e *E2E) PlainName(name string) string {
+	if n, err := e.openName(name); err == nil {
+		return n
+	}
+	return name
+}
+
+// CreateLink always fails, a public page would show the ciphertext.
+func (e *E2E) CreateLink(name string, ttl time.Duration) (note.Link, error) {
+	return note.Link{}, ErrNotPublic
+}
+
+func (e *E2E) RevokeLink(id string) error {
+	l, ok := e.inner.(note.Linker)
+	if !ok {
+		return ErrUnsupported
+	}
+	return l.RevokeLink(id)
+}
+
+// Links returns the links made before encryption was set up.
+func (e *E2E) Links() ([]note.Link, error) {
+	l, ok := e.inner.(note.Linker)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	links, err := l.Links()
+	for i := range links {
+		links[i].Name = e.PlainName(links[i].Name)
+	}
+	return links, err
+}
+
+func (e *E2E) Share(g note.Grant) error {
+	s, ok := e.inner.(note.Sharer)
+	if !ok {
+		return ErrUnsupported
+	}
+	g, err := e.sealGrant(g)
+	if err != nil {
+		return err
+	}
+	return s.Share(g)
+}
+
+func (e *E2E) Unshare(g note.Grant) error {
+	s, ok := e.inner.(note.Sharer)
+	if !ok {
+		return ErrUnsupported
+	}
+	g, err := e.sealGrant(g)
+	if err != nil {
+		return err
+	}
+	return s.Unshare(g)
+}
+
+func (e *E2E) Grants() ([]note.Grant, error) {
+	s, ok := e.inner.(note.Sharer)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	grants, err := s.Grants()
+	for i := range grants {
+		grants[i].Name = e.PlainName(grants[i].Name)
+	}
+	return grants, err
+}
+
+// Shared returns the notes other users shared with us. Their names are
+// sealed with the key of the owner, they are returned as they are.
+func (e *E2E) Shared() ([]note.Note, error) {
+	s, ok := e.inner.(interface{ Shared() ([]note.Note, error) })
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return s.Shared()
+}
+
+// Watch calls f with the names of the changed notes decrypted.
+func (e *E2E) Watch(ctx context.Context, f func(note.Event)) error {
+	w, ok := e.inner.(note.Watcher)
+	if !ok {
+		return ErrUnsupported
+	}
+	return w.Watch(ctx, func(ev note.Event) {
+		if ev.Name == KeyNote {
+			return
+		}
+		ev.Name = e.PlainName(ev.Name)
+		f(ev)
+	})
+}
+
+// CreateToken and the other token methods go to the server as they are.
+func (e *E2E) CreateToken(scope note.Scope, ttl time.Duration) (note.Token, error) {
+	t, ok := e.inner.(note.TokenManager)
+	if !ok {
+		return note.Token{}, ErrUnsupported
+	}
+	return t.CreateToken(scope, ttl)
+}
+
+func (e *E2E) RevokeToken(id string) error {
+	t, ok := e.inner.(note.TokenManager)
+	if !ok {
+		return ErrUnsupported
+	}
+	return t.RevokeToken(id)
+}
+
+func (e *E2E) Tokens() ([]note.Token, error) {
+	t, ok := e.inner.(note.TokenManager)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return t.Tokens()
+}
+
+// sealGrant returns g with the note name as stored on the server.
+func (e *E2E) sealGrant(g note.Grant) (note.Grant, error) {
+	if g.Name == "" {
+		return g, nil
+	}
+	sealed, err := e.sealName(g.Name)
+	if err != nil {
+		return g, err
+	}
+	g.Name = sealed
+	return g, nil
+}
+
+// moveGrants makes the grants of the note stored as from apply to to.
+func (e *E2E) moveGrants(from, to string) error {
+	s, ok := e.inner.(note.Sharer)
+	if !ok {
+		return nil
+	}
+	grants, err := s.Grants()
+	if err != nil {
+		return err
+	}
+	for _, g := range grants {
+		if g.Name != from {
+			continue
+		}
+		err := s.Unshare(g)
+		if err != nil {
+			return err
+		}
+		g.Name = to
+		err = s.Share(g)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
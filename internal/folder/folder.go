@@ -0,0 +1,334 @@
+package folder
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/serboupal/note/note"
+)
+
+var (
+	ErrInvalidPath = errors.New("invalid path")
+)
+
+// ext is the extension of note files, other files are ignored.
+const ext = ".md"
+
+// Folder keeps every note as a Markdown file named after it, name.md, with
+// the / of names as subfolders, so notes can be read and changed with any
+// editor. Tags, groups and the encrypted flag go in the YAML front matter,
+// which is not part of the note content. Folders starting with a dot are
+// ignored.
+type Folder struct {
+	dir string
+}
+
+var _ = (note.Backend)(&Folder{})
+var _ = (note.Tagger)(&Folder{})
+
+func init() {
+	note.Register("md", open)
+}
+
+// open returns a Folder backend for the folder at the path of u, like
+// md:///home/me/notes, or md:notes for a relative path.
+func open(u *url.URL) (note.Backend, error) {
+	p := u.Path
+	if u.Opaque != "" {
+		p = u.Opaque
+	}
+	if p == "" || (u.Host != "" && u.Host != "localhost") {
+		return nil, ErrInvalidPath
+	}
+	return NewBackend(filepath.FromSlash(p)), nil
+}
+
+// NewBackend returns a Folder backend for the notes in dir, created by Init
+// if missing.
+func NewBackend(dir string) *Folder {
+	return &Folder{dir: dir}
+}
+
+func (f *Folder) Init() error {
+	return os.MkdirAll(f.dir, os.ModePerm)
+}
+
+func (f *Folder) Create(n *note.Note) error {
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	p, err := f.path(n.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil {
+		return note.ErrNoteExist
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	fm := frontMatter{tags: n.Tags, groups: n.Groups, encrypted: n.Encrypted}
+	return writeFile(p, fm.join(n.Data))
+}
+
+func (f *Folder) Get(name string) (note.Note, error) {
+	p, err := f.path(name)
+	if err != nil {
+		return note.Note{}, err
+	}
+	return f.load(name, p, true)
+}
+
+func (f *Folder) Update(name string, data []byte) error {
+	newNote, err := note.NewNote(name, "", data)
+	if err != nil {
+		return err
+	}
+	p, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	file, err := f.read(p)
+	if err != nil {
+		return err
+	}
+	fm, content := splitFile(file)
+	if fmt.Sprintf("%x", sha256.Sum256(content)) == newNote.Id {
+		return note.ErrNotModified
+	}
+	return writeFile(p, fm.join(data))
+}
+
+func (f *Folder) Delete(n *note.Note) error {
+	p, err := f.path(n.Name)
+	if err != nil {
+		return err
+	}
+	// only the version read by the caller is deleted, not one changed since
+	file, err := f.read(p)
+	if err != nil {
+		return err
+	}
+	if _, content := splitFile(file); fmt.Sprintf("%x", sha256.Sum256(content)) != n.Id {
+		return note.ErrNotFound
+	}
+	if err := os.Remove(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return note.ErrNotFound
+		}
+		return err
+	}
+	// remove the folders left empty
+	for d := filepath.Dir(p); d != filepath.Clean(f.dir); d = filepath.Dir(d) {
+		if os.Remove(d) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *Folder) List(name string) ([]note.Note, error) {
+	if note.InvalidName(name) {
+		return nil, note.ErrInvalidName
+	}
+	return f.walk(false, func(n *note.Note) bool {
+		return strings.Contains(n.Name, name)
+	})
+}
+
+func (f *Folder) Search(query string) ([]note.Note, error) {
+	query = strings.ToLower(query)
+	return f.walk(true, func(n *note.Note) bool {
+		return !n.Encrypted && strings.Contains(strings.ToLower(string(n.Data)), query)
+	})
+}
+
+func (f *Folder) Tag(name string, tags []string) error {
+	n := note.Note{Name: name, Tags: tags}
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	p, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	file, err := f.read(p)
+	if err != nil {
+		return err
+	}
+	fm, content := splitFile(file)
+	fm.tags = tags
+	return writeFile(p, fm.join(content))
+}
+
+// Ready checks that the folder can be read and written.
+func (f *Folder) Ready() error {
+	if _, err := os.ReadDir(f.dir); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".ready")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Stats returns the number of notes and the bytes used by their files.
+func (f *Folder) Stats() (int, int64, error) {
+	var c int
+	var size int64
+	err := filepath.WalkDir(f.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := f.name(p, d); !ok {
+			return skipHidden(p, d, f.dir)
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c++
+		size += fi.Size()
+		return nil
+	})
+	return c, size, err
+}
+
+// path returns the file of the note name. Names can't have empty parts or
+// go through hidden folders.
+func (f *Folder) path(name string) (string, error) {
+	if name == "" || note.InvalidName(name) || strings.Contains(name, "\\") {
+		return "", note.ErrInvalidName
+	}
+	parts := strings.Split(name, "/")
+	for i, v := range parts {
+		if v == "" || (i < len(parts)-1 && strings.HasPrefix(v, ".")) {
+			return "", note.ErrInvalidName
+		}
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(name)+ext), nil
+}
+
+// name returns the note kept in the file p, if it is a note file.
+func (f *Folder) name(p string, d fs.DirEntry) (string, bool) {
+	if !d.Type().IsRegular() || !strings.HasSuffix(p, ext) {
+		return "", false
+	}
+	rel, err := filepath.Rel(f.dir, p)
+	if err != nil {
+		return "", false
+	}
+	name := strings.TrimSuffix(filepath.ToSlash(rel), ext)
+	if name == "" || note.InvalidName(name) {
+		return "", false
+	}
+	return name, true
+}
+
+func (f *Folder) read(p string) ([]byte, error) {
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, note.ErrNotFound
+	}
+	return data, err
+}
+
+// load returns the note name kept in the file p, with its content when
+// withData is set.
+func (f *Folder) load(name, p string, withData bool) (note.Note, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return note.Note{}, note.ErrNotFound
+		}
+		return note.Note{}, err
+	}
+	file, err := f.read(p)
+	if err != nil {
+		return note.Note{}, err
+	}
+	fm, content := splitFile(file)
+	date := fi.ModTime()
+	n := note.Note{
+		Id:        fmt.Sprintf("%x", sha256.Sum256(content)),
+		Name:      name,
+		Date:      &date,
+		Tags:      fm.tags,
+		Groups:    fm.groups,
+		Encrypted: fm.encrypted,
+	}
+	if withData {
+		n.Data = content
+	}
+	return n, nil
+}
+
+// walk returns the notes match accepts, newest first. match sees the note
+// content, which is kept in the result when withData is set.
+func (f *Folder) walk(withData bool, match func(n *note.Note) bool) ([]note.Note, error) {
+	r := []note.Note{}
+	err := filepath.WalkDir(f.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, ok := f.name(p, d)
+		if !ok {
+			return skipHidden(p, d, f.dir)
+		}
+		n, err := f.load(name, p, true)
+		if err != nil {
+			return err
+		}
+		if !match(&n) {
+			return nil
+		}
+		if !withData {
+			n.Data = nil
+		}
+		r = append(r, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Date.After(*r[j].Date) })
+	return r, nil
+}
+
+// skipHidden skips the folders starting with a dot, like .git.
+func skipHidden(p string, d fs.DirEntry, root string) error {
+	if d.IsDir() && p != root && strings.HasPrefix(d.Name(), ".") {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// writeFile replaces the file p at once, editors watching it never see it
+// half written.
+func writeFile(p string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".note-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -0,0 +1,18 @@
+package folder
+
+import (
+	"testing"
+
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+func TestBackend(t *testing.T) {
+	notetest.Run(t, func(t *testing.T) note.Backend {
+		f := NewBackend(t.TempDir())
+		if err := f.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	})
+}
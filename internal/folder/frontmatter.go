@@ -0,0 +1,147 @@
+package folder
+
+import (
+	"bytes"
+	"strings"
+)
+
+const fence = "---"
+
+// frontMatter is the YAML header of a note file. Only tags, groups and
+// encrypted are understood, lines of other keys are kept as they are.
+type frontMatter struct {
+	tags      []string
+	groups    []string
+	encrypted bool
+	other     []string
+}
+
+// splitFile returns the front matter of a note file and the note content
+// that follows it.
+func splitFile(data []byte) (frontMatter, []byte) {
+	fm := frontMatter{}
+	rest, ok := bytes.CutPrefix(data, []byte(fence+"\n"))
+	if !ok {
+		return fm, data
+	}
+	var lines []string
+	for {
+		line, next, found := bytes.Cut(rest, []byte("\n"))
+		if !found && len(next) == 0 && string(line) != fence && string(line) != "..." {
+			// not closed, so it was not front matter
+			return frontMatter{}, data
+		}
+		rest = next
+		if s := strings.TrimRight(string(line), " \r"); s == fence || s == "..." {
+			break
+		}
+		lines = append(lines, string(line))
+	}
+	fm.parse(lines)
+	return fm, rest
+}
+
+func (fm *frontMatter) parse(lines []string) {
+	var list *[]string
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+		if item, ok := strings.CutPrefix(trim, "- "); ok && (line != trim || list != nil) {
+			if list != nil {
+				*list = append(*list, unquote(item))
+			} else {
+				fm.other = append(fm.other, line)
+			}
+			continue
+		}
+		if line != trim && list == nil {
+			// continuation of a key we don't know
+			fm.other = append(fm.other, line)
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		list = nil
+		switch strings.TrimSpace(key) {
+		case "tags":
+			fm.tags = parseList(value)
+			list = &fm.tags
+		case "groups":
+			fm.groups = parseList(value)
+			list = &fm.groups
+		case "encrypted":
+			fm.encrypted = value == "true"
+		default:
+			fm.other = append(fm.other, line)
+		}
+		if value != "" {
+			list = nil
+		}
+	}
+}
+
+// join returns the note file of content with fm as header. There is no
+// header when fm is empty, unless content would be read as one.
+func (fm *frontMatter) join(content []byte) []byte {
+	empty := len(fm.tags) == 0 && len(fm.groups) == 0 && !fm.encrypted && len(fm.other) == 0
+	if empty && !bytes.HasPrefix(content, []byte(fence+"\n")) {
+		return content
+	}
+	var b bytes.Buffer
+	b.WriteString(fence + "\n")
+	if len(fm.tags) > 0 {
+		b.WriteString("tags: " + formatList(fm.tags) + "\n")
+	}
+	if len(fm.groups) > 0 {
+		b.WriteString("groups: " + formatList(fm.groups) + "\n")
+	}
+	if fm.encrypted {
+		b.WriteString("encrypted: true\n")
+	}
+	for _, l := range fm.other {
+		b.WriteString(l + "\n")
+	}
+	b.WriteString(fence + "\n")
+	b.Write(content)
+	return b.Bytes()
+}
+
+// parseList reads a flow list, [a, b], or a single value. An empty value
+// starts a block list read by parse.
+func parseList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return []string{unquote(s)}
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	var r []string
+	for _, v := range strings.Split(s, ",") {
+		if v = unquote(strings.TrimSpace(v)); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func formatList(l []string) string {
+	q := make([]string, len(l))
+	for i, v := range l {
+		q[i] = v
+		if strings.ContainsAny(v, "[]{}#&*!|>'%@`") {
+			q[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		}
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package folder
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestJoinSplit(t *testing.T) {
+	contents := []string{
+		"",
+		"plain text\n",
+		"---\n",
+		"---",
+		"----\nnot a fence\n",
+		"---\ntitle: mine\n---\nbody\n",
+		"---\n---\n",
+		"---\nnot closed\n",
+		"...\n",
+	}
+	headers := []frontMatter{
+		{},
+		{tags: []string{"a", "b c"}},
+		{groups: []string{"team"}, encrypted: true},
+		{other: []string{"title: x"}},
+	}
+	for _, c := range contents {
+		for _, fm := range headers {
+			file := fm.join([]byte(c))
+			got, content := splitFile(file)
+			if string(content) != c {
+				t.Errorf("content %q with %+v: read back %q from %q", c, fm, content, file)
+			}
+			if !slices.Equal(got.tags, fm.tags) || !slices.Equal(got.groups, fm.groups) ||
+				got.encrypted != fm.encrypted || !slices.Equal(got.other, fm.other) {
+				t.Errorf("content %q: front matter %+v read back as %+v", c, fm, got)
+			}
+		}
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		fm      frontMatter
+		content string
+	}{
+		{"none", "text\n", frontMatter{}, "text\n"},
+		{"flow lists", "---\ntags: [a, 'b&c', \"d\"]\ngroups: team\n---\nbody", frontMatter{
+			tags: []string{"a", "b&c", "d"}, groups: []string{"team"},
+		}, "body"},
+		{"block list", "---\ntags:\n  - a\n  - b\nencrypted: true\n---\nbody", frontMatter{
+			tags: []string{"a", "b"}, encrypted: true,
+		}, "body"},
+		{"other keys kept", "---\ntitle: x\nauthors:\n  - me\n---\n", frontMatter{
+			other: []string{"title: x", "authors:", "  - me"},
+		}, ""},
+		{"dots close", "---\ntags: a\n...\nbody", frontMatter{tags: []string{"a"}}, "body"},
+		{"not closed", "---\ntags: a\n", frontMatter{}, "---\ntags: a\n"},
+		{"empty", "---\n---\n---\nx\n", frontMatter{}, "---\nx\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, content := splitFile([]byte(tt.file))
+			if string(content) != tt.content {
+				t.Errorf("content %q, want %q", content, tt.content)
+			}
+			if !slices.Equal(fm.tags, tt.fm.tags) || !slices.Equal(fm.groups, tt.fm.groups) ||
+				fm.encrypted != tt.fm.encrypted || !slices.Equal(fm.other, tt.fm.other) {
+				t.Errorf("front matter %+v, want %+v", fm, tt.fm)
+			}
+		})
+	}
+}
@@ -0,0 +1,624 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+var (
+	ErrInvalidPath = errors.New("invalid path")
+	ErrNoGit       = errors.New("git binary not found")
+)
+
+// metaDir holds the labels of the notes, the rest of the files tracked by
+// the repository are notes named by their path.
+const metaDir = ".note"
+
+// Git keeps every note as a file of a git repository, named by its path,
+// and commits each change. Tags, groups and the encrypted flag live in
+// .note/labels.
+type Git struct {
+	dir string
+	env []string
+
+	// mu serializes changes, git can't run two commits on one repository
+	mu sync.Mutex
+}
+
+var _ = (note.Backend)(&Git{})
+var _ = (note.Tagger)(&Git{})
+
+// labels are the metadata of a note that can't be kept in its file.
+type labels struct {
+	Name      string
+	Tags      []string
+	Groups    []string
+	Encrypted bool
+}
+
+func (l *labels) String() string {
+	enc := ""
+	if l.Encrypted {
+		enc = "encrypted"
+	}
+	return fmt.Sprintf("%s,%s,%s,%s", strings.Join(l.Tags, ";"), strings.Join(l.Groups, ";"), enc, l.Name)
+}
+
+func (l *labels) Parse(s string) error {
+	item := strings.SplitN(s, ",", 4)
+	if len(item) != 4 {
+		return fmt.Errorf("invalid labels string")
+	}
+	l.Tags = splitLabels(item[0])
+	l.Groups = splitLabels(item[1])
+	l.Encrypted = item[2] == "encrypted"
+	l.Name = item[3]
+	return nil
+}
+
+func (l *labels) empty() bool {
+	return len(l.Tags) == 0 && len(l.Groups) == 0 && !l.Encrypted
+}
+
+func init() {
+	note.Register("git", open)
+}
+
+// open returns a Git backend for the repository at the path of u, like
+// git:///home/me/notes, or git:notes for a relative path.
+func open(u *url.URL) (note.Backend, error) {
+	p := u.Path
+	if u.Opaque != "" {
+		p = u.Opaque
+	}
+	if p == "" || (u.Host != "" && u.Host != "localhost") {
+		return nil, ErrInvalidPath
+	}
+	return NewBackend(filepath.FromSlash(p)), nil
+}
+
+// NewBackend returns a Git backend for the repository at dir, created by
+// Init if missing.
+func NewBackend(dir string) *Git {
+	return &Git{dir: dir}
+}
+
+// Init creates the repository if needed. Commits are made with the identity
+// configured for git, or as note when there is none.
+func (g *Git) Init() error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return ErrNoGit
+	}
+	dir, err := filepath.Abs(g.dir)
+	if err != nil {
+		return err
+	}
+	g.dir = dir
+	err = os.MkdirAll(g.dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := g.git("rev-parse", "--git-dir"); err != nil {
+		if _, err := g.git("init", "--quiet"); err != nil {
+			return err
+		}
+	}
+	g.env = nil
+	if out, _ := g.git("config", "user.email"); len(bytes.TrimSpace(out)) == 0 {
+		g.env = []string{
+			"GIT_AUTHOR_NAME=note", "GIT_AUTHOR_EMAIL=note@localhost",
+			"GIT_COMMITTER_NAME=note", "GIT_COMMITTER_EMAIL=note@localhost",
+		}
+	}
+	return nil
+}
+
+func (g *Git) Create(n *note.Note) error {
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	p, err := g.path(n.Name)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := os.Stat(p); err == nil {
+		return note.ErrNoteExist
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, n.Data, 0644); err != nil {
+		return err
+	}
+	l := labels{Name: n.Name, Tags: n.Tags, Groups: n.Groups, Encrypted: n.Encrypted}
+	if err := g.setLabels(l); err != nil {
+		return err
+	}
+	return g.commit("create "+n.Name, p, g.labelsPath())
+}
+
+func (g *Git) Get(name string) (note.Note, error) {
+	p, err := g.path(name)
+	if err != nil {
+		return note.Note{}, err
+	}
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+		return note.Note{}, note.ErrNotFound
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return note.Note{}, err
+	}
+	dates, err := g.dates([]string{name})
+	if err != nil {
+		return note.Note{}, err
+	}
+	all, err := g.loadLabels()
+	if err != nil {
+		return note.Note{}, err
+	}
+	n := g.newNote(name, dates[name], all)
+	n.Data = data
+	n.Id = fmt.Sprintf("%x", sha256.Sum256(data))
+	return n, nil
+}
+
+func (g *Git) Update(name string, data []byte) error {
+	newNote, err := note.NewNote(name, "", data)
+	if err != nil {
+		return err
+	}
+
+	n, err := g.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if n.Id == newNote.Id {
+		return note.ErrNotModified
+	}
+
+	p, _ := g.path(name)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return err
+	}
+	return g.commit("update "+name, p)
+}
+
+func (g *Git) Delete(n *note.Note) error {
+	p, err := g.path(n.Name)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// only the version read by the caller is deleted
+	data, err := os.ReadFile(p)
+	if err != nil || fmt.Sprintf("%x", sha256.Sum256(data)) != n.Id {
+		return note.ErrNotFound
+	}
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	// leave no empty folders behind, like git does
+	for d := filepath.Dir(p); d != g.dir; d = filepath.Dir(d) {
+		if os.Remove(d) != nil {
+			break
+		}
+	}
+	if err := g.setLabels(labels{Name: n.Name}); err != nil {
+		return err
+	}
+	return g.commit("delete "+n.Name, p, g.labelsPath())
+}
+
+func (g *Git) List(name string) ([]note.Note, error) {
+	if note.InvalidName(name) {
+		return nil, note.ErrInvalidName
+	}
+	names, err := g.files()
+	if err != nil {
+		return nil, err
+	}
+	names = slices.DeleteFunc(names, func(v string) bool { return !strings.Contains(v, name) })
+	return g.notes(names)
+}
+
+// Search finds notes whose content contains query, ignoring case, with git
+// grep.
+func (g *Git) Search(query string) ([]note.Note, error) {
+	out, err := g.git("grep", "--null", "--files-with-matches", "--ignore-case",
+		"--fixed-strings", "-I", "-e", query, "--", ".", ":(exclude)"+metaDir)
+	if err != nil {
+		// git grep exits with 1 when nothing matches
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 1 {
+			return []note.Note{}, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, v := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if name, ok := noteName(v); ok {
+			names = append(names, name)
+		}
+	}
+
+	list, err := g.notes(names)
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Note{}
+	for _, n := range list {
+		if n.Encrypted {
+			continue
+		}
+		n.Data, err = os.ReadFile(filepath.Join(g.dir, filepath.FromSlash(n.Name)))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, n)
+	}
+	return r, nil
+}
+
+func (g *Git) Tag(name string, tags []string) error {
+	n := note.Note{Name: name, Tags: tags}
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	p, err := g.path(name)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := os.Stat(p); err != nil {
+		return note.ErrNotFound
+	}
+	all, err := g.loadLabels()
+	if err != nil {
+		return err
+	}
+	l := all[name]
+	l.Name = name
+	l.Tags = tags
+	if err := g.setLabels(l); err != nil {
+		return err
+	}
+	return g.commit("tag "+name, g.labelsPath())
+}
+
+// Ready checks that the repository can be read and its worktree written.
+func (g *Git) Ready() error {
+	if _, err := g.git("status", "--porcelain"); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Join(g.dir, ".git"), ".ready")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Stats returns the number of notes and the bytes used by the repository,
+// history included.
+func (g *Git) Stats() (int, int64, error) {
+	names, err := g.files()
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	err = filepath.WalkDir(g.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(names), size, nil
+}
+
+// path returns the file of the note name, names can't point into the git or
+// labels folders, even through symbolic links.
+func (g *Git) path(name string) (string, error) {
+	if name == "" || note.InvalidName(name) {
+		return "", note.ErrInvalidName
+	}
+	if _, ok := noteName(name); !ok {
+		return "", note.ErrInvalidName
+	}
+	p := filepath.Join(g.dir, filepath.FromSlash(name))
+	if !g.inside(p) {
+		return "", note.ErrInvalidName
+	}
+	return p, nil
+}
+
+// inside reports if p, once its existing part is resolved, is a note file of
+// the work tree.
+func (g *Git) inside(p string) bool {
+	root, err := filepath.EvalSymlinks(g.dir)
+	if err != nil {
+		return false
+	}
+	existing, rest := p, ""
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			rel, err := filepath.Rel(root, filepath.Join(real, rest))
+			if err != nil {
+				return false
+			}
+			_, ok := noteName(filepath.ToSlash(rel))
+			return ok
+		}
+		if !errors.Is(err, fs.ErrNotExist) || existing == g.dir {
+			return false
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+}
+
+// noteName returns the note of the repository file p, if it is one. No
+// folder of p can be . or .., nor any part start with .git or be the labels
+// folder, whatever the case.
+func noteName(p string) (string, bool) {
+	if p == "" || strings.Contains(p, "\\") {
+		return "", false
+	}
+	for _, v := range strings.Split(p, "/") {
+		l := strings.ToLower(v)
+		if v == "" || v == "." || v == ".." || strings.HasPrefix(l, ".git") || l == metaDir {
+			return "", false
+		}
+	}
+	return p, true
+}
+
+// files returns the notes in the repository, committed or not.
+func (g *Git) files() ([]string, error) {
+	out, err := g.git("ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	for _, v := range strings.Split(string(out), "\x00") {
+		if name, ok := noteName(v); ok && v != "" && !slices.Contains(r, name) {
+			r = append(r, name)
+		}
+	}
+	return r, nil
+}
+
+// notes returns the notes named names without their data, newest first.
+// Content ids are computed from the files.
+func (g *Git) notes(names []string) ([]note.Note, error) {
+	dates, err := g.dates(names)
+	if err != nil {
+		return nil, err
+	}
+	all, err := g.loadLabels()
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Note{}
+	for _, name := range names {
+		n := g.newNote(name, dates[name], all)
+		data, err := os.ReadFile(filepath.Join(g.dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		n.Id = fmt.Sprintf("%x", sha256.Sum256(data))
+		r = append(r, n)
+	}
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Date.After(*r[j].Date) })
+	return r, nil
+}
+
+func (g *Git) newNote(name string, date time.Time, all map[string]labels) note.Note {
+	l := all[name]
+	return note.Note{
+		Name:      name,
+		Date:      &date,
+		Tags:      l.Tags,
+		Groups:    l.Groups,
+		Encrypted: l.Encrypted,
+	}
+}
+
+// dates returns the time of the last commit that changed each of names, or
+// of the last change of the file when it has uncommitted changes.
+func (g *Git) dates(names []string) (map[string]time.Time, error) {
+	r := map[string]time.Time{}
+	missing := map[string]bool{}
+	for _, name := range names {
+		missing[name] = true
+	}
+
+	out, _ := g.git("-c", "core.quotepath=off", "status", "--porcelain", "--untracked-files=all")
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		name := line[3:]
+		if !missing[name] {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(g.dir, filepath.FromSlash(name)))
+		if err == nil {
+			r[name] = fi.ModTime()
+			delete(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return r, nil
+	}
+
+	// walk the history from the newest commit until every note was seen
+	cmd := g.command("-c", "core.quotepath=off", "log", "--format=%x00%ct", "--name-only")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var date time.Time
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && len(missing) > 0 {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\x00") {
+			sec, _ := strconv.ParseInt(line[1:], 10, 64)
+			date = time.Unix(sec, 0)
+			continue
+		}
+		if missing[line] {
+			r[line] = date
+			delete(missing, line)
+		}
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	// empty repository, or files changed outside of git
+	for name := range missing {
+		r[name] = time.Now()
+	}
+	return r, nil
+}
+
+func (g *Git) labelsPath() string {
+	return filepath.Join(g.dir, metaDir, "labels")
+}
+
+func (g *Git) loadLabels() (map[string]labels, error) {
+	all, err := dbline.Open[*labels](g.labelsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	r := map[string]labels{}
+	for _, l := range all {
+		r[l.Name] = l
+	}
+	return r, nil
+}
+
+// setLabels replaces the labels of l.Name, empty labels are removed.
+func (g *Git) setLabels(l labels) error {
+	all, err := dbline.Open[*labels](g.labelsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	all = slices.DeleteFunc(all, func(v labels) bool { return v.Name == l.Name })
+	if !l.empty() {
+		all = append(all, l)
+	}
+	if err := os.MkdirAll(filepath.Dir(g.labelsPath()), os.ModePerm); err != nil {
+		return err
+	}
+	p := make([]*labels, len(all))
+	for i := range all {
+		p[i] = &all[i]
+	}
+	return dbline.Save(g.labelsPath(), p)
+}
+
+// commit stages paths, which may be gone, and commits them when they
+// changed. Other changes staged in the repository are left out.
+func (g *Git) commit(msg string, paths ...string) error {
+	var rels []string
+	for _, p := range paths {
+		rel, err := filepath.Rel(g.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		args := []string{"add", "--", rel}
+		if _, err := os.Stat(p); err != nil {
+			args = []string{"rm", "--quiet", "--cached", "--ignore-unmatch", "--", rel}
+		}
+		if _, err := g.git(args...); err != nil {
+			return err
+		}
+		rels = append(rels, rel)
+	}
+	// paths never added to git can't be named to commit
+	out, err := g.git(append([]string{"diff", "--cached", "--name-only", "-z", "--"}, rels...)...)
+	if err != nil {
+		return err
+	}
+	changed := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if len(out) == 0 {
+		return nil
+	}
+	args := append([]string{"commit", "--quiet", "--only", "--message", "note: " + msg, "--"}, changed...)
+	_, err = g.git(args...)
+	return err
+}
+
+func (g *Git) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(os.Environ(), g.env...)
+	return cmd
+}
+
+// git runs git with args in the repository and returns its output. Errors
+// include what git printed.
+func (g *Git) git(args ...string) ([]byte, error) {
+	cmd := g.command(args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return out, err
+		}
+		return out, fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+	}
+	return out, nil
+}
+
+func splitLabels(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ";")
+}
@@ -0,0 +1,125 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+func newTestRepo(t *testing.T) *Git {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	g := NewBackend(t.TempDir())
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestBackend(t *testing.T) {
+	notetest.Run(t, func(t *testing.T) note.Backend { return newTestRepo(t) })
+}
+
+func TestPath(t *testing.T) {
+	g := newTestRepo(t)
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"todo", true},
+		{"work/plan", true},
+		{"work/.hidden", true},
+		{".config", true},
+		{".git/config", false},
+		{"./.git/config", false},
+		{"//.git/config", false},
+		{"/.git/config", false},
+		{"a/../.git/config", false},
+		{"a/./b", false},
+		{"a//b", false},
+		{"a/", false},
+		{".GIT/config", false},
+		{".gitmodules", false},
+		{"sub/.git/hooks/pre-commit", false},
+		{".note/labels", false},
+		{"./.note/labels", false},
+		{".Note/labels", false},
+		{`a\b`, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		_, err := g.path(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("path(%q): %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, note.ErrInvalidName) {
+			t.Errorf("path(%q) = %v, want %v", tt.name, err, note.ErrInvalidName)
+		}
+	}
+}
+
+func TestPathSymlink(t *testing.T) {
+	g := newTestRepo(t)
+	if err := os.Symlink(".git", filepath.Join(g.dir, "link")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(g.dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"link/config", "link/hooks/new/pre-commit", "out/x"} {
+		n, err := note.NewNote(name, "", []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Create(n); !errors.Is(err, note.ErrInvalidName) {
+			t.Errorf("create %s: %v, want %v", name, err, note.ErrInvalidName)
+		}
+	}
+}
+
+func TestCommitOnlyNotes(t *testing.T) {
+	g := newTestRepo(t)
+	other := filepath.Join(g.dir, "staged.txt")
+	if err := os.WriteFile(other, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.git("add", "staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := note.NewNote("todo", "", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Create(n); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Update("todo", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := g.git("log", "--name-only", "--format=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "staged.txt") {
+		t.Errorf("staged file committed with the notes:\n%s", out)
+	}
+	out, err = g.git("diff", "--cached", "--name-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "staged.txt" {
+		t.Errorf("staged changes %q, want staged.txt", out)
+	}
+}
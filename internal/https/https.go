@@ -4,32 +4,119 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/serboupal/note/note"
 )
 
 var _ = (note.Backend)(&https{})
+var _ = (note.TokenManager)(&https{})
+var _ = (note.Sharer)(&https{})
+var _ = (note.Linker)(&https{})
+var _ = (note.Signer)(&https{})
 var (
 	ErrInvalidResponse = errors.New("invalid response form server")
 	ErrBadRequest      = errors.New("invalid user input")
 	ErrInvalidAuth     = errors.New("unauthenticated request")
+	ErrForbidden       = errors.New("token scope does not allow this operation")
+	ErrRateLimited     = errors.New("too many requests")
+	ErrServer          = errors.New("server error")
 )
 
+// StatusError is returned when the server answers with an unexpected status.
+// It wraps the matching error, so errors.Is works with note and https errors.
+type StatusError struct {
+	Code      int
+	Message   string
+	RequestId string
+	Err       error
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" || e.Message == e.Err.Error() {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Message
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
 type https struct {
-	client *http.Client
-	token  string
-	url    string
+	client  *http.Client
+	token   string
+	url     string
+	timeout time.Duration
+	retries int
+}
+
+type Option func(*https)
+
+// WithTimeout sets the timeout of every request, 5 seconds by default.
+func WithTimeout(d time.Duration) Option {
+	return func(h *https) { h.timeout = d }
+}
+
+// WithRetries sets how many times idempotent requests are retried after
+// network errors or busy server responses, 3 by default.
+func WithRetries(n int) Option {
+	return func(h *https) { h.retries = n }
 }
 
-func NewBackend(url, token string) *https {
-	return &https{url: url, token: token}
+func NewBackend(url, token string, opts ...Option) *https {
+	h := &https{url: url, token: token, timeout: 5 * time.Second, retries: 3}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+func init() {
+	note.Register("https", open)
+	note.Register("http", open)
+}
+
+// open returns the backend of the server at u. The token goes in the user
+// part, https://TOKEN@host, and options in the query, ?timeout=10s&retries=5.
+func open(u *url.URL) (note.Backend, error) {
+	if u.User == nil {
+		return nil, ErrInvalidAuth
+	}
+	token, ok := u.User.Password()
+	if !ok {
+		token = u.User.Username()
+	}
+	var opts []Option
+	q := u.Query()
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		opts = append(opts, WithTimeout(d))
+	}
+	if v := q.Get("retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retries: %w", err)
+		}
+		opts = append(opts, WithRetries(n))
+	}
+	base := *u
+	base.User = nil
+	base.RawQuery = ""
+	return NewBackend(base.String(), token, opts...), nil
 }
 
 func (h *https) Init() error {
-	h.client = &http.Client{Timeout: 5 * time.Second}
+	h.client = &http.Client{Timeout: h.timeout}
 	return nil
 }
 
@@ -41,7 +128,7 @@ func (h *https) Create(n *note.Note) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errMapStatus(resp.StatusCode)
+		return errResponse(resp)
 	}
 	return nil
 }
@@ -53,8 +140,13 @@ func (h *https) Get(name string) (n note.Note, err error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		// the server sends the note that failed the check
+		json.NewDecoder(resp.Body).Decode(&n)
+		return n, &StatusError{Code: resp.StatusCode, Err: note.ErrIntegrityFail}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return n, errMapStatus(resp.StatusCode)
+		return n, errResponse(resp)
 	}
 
 	err = json.NewDecoder(resp.Body).Decode(&n)
@@ -74,7 +166,7 @@ func (h *https) Update(name string, data []byte) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errMapStatus(resp.StatusCode)
+		return errResponse(resp)
 	}
 	return nil
 }
@@ -87,28 +179,20 @@ func (h *https) Delete(n *note.Note) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errMapStatus(resp.StatusCode)
+		return errResponse(resp)
 	}
 	return nil
 }
 
 func (h *https) List(name string) ([]note.Note, error) {
-	req, err := h.newRequest("GET", "", nil)
-	if err != nil {
-		return nil, err
-	}
-	q := req.URL.Query()
-	q.Add("filter", name)
-	req.URL.RawQuery = q.Encode()
-
-	resp, err := h.client.Do(req)
+	resp, err := h.do("GET", "", url.Values{"filter": {name}}, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errMapStatus(resp.StatusCode)
+		return nil, errResponse(resp)
 	}
 
 	notes := []note.Note{}
@@ -120,22 +204,14 @@ func (h *https) List(name string) ([]note.Note, error) {
 }
 
 func (h *https) Search(query string) ([]note.Note, error) {
-	req, err := h.newRequest("GET", "/search", nil)
-	if err != nil {
-		return nil, err
-	}
-	q := req.URL.Query()
-	q.Add("query", query)
-	req.URL.RawQuery = q.Encode()
-
-	resp, err := h.client.Do(req)
+	resp, err := h.do("GET", "/search", url.Values{"query": {query}}, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errMapStatus(resp.StatusCode)
+		return nil, errResponse(resp)
 	}
 
 	notes := []note.Note{}
@@ -147,15 +223,7 @@ func (h *https) Search(query string) ([]note.Note, error) {
 }
 
 func (h *https) newRequestDo(method, path string, a any) (*http.Response, error) {
-	req, err := h.newRequest(method, path, a)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := h.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	return resp, err
+	return h.do(method, path, nil, a)
 }
 
 func (h *https) newRequest(method, path string, a any) (*http.Request, error) {
@@ -183,6 +251,29 @@ func (h *https) newRequest(method, path string, a any) (*http.Request, error) {
 	return req, nil
 }
 
+// errResponse returns a StatusError for resp. JSON error bodies are mapped
+// back to the note error with the same code.
+func errResponse(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &StatusError{Code: resp.StatusCode, Err: errMapStatus(resp.StatusCode)}
+
+	apiErr := struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestId string `json:"request_id"`
+	}{}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+		if err := note.CodeError(apiErr.Code); err != nil {
+			e.Err = err
+		}
+		e.Message = apiErr.Message
+		e.RequestId = apiErr.RequestId
+		return e
+	}
+	e.Message = strings.TrimSpace(string(body))
+	return e
+}
+
 func errMapStatus(code int) error {
 	switch code {
 	case http.StatusNotFound:
@@ -193,9 +284,224 @@ func errMapStatus(code int) error {
 		return ErrBadRequest
 	case http.StatusUnauthorized:
 		return ErrInvalidAuth
+	case http.StatusForbidden:
+		return ErrForbidden
 	case http.StatusUnprocessableEntity:
 		return note.ErrIntegrityFail
-	default:
-		return ErrInvalidResponse
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	if code >= 500 {
+		return ErrServer
+	}
+	return ErrInvalidResponse
+}
+
+func (h *https) CreateToken(scope note.Scope, ttl time.Duration) (note.Token, error) {
+	t := note.Token{Scope: scope}
+	if ttl > 0 {
+		exp := time.Now().Add(ttl)
+		t.Expires = &exp
+	}
+	resp, err := h.newRequestDo("POST", "/_/tokens", t)
+	if err != nil {
+		return note.Token{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return note.Token{}, errResponse(resp)
+	}
+
+	t = note.Token{}
+	err = json.NewDecoder(resp.Body).Decode(&t)
+	if err != nil {
+		return note.Token{}, err
+	}
+	return t, nil
+}
+
+func (h *https) RevokeToken(id string) error {
+	resp, err := h.newRequestDo("DELETE", "/_/tokens/"+id, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+func (h *https) Tokens() ([]note.Token, error) {
+	resp, err := h.newRequestDo("GET", "/_/tokens", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errResponse(resp)
+	}
+
+	tokens := []note.Token{}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (h *https) Share(g note.Grant) error {
+	resp, err := h.newRequestDo("POST", "/_/shares", g)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+func (h *https) Unshare(g note.Grant) error {
+	resp, err := h.newRequestDo("DELETE", "/_/shares", g)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+func (h *https) Grants() ([]note.Grant, error) {
+	resp, err := h.newRequestDo("GET", "/_/shares", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errResponse(resp)
+	}
+
+	grants := []note.Grant{}
+	err = json.NewDecoder(resp.Body).Decode(&grants)
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// Shared returns the notes other users shared with us, named ~owner/name.
+func (h *https) Shared() ([]note.Note, error) {
+	resp, err := h.newRequestDo("GET", "/_/shared", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errResponse(resp)
+	}
+
+	notes := []note.Note{}
+	err = json.NewDecoder(resp.Body).Decode(&notes)
+	if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+func (h *https) CreateLink(name string, ttl time.Duration) (note.Link, error) {
+	exp := time.Now().Add(ttl)
+	resp, err := h.newRequestDo("POST", "/_/links", note.Link{Name: name, Expires: &exp})
+	if err != nil {
+		return note.Link{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return note.Link{}, errResponse(resp)
+	}
+
+	l := note.Link{}
+	err = json.NewDecoder(resp.Body).Decode(&l)
+	if err != nil {
+		return note.Link{}, err
+	}
+	l.URL, err = url.JoinPath(h.url, "_", "public", l.Secret)
+	if err != nil {
+		return note.Link{}, err
+	}
+	return l, nil
+}
+
+func (h *https) RevokeLink(id string) error {
+	resp, err := h.newRequestDo("DELETE", "/_/links/"+id, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+func (h *https) Links() ([]note.Link, error) {
+	resp, err := h.newRequestDo("GET", "/_/links", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errResponse(resp)
+	}
+
+	links := []note.Link{}
+	err = json.NewDecoder(resp.Body).Decode(&links)
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (h *https) AddSignature(name string, s note.Signature) error {
+	resp, err := h.newRequestDo("POST", "/_/signatures/"+name, s)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+func (h *https) Signatures(name string) ([]note.Signature, error) {
+	resp, err := h.newRequestDo("GET", "/_/signatures/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errResponse(resp)
+	}
+
+	list := []note.Signature{}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, err
 	}
+	return list, nil
 }
@@ -0,0 +1,178 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+// newTestBackend returns a backend of a server answering with h, and the
+// number of requests it got.
+func newTestBackend(t *testing.T, h http.HandlerFunc, opts ...Option) (*https, *atomic.Int32) {
+	t.Helper()
+	calls := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	b := NewBackend(srv.URL, "secret", opts...)
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return b, calls
+}
+
+// busy answers code with Retry-After after until the request number ok.
+func busy(ok int32, code int, after string) http.HandlerFunc {
+	calls := &atomic.Int32{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < ok {
+			w.Header().Set("Retry-After", after)
+			w.WriteHeader(code)
+			return
+		}
+		json.NewEncoder(w).Encode(note.Note{Name: "todo", Data: []byte("a")})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		code  int
+		after string
+		wait  time.Duration
+	}{
+		{"rate limited", http.StatusTooManyRequests, "0", 0},
+		{"unavailable", http.StatusServiceUnavailable, "0", 0},
+		{"retry after", http.StatusServiceUnavailable, "1", time.Second},
+		{"retry after date", http.StatusTooManyRequests, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, calls := newTestBackend(t, busy(3, tt.code, tt.after))
+			start := time.Now()
+			n, err := b.Get("todo")
+			if err != nil || string(n.Data) != "a" {
+				t.Fatalf("get: %v, %v", n, err)
+			}
+			if calls.Load() != 3 {
+				t.Errorf("%d requests, want 3", calls.Load())
+			}
+			if d := time.Since(start); d < 2*tt.wait {
+				t.Errorf("retried after %v, want Retry-After %v", d, tt.wait)
+			}
+		})
+	}
+}
+
+func TestRetryLimit(t *testing.T) {
+	b, calls := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, WithRetries(2))
+	_, err := b.Get("todo")
+	if !errors.Is(err, ErrServer) {
+		t.Errorf("get: %v, want %v", err, ErrServer)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("%d requests, want 3", calls.Load())
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	b, calls := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	n, err := note.NewNote("todo", "", []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// creating twice is not safe
+	if err := b.Create(n); !errors.Is(err, ErrServer) {
+		t.Errorf("create: %v, want %v", err, ErrServer)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("create sent %d times, want 1", calls.Load())
+	}
+
+	b, calls = newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if _, err := b.Get("todo"); !errors.Is(err, ErrServer) {
+		t.Errorf("get: %v, want %v", err, ErrServer)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("internal error retried, %d requests", calls.Load())
+	}
+}
+
+func TestErrResponse(t *testing.T) {
+	for _, c := range []struct {
+		code   string
+		status int
+		want   error
+	}{
+		{"invalid_name", http.StatusBadRequest, note.ErrInvalidName},
+		{"integrity_fail", http.StatusUnprocessableEntity, note.ErrIntegrityFail},
+		{"note_exist", http.StatusConflict, note.ErrNoteExist},
+		{"not_modified", http.StatusBadRequest, note.ErrNotModified},
+		{"not_found", http.StatusNotFound, note.ErrNotFound},
+		{"invalid_label", http.StatusBadRequest, note.ErrInvalidLabel},
+		{"invalid_scope", http.StatusBadRequest, note.ErrInvalidScope},
+		{"invalid_grant", http.StatusBadRequest, note.ErrInvalidGrant},
+		{"invalid_signature", http.StatusBadRequest, note.ErrInvalidSignature},
+		{"stale_signature", http.StatusConflict, note.ErrStaleSignature},
+		{"unknown", http.StatusForbidden, ErrForbidden},
+	} {
+		b, _ := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			json.NewEncoder(w).Encode(map[string]string{
+				"code": c.code, "message": "detail", "request_id": "r1",
+			})
+		})
+		err := b.Update("todo", []byte("a"))
+		if !errors.Is(err, c.want) {
+			t.Errorf("code %s: %v, want %v", c.code, err, c.want)
+		}
+		var se *StatusError
+		if !errors.As(err, &se) || se.Code != c.status || se.Message != "detail" || se.RequestId != "r1" {
+			t.Errorf("code %s: %#v", c.code, err)
+		}
+	}
+}
+
+func TestErrStatus(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, note.ErrNotFound},
+		{http.StatusConflict, note.ErrNoteExist},
+		{http.StatusBadRequest, ErrBadRequest},
+		{http.StatusUnauthorized, ErrInvalidAuth},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusUnprocessableEntity, note.ErrIntegrityFail},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusInternalServerError, ErrServer},
+		{http.StatusTeapot, ErrInvalidResponse},
+	} {
+		b, _ := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "plain body", c.status)
+		}, WithRetries(0))
+		err := b.Delete(&note.Note{Name: "todo"})
+		if !errors.Is(err, c.want) {
+			t.Errorf("status %d: %v, want %v", c.status, err, c.want)
+		}
+		var se *StatusError
+		if !errors.As(err, &se) || se.Message != "plain body" {
+			t.Errorf("status %d: %#v", c.status, err)
+		}
+	}
+}
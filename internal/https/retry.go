@@ -0,0 +1,102 @@
+package https
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	backoffBase = 200 * time.Millisecond
+	backoffMax  = 5 * time.Second
+	// maxRetryAfter caps the wait asked by the server with Retry-After.
+	maxRetryAfter = time.Minute
+)
+
+// do sends the request, retrying idempotent methods on network errors and
+// busy server responses with exponential backoff and jitter.
+func (h *https) do(method, path string, query url.Values, a any) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := h.newRequest(method, path, a)
+		if err != nil {
+			return nil, err
+		}
+		if query != nil {
+			req.URL.RawQuery = query.Encode()
+		}
+
+		resp, err := h.client.Do(req)
+		if attempt >= h.retries || !idempotent(method) || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				wait = d
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		time.Sleep(wait)
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// a timed out request already waited long enough
+		var ne net.Error
+		return !(errors.As(err, &ne) && ne.Timeout())
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns a random wait up to backoffBase * 2^attempt.
+func backoff(attempt int) time.Duration {
+	d := backoffBase << attempt
+	if d > backoffMax || d <= 0 {
+		d = backoffMax
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryAfter parses the Retry-After header, in seconds or as a date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if s, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
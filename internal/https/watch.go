@@ -0,0 +1,59 @@
+package https
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/serboupal/note/note"
+)
+
+var _ = (note.Watcher)(&https{})
+
+// Watch reads the Server-Sent Events of /_/events. The stream has no timeout,
+// it ends when ctx is done or the connection drops.
+func (h *https) Watch(ctx context.Context, f func(note.Event)) error {
+	req, err := h.newRequest("GET", "/_/events", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+
+	s := bufio.NewScanner(resp.Body)
+	var data strings.Builder
+	for s.Scan() {
+		line := s.Text()
+		if v, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(v, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			// comments and event names, the type is also in data
+			continue
+		}
+		e := note.Event{}
+		err := json.Unmarshal([]byte(data.String()), &e)
+		data.Reset()
+		if err != nil {
+			return ErrInvalidResponse
+		}
+		f(e)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return s.Err()
+}
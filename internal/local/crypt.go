@@ -0,0 +1,369 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/internal/crypt"
+	"github.com/serboupal/note/note"
+)
+
+var (
+	ErrLocked    = errors.New("store is encrypted, run note unlock")
+	ErrEncrypted = errors.New("store is already encrypted")
+)
+
+// indexAD, signaturesAD and aclAD bind sealed lines to their file.
+var (
+	indexAD      = []byte("index")
+	signaturesAD = []byte("signatures")
+	aclAD        = []byte("acl")
+)
+
+func (dir *Local) keyPath() string {
+	return filepath.Join(dir.data, "key")
+}
+
+func (dir *Local) loadKeyFile() (crypt.KeyFile, error) {
+	r, err := dbline.Open[*crypt.KeyFile](dir.keyPath())
+	if err != nil {
+		return crypt.KeyFile{}, err
+	}
+	if len(r) != 1 {
+		return crypt.KeyFile{}, fmt.Errorf("invalid key file")
+	}
+	return r[0], nil
+}
+
+// Encrypted reports if the store is encrypted. Init must be called first.
+func (dir *Local) Encrypted() bool {
+	_, err := os.Stat(dir.keyPath())
+	return err == nil
+}
+
+// KeyId identifies the key of an encrypted store, to cache it.
+func (dir *Local) KeyId() (string, error) {
+	k, err := dir.loadKeyFile()
+	if err != nil {
+		return "", err
+	}
+	return k.Id(), nil
+}
+
+// DeriveKey returns the key of the store for pass.
+func (dir *Local) DeriveKey(pass []byte) ([]byte, error) {
+	k, err := dir.loadKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	return k.Derive(pass)
+}
+
+// Unlock makes an encrypted store usable with key.
+func (dir *Local) Unlock(key []byte) error {
+	k, err := dir.loadKeyFile()
+	if err != nil {
+		return err
+	}
+	if err := k.Verify(key); err != nil {
+		return err
+	}
+	dir.key = key
+	return nil
+}
+
+// Encrypt encrypts the notes, index, signatures and shares of the store with a key derived from
+// pass, which is returned. Note ids don't change.
+func (dir *Local) Encrypt(pass []byte) ([]byte, error) {
+	if dir.Encrypted() {
+		return nil, ErrEncrypted
+	}
+	k, key, err := crypt.NewKeyFile(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := dir.readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// everything is written aside and moved in place, notes first and the
+	// key last, so the store is only encrypted once all of it is
+	var moves []move
+	defer func() {
+		for _, m := range moves {
+			os.Remove(m.from)
+		}
+	}()
+	for _, n := range all {
+		err := dir.loadNoteData(&n)
+		if err != nil {
+			return nil, err
+		}
+		data, err := crypt.Seal(key, n.Data, []byte(n.Id))
+		if err != nil {
+			return nil, err
+		}
+		path, err := dir.newPathFromId(n.Id)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(path.full+".enc", data, 0600)
+		if err != nil {
+			return nil, err
+		}
+		moves = append(moves, move{path.full + ".enc", path.full})
+	}
+	sigs, err := readRecords[*note.Signature](dir, dir.signaturesPath(), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	grants, err := readRecords[*note.Grant](dir, dir.aclPath(), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dir.key = key
+	records := []struct {
+		path string
+		err  error
+	}{
+		{dir.data + "/index", writeSealed(dir, dir.data+"/index.enc", pointers(all), indexAD)},
+		{dir.signaturesPath(), writeSealed(dir, dir.signaturesPath()+".enc", pointers(sigs), signaturesAD)},
+		{dir.aclPath(), writeSealed(dir, dir.aclPath()+".enc", pointers(grants), aclAD)},
+	}
+	for _, r := range records {
+		if r.err != nil {
+			dir.key = nil
+			return nil, r.err
+		}
+		moves = append(moves, move{r.path + ".enc", r.path})
+	}
+	err = dbline.Save(dir.keyPath()+".enc", []*crypt.KeyFile{&k})
+	if err != nil {
+		dir.key = nil
+		return nil, err
+	}
+	moves = append(moves, move{dir.keyPath() + ".enc", dir.keyPath()})
+
+	err = replace(moves)
+	if err != nil {
+		dir.key = nil
+		return nil, err
+	}
+	return key, nil
+}
+
+// move is a file written aside to replace another.
+type move struct {
+	from, to string
+}
+
+// rename is os.Rename, replaced by tests to fail.
+var rename = os.Rename
+
+// replace renames every file of moves in order. The replaced files are kept
+// until all of them are in place and put back if one fails.
+func replace(moves []move) error {
+	var done []move
+	undo := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			m := done[i]
+			if _, err := os.Stat(m.to + ".bak"); err == nil {
+				os.Rename(m.to+".bak", m.to)
+			} else {
+				os.Remove(m.to)
+			}
+		}
+	}
+	for _, m := range moves {
+		os.Remove(m.to + ".bak")
+		err := rename(m.to, m.to+".bak")
+		if err != nil && !os.IsNotExist(err) {
+			undo()
+			return err
+		}
+		done = append(done, m)
+		err = rename(m.from, m.to)
+		if err != nil {
+			undo()
+			return err
+		}
+	}
+	for _, m := range moves {
+		os.Remove(m.to + ".bak")
+	}
+	return nil
+}
+
+// seal and open encrypt note contents when the store is encrypted.
+func (dir *Local) seal(n *note.Note) ([]byte, error) {
+	if !dir.Encrypted() {
+		return n.Data, nil
+	}
+	if dir.key == nil {
+		return nil, ErrLocked
+	}
+	return crypt.Seal(dir.key, n.Data, []byte(n.Id))
+}
+
+func (dir *Local) open(id string, data []byte) ([]byte, error) {
+	if !dir.Encrypted() {
+		return data, nil
+	}
+	if dir.key == nil {
+		return nil, ErrLocked
+	}
+	return crypt.Open(dir.key, data, []byte(id))
+}
+
+// readIndex returns the index entries in file order.
+func (dir *Local) readIndex() ([]note.Note, error) {
+	return readRecords[*note.Note](dir, dir.data+"/index", indexAD)
+}
+
+func (dir *Local) appendIndex(n *note.Note) error {
+	return appendRecord(dir, dir.data+"/index", n, indexAD)
+}
+
+func (dir *Local) saveIndex(all []note.Note) error {
+	return saveRecords(dir, dir.data+"/index", pointers(all), indexAD)
+}
+
+// deleteIndex removes the entry of n and reports if other entries still use
+// its id.
+func (dir *Local) deleteIndex(n *note.Note) (bool, error) {
+	all, err := dir.readIndex()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, note.ErrNotFound
+		}
+		return false, err
+	}
+	i := slices.IndexFunc(all, func(v note.Note) bool {
+		return v.Id == n.Id && (n.Name == "" || v.Name == n.Name)
+	})
+	if i < 0 {
+		return false, note.ErrNotFound
+	}
+	all = slices.Delete(all, i, i+1)
+	shared := slices.ContainsFunc(all, func(v note.Note) bool { return v.Id == n.Id })
+	return shared, dir.saveIndex(all)
+}
+
+// entry is a line of a dbline file, record a pointer to one.
+type entry interface {
+	String() string
+	Parse(s string) error
+}
+
+type record[T any] interface {
+	*T
+	entry
+}
+
+func pointers[T any](all []T) []*T {
+	p := make([]*T, len(all))
+	for i := range all {
+		p[i] = &all[i]
+	}
+	return p
+}
+
+// readRecords returns the entries of the dbline file at path, which lines
+// are sealed with ad when the store is encrypted.
+func readRecords[P record[T], T any](dir *Local, path string, ad []byte) ([]T, error) {
+	if !dir.Encrypted() {
+		return dbline.Open[P](path)
+	}
+	if dir.key == nil {
+		return nil, ErrLocked
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r []T
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		data, err := base64.RawStdEncoding.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		line, err := crypt.Open(dir.key, data, ad)
+		if err != nil {
+			return nil, err
+		}
+		var e P = new(T)
+		err = e.Parse(string(line))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, *e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func appendRecord[T entry](dir *Local, path string, item T, ad []byte) error {
+	if !dir.Encrypted() {
+		return dbline.AppendEntry(path, item)
+	}
+	line, err := dir.sealLine(item.String(), ad)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func saveRecords[T entry](dir *Local, path string, all []T, ad []byte) error {
+	if !dir.Encrypted() {
+		return dbline.Save(path, all)
+	}
+	return writeSealed(dir, path, all, ad)
+}
+
+// writeSealed writes all sealed with ad to path.
+func writeSealed[T entry](dir *Local, path string, all []T, ad []byte) error {
+	buf := new(bytes.Buffer)
+	for _, v := range all {
+		line, err := dir.sealLine(v.String(), ad)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(line + "\n")
+	}
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
+
+func (dir *Local) sealLine(s string, ad []byte) (string, error) {
+	if dir.key == nil {
+		return "", ErrLocked
+	}
+	data, err := crypt.Seal(dir.key, []byte(s), ad)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,174 @@
+package local
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/serboupal/note/internal/crypt"
+	"github.com/serboupal/note/note"
+)
+
+// newPlainStore returns a store under root with a note shared with bob.
+func newPlainStore(t *testing.T, root string) *Local {
+	t.Helper()
+	l := NewBackendAt(root)
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"todo": "a secret", "other": "more"} {
+		n, err := note.NewNote(name, "", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Create(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Share(note.Grant{Name: "todo", User: "bob", Scope: note.ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// files returns the content of every file of the store by relative path.
+func files(t *testing.T, l *Local) map[string][]byte {
+	t.Helper()
+	r := map[string][]byte{}
+	err := filepath.WalkDir(l.data, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(l.data, path)
+		r[rel] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// readable checks the notes and shares of newPlainStore can be read.
+func readable(t *testing.T, l *Local) {
+	t.Helper()
+	n, err := l.Get("todo")
+	if err != nil || string(n.Data) != "a secret" {
+		t.Fatalf("get: %q, %v", n.Data, err)
+	}
+	g, err := l.Grants()
+	if err != nil || len(g) != 1 || g[0].User != "bob" {
+		t.Fatalf("grants: %v, %v", g, err)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	root := t.TempDir()
+	l := newPlainStore(t, root)
+	key, err := l.Encrypt([]byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readable(t, l)
+	for path, data := range files(t, l) {
+		if bytes.Contains(data, []byte("a secret")) || bytes.Contains(data, []byte("bob")) {
+			t.Errorf("%s is not encrypted", path)
+		}
+		if strings.HasSuffix(path, ".enc") || strings.HasSuffix(path, ".bak") {
+			t.Errorf("%s left behind", path)
+		}
+	}
+	if _, err := l.Encrypt([]byte("pass")); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("encrypt twice: %v, want %v", err, ErrEncrypted)
+	}
+
+	// a new process starts locked
+	l = NewBackendAt(root)
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Encrypted() {
+		t.Fatal("store not encrypted")
+	}
+	n, _ := note.NewNote("new", "", []byte("x"))
+	for op, err := range map[string]error{
+		"get":    func() error { _, err := l.Get("todo"); return err }(),
+		"list":   func() error { _, err := l.List(""); return err }(),
+		"create": l.Create(n),
+		"grants": func() error { _, err := l.Grants(); return err }(),
+	} {
+		if !errors.Is(err, ErrLocked) {
+			t.Errorf("%s on locked store: %v, want %v", op, err, ErrLocked)
+		}
+	}
+
+	if _, err := l.DeriveKey([]byte("wrong")); !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("derive with wrong passphrase: %v, want %v", err, crypt.ErrWrongKey)
+	}
+	if err := l.Unlock(bytes.Repeat([]byte{1}, crypt.KeySize)); !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("unlock with wrong key: %v, want %v", err, crypt.ErrWrongKey)
+	}
+	if _, err := l.Get("todo"); !errors.Is(err, ErrLocked) {
+		t.Errorf("get after failed unlock: %v", err)
+	}
+
+	got, err := l.DeriveKey([]byte("pass"))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("derived key differs from Encrypt: %v", err)
+	}
+	if err := l.Unlock(got); err != nil {
+		t.Fatal(err)
+	}
+	readable(t, l)
+	if err := l.Create(n); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := l.Get("new"); err != nil || string(n.Data) != "x" {
+		t.Errorf("get note created unlocked: %q, %v", n.Data, err)
+	}
+}
+
+func TestEncryptRollback(t *testing.T) {
+	defer func() { rename = os.Rename }()
+	for fail := 0; ; fail++ {
+		l := newPlainStore(t, t.TempDir())
+		before := files(t, l)
+		calls := 0
+		rename = func(from, to string) error {
+			calls++
+			if calls > fail {
+				return &os.LinkError{Op: "rename", Old: from, New: to, Err: fs.ErrPermission}
+			}
+			return os.Rename(from, to)
+		}
+		_, err := l.Encrypt([]byte("pass"))
+		if err == nil {
+			// every rename done
+			if fail == 0 {
+				t.Fatal("encrypt didn't rename anything")
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("rename %d failing: %v", fail, err)
+		}
+		if l.Encrypted() {
+			t.Fatalf("rename %d failing: store left encrypted", fail)
+		}
+		readable(t, l)
+		after := files(t, l)
+		if len(after) != len(before) {
+			t.Errorf("rename %d failing: files %d, want %d", fail, len(after), len(before))
+		}
+		for path, data := range before {
+			if !bytes.Equal(after[path], data) {
+				t.Errorf("rename %d failing: %s changed", fail, path)
+			}
+		}
+	}
+}
@@ -2,12 +2,13 @@ package local
 
 import (
 	"errors"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 
-	"github.com/serboupal/note/dbline"
 	"github.com/serboupal/note/note"
 )
 
@@ -16,11 +17,13 @@ var (
 )
 
 type Local struct {
+	root   string
 	config string
 	data   string
 	tags   string
 	groups string
 	dir    string
+	key    []byte
 }
 
 type path struct {
@@ -32,6 +35,9 @@ type path struct {
 }
 
 var _ = (note.Backend)(&Local{})
+var _ = (note.Sharer)(&Local{})
+var _ = (note.Tagger)(&Local{})
+var _ = (note.Signer)(&Local{})
 
 func (dir *Local) newPathFromId(id string) (*path, error) {
 	if len(id) != 64 {
@@ -62,11 +68,50 @@ func NewBackend(dir string) *Local {
 	return &Local{dir: dir}
 }
 
+// NewBackendAt returns a Local backend that keeps config and data under root
+// instead of the user config and home folders.
+func NewBackendAt(root string) *Local {
+	return &Local{root: root}
+}
+
+func init() {
+	note.Register("file", open)
+}
+
+// open returns a Local backend kept under the path of u, like
+// file:///home/me/notes, or file:notes for a relative path.
+func open(u *url.URL) (note.Backend, error) {
+	p := u.Path
+	if u.Opaque != "" {
+		p = u.Opaque
+	}
+	if p == "" || (u.Host != "" && u.Host != "localhost") {
+		return nil, ErrInvalidPath
+	}
+	return NewBackendAt(filepath.FromSlash(p)), nil
+}
+
 func (l *Local) Init() error {
 	return l.mkDirs()
 }
 
 func (dir *Local) Create(n *note.Note) error {
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	all, err := dir.readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if slices.ContainsFunc(all, func(v note.Note) bool { return v.Name == n.Name }) {
+		return note.ErrNoteExist
+	}
+	return dir.create(n)
+}
+
+// create stores n without checking its name is free, Update adds the new
+// revision before deleting the old one.
+func (dir *Local) create(n *note.Note) error {
 	path, err := dir.newPathFromId(n.Id)
 	if err != nil {
 		return err
@@ -76,13 +121,18 @@ func (dir *Local) Create(n *note.Note) error {
 		return err
 	}
 
+	data, err := dir.seal(n)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Create(path.full)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	_, err = file.Write(n.Data)
+	_, err = file.Write(data)
 	if err != nil {
 		return err
 	}
@@ -148,8 +198,9 @@ func (dir *Local) Update(name string, data []byte) error {
 
 	newNote.Tags = n.Tags
 	newNote.Groups = n.Groups
+	newNote.Encrypted = n.Encrypted
 
-	err = dir.Create(newNote)
+	err = dir.create(newNote)
 	if err != nil {
 		return err
 	}
@@ -167,6 +218,9 @@ func (dir *Local) Search(s string) ([]note.Note, error) {
 	}
 
 	for _, n := range notes {
+		if n.Encrypted {
+			continue
+		}
 		err := dir.loadNoteData(&n)
 		if err != nil {
 			return nil, err
@@ -178,11 +232,16 @@ func (dir *Local) Search(s string) ([]note.Note, error) {
 	return r, nil
 }
 
+// Delete removes the note named n.Name with id n.Id. Its content is kept
+// while other notes have the same id.
 func (dir *Local) Delete(n *note.Note) error {
-	err := dbline.DeleteEntry(dir.data+"/index", n.Id)
+	shared, err := dir.deleteIndex(n)
 	if err != nil {
 		return err
 	}
+	if shared {
+		return nil
+	}
 
 	path, err := dir.newPathFromId(n.Id)
 	if err != nil {
@@ -192,8 +251,153 @@ func (dir *Local) Delete(n *note.Note) error {
 	return os.Remove(path.full)
 }
 
+func (dir *Local) Tag(name string, tags []string) error {
+	n := note.Note{Name: name, Tags: tags}
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+
+	all, err := dir.readIndex()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return note.ErrNotFound
+		}
+		return err
+	}
+	i := slices.IndexFunc(all, func(v note.Note) bool { return v.Name == name })
+	if i < 0 {
+		return note.ErrNotFound
+	}
+	all[i].Tags = tags
+	return dir.saveIndex(all)
+}
+
+// Ready checks that the index can be read and the data folder written.
+func (dir *Local) Ready() error {
+	_, err := dir.loadIndex()
+	if err != nil && !errors.Is(err, note.ErrNotFound) {
+		return err
+	}
+	f, err := os.CreateTemp(dir.data, ".ready")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Stats returns the number of notes and the bytes used by the store.
+func (dir *Local) Stats() (int, int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir.data, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	all, err := dir.loadIndex()
+	if err != nil && !errors.Is(err, note.ErrNotFound) {
+		return 0, 0, err
+	}
+	return len(all), size, nil
+}
+
+// AddSignature keeps s, which must sign the current revision of name.
+// Signatures of every revision are kept.
+func (dir *Local) AddSignature(name string, s note.Signature) error {
+	if s.Name != name {
+		return note.ErrInvalidSignature
+	}
+	if err := s.Verify(); err != nil {
+		return err
+	}
+	list, err := dir.List(name)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(list, func(n note.Note) bool { return n.Name == name })
+	if i < 0 {
+		return note.ErrNotFound
+	}
+	if list[i].Id != s.Id {
+		return note.ErrStaleSignature
+	}
+	return appendRecord(dir, dir.signaturesPath(), &s, signaturesAD)
+}
+
+// Signatures returns the signatures of every revision of name, oldest first.
+func (dir *Local) Signatures(name string) ([]note.Signature, error) {
+	all, err := readRecords[*note.Signature](dir, dir.signaturesPath(), signaturesAD)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	r := []note.Signature{}
+	for _, s := range all {
+		if s.Name == name {
+			r = append(r, s)
+		}
+	}
+	return r, nil
+}
+
+func (dir *Local) Share(g note.Grant) error {
+	if err := g.Check(); err != nil {
+		return err
+	}
+	grants, err := dir.Grants()
+	if err != nil {
+		return err
+	}
+	grants = slices.DeleteFunc(grants, g.Same)
+	grants = append(grants, g)
+	return dir.saveGrants(grants)
+}
+
+func (dir *Local) Unshare(g note.Grant) error {
+	grants, err := dir.Grants()
+	if err != nil {
+		return err
+	}
+	l := len(grants)
+	grants = slices.DeleteFunc(grants, g.Same)
+	if len(grants) == l {
+		return note.ErrNotFound
+	}
+	return dir.saveGrants(grants)
+}
+
+func (dir *Local) Grants() ([]note.Grant, error) {
+	r, err := readRecords[*note.Grant](dir, dir.aclPath(), aclAD)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (dir *Local) saveGrants(grants []note.Grant) error {
+	return saveRecords(dir, dir.aclPath(), pointers(grants), aclAD)
+}
+
+func (dir *Local) signaturesPath() string {
+	return filepath.Join(dir.data, "signatures")
+}
+
+func (dir *Local) aclPath() string {
+	return filepath.Join(dir.groups, "acl")
+}
+
 func (dir *Local) loadIndex() ([]note.Note, error) {
-	r, err := dbline.Open[*note.Note](dir.data + "/index")
+	r, err := dir.readIndex()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, note.ErrNotFound
@@ -216,6 +420,7 @@ func (dir *Local) loadNodeMetadata(n *note.Note) error {
 			n.Date = note.Date
 			n.Groups = note.Groups
 			n.Tags = note.Tags
+			n.Encrypted = note.Encrypted
 			return nil
 		}
 	}
@@ -231,39 +436,48 @@ func (dir *Local) loadNoteData(n *note.Note) error {
 	if err != nil {
 		return err
 	}
-	n.Data = data
-	return nil
+	n.Data, err = dir.open(n.Id, data)
+	return err
 }
 
 func (dir *Local) addNoteData(n *note.Note) error {
-	return dbline.AppendEntry[*note.Note](dir.data+"/index", n)
+	return dir.appendIndex(n)
 }
 
 func (dir *Local) mkDirs() error {
-	cfg, err := os.UserConfigDir()
-	if err != nil {
-		return err
-	}
+	if dir.root != "" {
+		dir.config = dir.root
+		dir.data = dir.root
+		err := os.MkdirAll(dir.root, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	} else {
+		cfg, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
 
-	data, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
+		data, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
 
-	dir.config = filepath.Join(cfg, dir.dir)
-	err = os.Mkdir(dir.config, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		return err
-	}
+		dir.config = filepath.Join(cfg, dir.dir)
+		err = os.Mkdir(dir.config, os.ModePerm)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
 
-	dir.data = filepath.Join(data, "."+dir.dir)
-	err = os.Mkdir(dir.data, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		return err
+		dir.data = filepath.Join(data, "."+dir.dir)
+		err = os.Mkdir(dir.data, os.ModePerm)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
 	}
 
 	dir.tags = filepath.Join(dir.data, "tags")
-	err = os.Mkdir(dir.tags, os.ModePerm)
+	err := os.Mkdir(dir.tags, os.ModePerm)
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
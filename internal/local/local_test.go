@@ -0,0 +1,27 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+func TestBackend(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "Plain", true: "Encrypted"}[encrypted], func(t *testing.T) {
+			notetest.Run(t, func(t *testing.T) note.Backend {
+				l := NewBackendAt(t.TempDir())
+				if err := l.Init(); err != nil {
+					t.Fatal(err)
+				}
+				if encrypted {
+					if _, err := l.Encrypt([]byte("pass")); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return l
+			})
+		})
+	}
+}
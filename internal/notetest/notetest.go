@@ -0,0 +1,254 @@
+// package notetest checks that backends behave the way the rest of note
+// expects from a note.Backend.
+package notetest
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/serboupal/note/note"
+)
+
+// Run runs the conformance tests on the backends returned by open, which must
+// return a new empty store, already initialized, on every call.
+func Run(t *testing.T, open func(t *testing.T) note.Backend) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, b note.Backend)
+	}{
+		{"CreateGet", testCreateGet},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"Search", testSearch},
+		{"Labels", testLabels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f(t, open(t))
+		})
+	}
+}
+
+func newNote(t *testing.T, name, data string) *note.Note {
+	t.Helper()
+	n, err := note.NewNote(name, "", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func create(t *testing.T, b note.Backend, notes ...*note.Note) {
+	t.Helper()
+	for _, n := range notes {
+		if err := b.Create(n); err != nil {
+			t.Fatalf("create %s: %v", n.Name, err)
+		}
+	}
+}
+
+// names returns the sorted names of list, backends don't agree on an order.
+func names(list []note.Note) string {
+	r := []string{}
+	for _, n := range list {
+		r = append(r, n.Name)
+	}
+	slices.Sort(r)
+	return strings.Join(r, ",")
+}
+
+func testCreateGet(t *testing.T, b note.Backend) {
+	if _, err := b.Get("todo"); !errors.Is(err, note.ErrNotFound) {
+		t.Errorf("get missing: %v, want %v", err, note.ErrNotFound)
+	}
+	n := newNote(t, "todo", "buy milk\n")
+	create(t, b, n, newNote(t, "work/plan", "ship it"))
+
+	got, err := b.Get("todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "todo" || got.Id != n.Id || string(got.Data) != "buy milk\n" {
+		t.Errorf("get: %+v, want %+v", got, n)
+	}
+	if err := got.Check(); err != nil {
+		t.Errorf("note read back fails its check: %v", err)
+	}
+	got, err = b.Get("work/plan")
+	if err != nil || string(got.Data) != "ship it" {
+		t.Errorf("get work/plan: %+v, %v", got, err)
+	}
+
+	if err := b.Create(newNote(t, "todo", "other")); !errors.Is(err, note.ErrNoteExist) {
+		t.Errorf("create existing name: %v, want %v", err, note.ErrNoteExist)
+	}
+	if got, err := b.Get("todo"); err != nil || got.Id != n.Id {
+		t.Errorf("note changed by a failed create: %+v, %v", got, err)
+	}
+}
+
+func testUpdate(t *testing.T, b note.Backend) {
+	create(t, b, newNote(t, "todo", "first"))
+
+	if err := b.Update("todo", []byte("first")); !errors.Is(err, note.ErrNotModified) {
+		t.Errorf("update with the same data: %v, want %v", err, note.ErrNotModified)
+	}
+	if err := b.Update("missing", []byte("x")); !errors.Is(err, note.ErrNotFound) {
+		t.Errorf("update missing: %v, want %v", err, note.ErrNotFound)
+	}
+	if err := b.Update("todo", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Get("todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Data) != "second" || got.Id != newNote(t, "todo", "second").Id {
+		t.Errorf("get after update: %+v", got)
+	}
+	list, err := b.List("")
+	if err != nil || names(list) != "todo" {
+		t.Errorf("list after update: %q, %v", names(list), err)
+	}
+}
+
+func testDelete(t *testing.T, b note.Backend) {
+	create(t, b, newNote(t, "todo", "first"), newNote(t, "keep", "first"))
+	n, err := b.Get("todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(&n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get("todo"); !errors.Is(err, note.ErrNotFound) {
+		t.Errorf("get deleted: %v, want %v", err, note.ErrNotFound)
+	}
+	if got, err := b.Get("keep"); err != nil || string(got.Data) != "first" {
+		t.Errorf("note with the same content: %+v, %v", got, err)
+	}
+	list, err := b.List("")
+	if err != nil || names(list) != "keep" {
+		t.Errorf("list after delete: %q, %v", names(list), err)
+	}
+
+	// the name can be used again
+	create(t, b, newNote(t, "todo", "again"))
+	if got, err := b.Get("todo"); err != nil || string(got.Data) != "again" {
+		t.Errorf("get recreated: %+v, %v", got, err)
+	}
+
+	// a note changed since it was read is not deleted
+	if err := b.Update("todo", []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	stale := newNote(t, "todo", "again")
+	if err := b.Delete(stale); !errors.Is(err, note.ErrNotFound) {
+		t.Errorf("delete stale: %v, want %v", err, note.ErrNotFound)
+	}
+	if got, err := b.Get("todo"); err != nil || string(got.Data) != "changed" {
+		t.Errorf("note changed by a stale delete: %+v, %v", got, err)
+	}
+}
+
+func testList(t *testing.T, b note.Backend) {
+	list, err := b.List("")
+	if err != nil && !errors.Is(err, note.ErrNotFound) {
+		t.Fatalf("list empty store: %v", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("empty store lists %q", names(list))
+	}
+
+	create(t, b,
+		newNote(t, "work/plan", "a"),
+		newNote(t, "work/notes", "b"),
+		newNote(t, "home", "c"),
+	)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "home,work/notes,work/plan"},
+		{"work/", "work/notes,work/plan"},
+		{"work/plan", "work/plan"},
+		{"nope", ""},
+	}
+	for _, tt := range tests {
+		list, err := b.List(tt.name)
+		if err != nil && !(tt.want == "" && errors.Is(err, note.ErrNotFound)) {
+			t.Errorf("list %q: %v", tt.name, err)
+			continue
+		}
+		if got := names(list); got != tt.want {
+			t.Errorf("list %q = %q, want %q", tt.name, got, tt.want)
+		}
+		for _, n := range list {
+			if n.Id == "" || n.Date == nil {
+				t.Errorf("list %q: %s has no id or date", tt.name, n.Name)
+			}
+		}
+	}
+}
+
+func testSearch(t *testing.T, b note.Backend) {
+	sealed := newNote(t, "sealed", "release notes")
+	sealed.Encrypted = true
+	create(t, b,
+		newNote(t, "work/plan", "Ship the Release"),
+		newNote(t, "work/notes", "nothing here"),
+		newNote(t, "home", "release the cat"),
+		sealed,
+	)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"release", "home,work/plan"},
+		{"RELEASE", "home,work/plan"},
+		{"the cat", "home"},
+		{"nope", ""},
+	}
+	for _, tt := range tests {
+		list, err := b.Search(tt.query)
+		if err != nil && !(tt.want == "" && errors.Is(err, note.ErrNotFound)) {
+			t.Errorf("search %q: %v", tt.query, err)
+			continue
+		}
+		if got := names(list); got != tt.want {
+			t.Errorf("search %q = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func testLabels(t *testing.T, b note.Backend) {
+	n := newNote(t, "todo", "first")
+	n.Tags = []string{"a", "b"}
+	n.Groups = []string{"team"}
+	create(t, b, n)
+
+	check := func(when string) {
+		t.Helper()
+		got, err := b.Get("todo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.Tags, n.Tags) || !slices.Equal(got.Groups, n.Groups) {
+			t.Errorf("%s: tags %q groups %q, want %q %q", when, got.Tags, got.Groups, n.Tags, n.Groups)
+		}
+	}
+	check("after create")
+	if err := b.Update("todo", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	check("after update")
+
+	bad := newNote(t, "bad", "x")
+	bad.Tags = []string{"a;b"}
+	if err := b.Create(bad); !errors.Is(err, note.ErrInvalidLabel) {
+		t.Errorf("create with an invalid tag: %v, want %v", err, note.ErrInvalidLabel)
+	}
+}
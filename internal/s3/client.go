@@ -0,0 +1,290 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+// errPrecondition is returned when a conditional write lost against another
+// writer.
+var errPrecondition = errors.New("precondition failed")
+
+const (
+	algorithm   = "AWS4-HMAC-SHA256"
+	amzDate     = "20060102T150405Z"
+	service     = "s3"
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// RequestError is returned when the object store answers with an error.
+type RequestError struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *RequestError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// credentials sign requests with AWS signature version 4.
+type credentials struct {
+	key    string
+	secret string
+	token  string
+	region string
+}
+
+type client struct {
+	http      *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	creds     credentials
+}
+
+type object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+	ETag string `xml:"ETag"`
+}
+
+type listResult struct {
+	Contents              []object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// get returns the object at key and its etag.
+func (c *client) get(key string) ([]byte, string, error) {
+	resp, err := c.do("GET", key, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errResponse(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// put writes data at key. With etag set the write only succeeds if the
+// object didn't change, an etag of * only creates the object.
+func (c *client) put(key string, data []byte, etag string) error {
+	h := http.Header{}
+	switch etag {
+	case "":
+	case "*":
+		h.Set("If-None-Match", "*")
+	default:
+		h.Set("If-Match", etag)
+	}
+	resp, err := c.do("PUT", key, nil, data, h)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+func (c *client) delete(key string) error {
+	resp, err := c.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return errResponse(resp)
+	}
+	return nil
+}
+
+// list returns the objects whose key starts with prefix.
+func (c *client) list(prefix string) ([]object, error) {
+	var r []object
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := c.do("GET", "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := errResponse(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		res := listResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, res.Contents...)
+		if !res.IsTruncated {
+			return r, nil
+		}
+		q.Set("continuation-token", res.NextContinuationToken)
+	}
+}
+
+func (c *client) do(method, key string, query url.Values, body []byte, h http.Header) (*http.Response, error) {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	c.creds.sign(req, body, time.Now())
+	return c.http.Do(req)
+}
+
+// sign adds the headers of a version 4 signature to req made at t.
+func (c credentials) sign(req *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	hash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		hash = hex.EncodeToString(sum[:])
+	}
+	req.Header.Set("X-Amz-Date", t.Format(amzDate))
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+
+	signed, canonical := canonicalRequest(req, hash)
+	scope := strings.Join([]string{t.Format("20060102"), c.region, service, "aws4_request"}, "/")
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{algorithm, t.Format(amzDate), scope, hex.EncodeToString(sum[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secret), t.Format("20060102"))
+	for _, v := range []string{c.region, service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, c.key, scope, signed, sig))
+}
+
+// canonicalRequest returns the signed header names and the canonical form of
+// req. The host and the x-amz and conditional headers are signed.
+func canonicalRequest(req *http.Request, hash string) (string, string) {
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || strings.HasPrefix(k, "if-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.URL.EscapedPath() + "\n")
+	b.WriteString(req.URL.RawQuery + "\n")
+	for _, k := range names {
+		b.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	b.WriteString("\n" + signed + "\n" + hash)
+	return signed, b.String()
+}
+
+// canonicalQuery returns q sorted and escaped as signatures expect.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapePath(p string) string {
+	return escape(p, false)
+}
+
+// escape percent encodes everything but unreserved characters, and / when
+// slash is not set.
+func escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || (c == '/' && !slash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+func errResponse(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return note.ErrNotFound
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return errPrecondition
+	}
+	e := &RequestError{Status: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	xml.Unmarshal(body, e)
+	return e
+}
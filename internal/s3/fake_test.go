@@ -0,0 +1,182 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fake is an in-memory object store that speaks enough of the S3 API for
+// this package: signed object GET, PUT and DELETE with conditional writes,
+// and ListObjectsV2. Buckets are addressed by path and exist on first use.
+type fake struct {
+	creds credentials
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data []byte
+	etag string
+}
+
+var authRe = regexp.MustCompile(`^` + algorithm + ` Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]+)$`)
+
+// newFake returns an empty store that accepts requests signed with key and
+// secret for any region.
+func newFake(key, secret string) *fake {
+	return &fake{
+		creds:   credentials{key: key, secret: secret},
+		objects: map[string]fakeObject{},
+	}
+}
+
+func (f *fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fakeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !f.verify(r, body) {
+		fakeError(w, http.StatusForbidden, "SignatureDoesNotMatch",
+			"The request signature we calculated does not match the signature you provided.")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		fakeError(w, http.StatusBadRequest, "InvalidBucketName", "missing bucket")
+		return
+	}
+	if key == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			fakeError(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported on buckets")
+			return
+		}
+		f.list(w, r, bucket)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := bucket + "/" + key
+	o, ok := f.objects[name]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	case http.MethodPut:
+		if m := r.Header.Get("If-Match"); m != "" && (!ok || m != o.etag) {
+			fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed",
+				"At least one of the pre-conditions you specified did not hold")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && ok {
+			fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed",
+				"At least one of the pre-conditions you specified did not hold")
+			return
+		}
+		sum := md5.Sum(body)
+		o = fakeObject{data: body, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+		f.objects[name] = o
+		w.Header().Set("ETag", o.etag)
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (f *fake) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	prefix := bucket + "/" + q.Get("prefix")
+	max := 1000
+	if v, err := strconv.Atoi(q.Get("max-keys")); err == nil && v > 0 && v < max {
+		max = v
+	}
+
+	f.mu.Lock()
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) && k > bucket+"/"+q.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := listResult{}
+	for _, k := range keys {
+		if len(res.Contents) == max {
+			res.IsTruncated = true
+			res.NextContinuationToken = res.Contents[max-1].Key
+			break
+		}
+		o := f.objects[k]
+		res.Contents = append(res.Contents, object{
+			Key:  strings.TrimPrefix(k, bucket+"/"),
+			Size: int64(len(o.data)),
+			ETag: o.etag,
+		})
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listResult
+	}{listResult: res})
+}
+
+// verify checks the signature of r by computing it again, sign itself is
+// checked against the AWS examples in TestSign.
+func (f *fake) verify(r *http.Request, body []byte) bool {
+	m := authRe.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil || m[1] != f.creds.key {
+		return false
+	}
+	t, err := time.Parse(amzDate, r.Header.Get("X-Amz-Date"))
+	if err != nil || t.Format("20060102") != m[2] {
+		return false
+	}
+
+	// sign a copy with only the headers the client signed
+	req := r.Clone(r.Context())
+	req.Header = http.Header{}
+	for _, h := range strings.Split(m[4], ";") {
+		if h != "host" {
+			req.Header[http.CanonicalHeaderKey(h)] = r.Header.Values(h)
+		}
+	}
+	c := f.creds
+	c.region = m[3]
+	c.token = r.Header.Get("X-Amz-Security-Token")
+	c.sign(req, body, t)
+
+	want := authRe.FindStringSubmatch(req.Header.Get("Authorization"))
+	return want != nil && want[4] == m[4] && subtle.ConstantTimeCompare([]byte(want[5]), []byte(m[5])) == 1
+}
+
+func fakeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, msg)
+}
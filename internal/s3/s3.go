@@ -0,0 +1,440 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+var (
+	ErrNoBucket      = errors.New("missing bucket name")
+	ErrNoCredentials = errors.New("missing credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+)
+
+// retries is how many times a change of the index is tried again after
+// losing against another writer.
+const retries = 10
+
+// S3 keeps notes in a bucket of an S3 compatible object store, so servers
+// using it don't keep state. Note contents are stored under blobs/ with
+// their content id as key, next to the index, acl and signatures objects,
+// all below the prefix of the store. Shared objects are changed with
+// conditional writes.
+type S3 struct {
+	c      *client
+	prefix string
+}
+
+var _ = (note.Backend)(&S3{})
+var _ = (note.Sharer)(&S3{})
+var _ = (note.Tagger)(&S3{})
+var _ = (note.Signer)(&S3{})
+
+// Config holds the settings of the store. Endpoint is only needed for
+// services other than AWS, their buckets are addressed by path.
+type Config struct {
+	Bucket    string
+	Prefix    string
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Token     string
+}
+
+func init() {
+	note.Register("s3", open)
+}
+
+// open returns the backend for s3://bucket/prefix?endpoint=URL&region=NAME.
+// Credentials are read from the AWS environment variables unless the URL
+// has them, s3://KEY:SECRET@bucket.
+func open(u *url.URL) (note.Backend, error) {
+	cfg := Config{
+		Bucket:    u.Host,
+		Prefix:    u.Path,
+		Endpoint:  u.Query().Get("endpoint"),
+		Region:    u.Query().Get("region"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if u.User != nil {
+		cfg.AccessKey = u.User.Username()
+		cfg.SecretKey, _ = u.User.Password()
+		cfg.Token = ""
+	}
+	return NewBackend(cfg)
+}
+
+// NewBackend returns a S3 backend for cfg. The region is us-east-1 by
+// default.
+func NewBackend(cfg Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, ErrNoBucket
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, ErrNoCredentials
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	c := &client{
+		http:   &http.Client{Timeout: 30 * time.Second},
+		bucket: cfg.Bucket,
+		creds: credentials{
+			key:    cfg.AccessKey,
+			secret: cfg.SecretKey,
+			token:  cfg.Token,
+			region: cfg.Region,
+		},
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	} else {
+		c.pathStyle = true
+	}
+	var err error
+	c.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{c: c, prefix: prefix}, nil
+}
+
+func (s *S3) Init() error {
+	return nil
+}
+
+func (s *S3) Create(n *note.Note) error {
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	all, _, err := s.loadIndex()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(all, func(v note.Note) bool { return v.Name == n.Name }) {
+		return note.ErrNoteExist
+	}
+	if err := s.c.put(s.blob(n.Id), n.Data, ""); err != nil {
+		return err
+	}
+	return s.changeIndex(func(all []note.Note) ([]note.Note, error) {
+		if slices.ContainsFunc(all, func(v note.Note) bool { return v.Name == n.Name }) {
+			return nil, note.ErrNoteExist
+		}
+		entry := *n
+		entry.Data = nil
+		return append(all, entry), nil
+	})
+}
+
+func (s *S3) List(name string) ([]note.Note, error) {
+	if note.InvalidName(name) {
+		return nil, note.ErrInvalidName
+	}
+	all, _, err := s.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(all)
+
+	r := []note.Note{}
+	for _, v := range all {
+		if strings.Contains(v.Name, name) {
+			r = append(r, v)
+		}
+	}
+	return r, nil
+}
+
+func (s *S3) Get(name string) (note.Note, error) {
+	if note.InvalidName(name) {
+		return note.Note{}, note.ErrInvalidName
+	}
+	all, _, err := s.loadIndex()
+	if err != nil {
+		return note.Note{}, err
+	}
+	i := slices.IndexFunc(all, func(v note.Note) bool { return v.Name == name })
+	if i < 0 {
+		return note.Note{}, note.ErrNotFound
+	}
+	n := all[i]
+	n.Data, _, err = s.c.get(s.blob(n.Id))
+	if err != nil {
+		return note.Note{}, err
+	}
+	if err = n.Check(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (s *S3) Update(name string, data []byte) error {
+	newNote, err := note.NewNote(name, "", data)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if n.Id == newNote.Id {
+		return note.ErrNotModified
+	}
+
+	err = s.c.put(s.blob(newNote.Id), data, "")
+	if err != nil {
+		return err
+	}
+	var all []note.Note
+	err = s.changeIndex(func(idx []note.Note) ([]note.Note, error) {
+		i := slices.IndexFunc(idx, func(v note.Note) bool { return v.Name == name && v.Id == n.Id })
+		if i < 0 {
+			return nil, note.ErrNotFound
+		}
+		entry := idx[i]
+		entry.Id = newNote.Id
+		entry.Date = newNote.Date
+		// the newest note goes last, like in the local index
+		all = append(slices.Delete(idx, i, i+1), entry)
+		return all, nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.deleteBlob(all, n.Id)
+}
+
+func (s *S3) Delete(n *note.Note) error {
+	var all []note.Note
+	err := s.changeIndex(func(idx []note.Note) ([]note.Note, error) {
+		l := len(idx)
+		all = slices.DeleteFunc(idx, func(v note.Note) bool { return v.Name == n.Name && v.Id == n.Id })
+		if len(all) == l {
+			return nil, note.ErrNotFound
+		}
+		return all, nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.deleteBlob(all, n.Id)
+}
+
+func (s *S3) Search(query string) ([]note.Note, error) {
+	all, _, err := s.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(all)
+
+	r := []note.Note{}
+	for _, n := range all {
+		if n.Encrypted {
+			continue
+		}
+		n.Data, _, err = s.c.get(s.blob(n.Id))
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(strings.ToLower(string(n.Data)), strings.ToLower(query)) {
+			r = append(r, n)
+		}
+	}
+	return r, nil
+}
+
+func (s *S3) Tag(name string, tags []string) error {
+	n := note.Note{Name: name, Tags: tags}
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	return s.changeIndex(func(all []note.Note) ([]note.Note, error) {
+		i := slices.IndexFunc(all, func(v note.Note) bool { return v.Name == name })
+		if i < 0 {
+			return nil, note.ErrNotFound
+		}
+		all[i].Tags = tags
+		return all, nil
+	})
+}
+
+// Ready checks that the bucket can be read.
+func (s *S3) Ready() error {
+	_, err := s.c.list(s.prefix + "index")
+	return err
+}
+
+// Stats returns the number of notes and the bytes used by the store.
+func (s *S3) Stats() (int, int64, error) {
+	objects, err := s.c.list(s.prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, o := range objects {
+		size += o.Size
+	}
+	all, _, err := s.loadIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(all), size, nil
+}
+
+func (s *S3) Share(g note.Grant) error {
+	if err := g.Check(); err != nil {
+		return err
+	}
+	return change(s, "acl", func(grants []note.Grant) ([]note.Grant, error) {
+		grants = slices.DeleteFunc(grants, g.Same)
+		return append(grants, g), nil
+	})
+}
+
+func (s *S3) Unshare(g note.Grant) error {
+	return change(s, "acl", func(grants []note.Grant) ([]note.Grant, error) {
+		l := len(grants)
+		grants = slices.DeleteFunc(grants, g.Same)
+		if len(grants) == l {
+			return nil, note.ErrNotFound
+		}
+		return grants, nil
+	})
+}
+
+func (s *S3) Grants() ([]note.Grant, error) {
+	r, _, err := load[*note.Grant](s, "acl")
+	if r == nil {
+		r = []note.Grant{}
+	}
+	return r, err
+}
+
+// AddSignature keeps sig, which must sign the current revision of name.
+// Signatures of every revision are kept.
+func (s *S3) AddSignature(name string, sig note.Signature) error {
+	if sig.Name != name {
+		return note.ErrInvalidSignature
+	}
+	if err := sig.Verify(); err != nil {
+		return err
+	}
+	all, _, err := s.loadIndex()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(all, func(v note.Note) bool { return v.Name == name })
+	if i < 0 {
+		return note.ErrNotFound
+	}
+	if all[i].Id != sig.Id {
+		return note.ErrStaleSignature
+	}
+	return change(s, "signatures", func(all []note.Signature) ([]note.Signature, error) {
+		return append(all, sig), nil
+	})
+}
+
+// Signatures returns the signatures of every revision of name, oldest first.
+func (s *S3) Signatures(name string) ([]note.Signature, error) {
+	all, _, err := load[*note.Signature](s, "signatures")
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Signature{}
+	for _, sig := range all {
+		if sig.Name == name {
+			r = append(r, sig)
+		}
+	}
+	return r, nil
+}
+
+func (s *S3) blob(id string) string {
+	return s.prefix + "blobs/" + id
+}
+
+func (s *S3) loadIndex() ([]note.Note, string, error) {
+	return load[*note.Note](s, "index")
+}
+
+func (s *S3) changeIndex(f func([]note.Note) ([]note.Note, error)) error {
+	return change(s, "index", f)
+}
+
+// deleteBlob removes the content id unless a note in all still uses it.
+func (s *S3) deleteBlob(all []note.Note, id string) error {
+	if slices.ContainsFunc(all, func(v note.Note) bool { return v.Id == id }) {
+		return nil
+	}
+	return s.c.delete(s.blob(id))
+}
+
+// load returns the entries of the dbline object name and its etag, * if it
+// doesn't exist yet.
+func load[P interface {
+	*T
+	String() string
+	Parse(s string) error
+}, T any](s *S3, name string) ([]T, string, error) {
+	data, etag, err := s.c.get(s.prefix + name)
+	if errors.Is(err, note.ErrNotFound) {
+		return nil, "*", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := dbline.Decode[P](bytes.NewReader(data))
+	return r, etag, err
+}
+
+// change replaces the entries of the dbline object name with the ones f
+// returns, starting again when another writer changed them first.
+func change[P interface {
+	*T
+	String() string
+	Parse(s string) error
+}, T any](s *S3, name string, f func([]T) ([]T, error)) error {
+	for i := 0; ; i++ {
+		all, etag, err := load[P](s, name)
+		if err != nil {
+			return err
+		}
+		all, err = f(all)
+		if err != nil {
+			return err
+		}
+		p := make([]P, len(all))
+		for i := range all {
+			p[i] = &all[i]
+		}
+		err = s.c.put(s.prefix+name, dbline.Encode(p), etag)
+		if !errors.Is(err, errPrecondition) || i == retries {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * 50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,532 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/serboupal/note/note"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	ErrInvalidPath = errors.New("invalid path")
+)
+
+// SQLite keeps notes in a single database file. Every revision of a note is
+// kept until the note is deleted, and note contents are indexed with FTS5
+// for Search.
+type SQLite struct {
+	path string
+	db   *sql.DB
+}
+
+var _ = (note.Backend)(&SQLite{})
+var _ = (note.Sharer)(&SQLite{})
+var _ = (note.Tagger)(&SQLite{})
+var _ = (note.Signer)(&SQLite{})
+
+const schema = `
+CREATE TABLE IF NOT EXISTS notes (
+	name      TEXT PRIMARY KEY,
+	id        TEXT NOT NULL,
+	date      INTEGER NOT NULL,
+	encrypted INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS revisions (
+	name TEXT NOT NULL REFERENCES notes (name) ON DELETE CASCADE,
+	id   TEXT NOT NULL,
+	date INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (name, id)
+);
+CREATE TABLE IF NOT EXISTS tags (
+	name TEXT NOT NULL REFERENCES notes (name) ON DELETE CASCADE,
+	pos  INTEGER NOT NULL,
+	tag  TEXT NOT NULL,
+	PRIMARY KEY (name, pos)
+);
+CREATE INDEX IF NOT EXISTS tags_tag ON tags (tag);
+CREATE TABLE IF NOT EXISTS groups (
+	name  TEXT NOT NULL REFERENCES notes (name) ON DELETE CASCADE,
+	pos   INTEGER NOT NULL,
+	grp   TEXT NOT NULL,
+	PRIMARY KEY (name, pos)
+);
+CREATE INDEX IF NOT EXISTS groups_grp ON groups (grp);
+CREATE TABLE IF NOT EXISTS grants (
+	name  TEXT NOT NULL,
+	grp   TEXT NOT NULL,
+	user  TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	PRIMARY KEY (name, grp, user)
+);
+CREATE TABLE IF NOT EXISTS signatures (
+	id     TEXT NOT NULL,
+	name   TEXT NOT NULL,
+	author TEXT NOT NULL,
+	key    TEXT NOT NULL,
+	date   TEXT NOT NULL,
+	sig    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS signatures_name ON signatures (name);
+CREATE VIRTUAL TABLE IF NOT EXISTS search USING fts5 (
+	body,
+	tokenize = 'trigram'
+);
+`
+
+// reindex rebuilds search from the current revisions, for databases made
+// when its rows were keyed by name instead of the rowid of the note.
+const reindex = `
+DROP TABLE search;
+CREATE VIRTUAL TABLE search USING fts5 (
+	body,
+	tokenize = 'trigram'
+);
+INSERT INTO search (rowid, body)
+	SELECT n.rowid, CAST(r.data AS TEXT) FROM notes n
+	JOIN revisions r ON r.name = n.name AND r.id = n.id
+	WHERE n.encrypted = 0;
+`
+
+// columns are read by scan, labels are joined with ; which they can't
+// contain.
+const columns = `n.name, n.id, n.date, n.encrypted,
+	(SELECT group_concat(tag, ';') FROM (SELECT tag FROM tags WHERE name = n.name ORDER BY pos)),
+	(SELECT group_concat(grp, ';') FROM (SELECT grp FROM groups WHERE name = n.name ORDER BY pos))`
+
+func init() {
+	note.Register("sqlite", open)
+}
+
+// open returns a SQLite backend for the database file at the path of u, like
+// sqlite:///home/me/notes.db, or sqlite:notes.db for a relative path.
+func open(u *url.URL) (note.Backend, error) {
+	p := u.Path
+	if u.Opaque != "" {
+		p = u.Opaque
+	}
+	if p == "" || (u.Host != "" && u.Host != "localhost") {
+		return nil, ErrInvalidPath
+	}
+	return NewBackend(filepath.FromSlash(p)), nil
+}
+
+// NewBackend returns a SQLite backend that uses the database file at path,
+// created by Init if missing.
+func NewBackend(path string) *SQLite {
+	return &SQLite{path: path}
+}
+
+func (s *SQLite) Init() error {
+	if s.db != nil {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", s.path+
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	err = s.migrate()
+	if err != nil {
+		db.Close()
+		s.db = nil
+		return err
+	}
+	return nil
+}
+
+// migrate updates the search index of databases made by older versions.
+func (s *SQLite) migrate() error {
+	var old int
+	err := s.db.QueryRow(`SELECT count(*) FROM pragma_table_info('search') WHERE name = 'name'`).Scan(&old)
+	if err != nil || old == 0 {
+		return err
+	}
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(reindex)
+		return err
+	})
+}
+
+func (s *SQLite) Create(n *note.Note) error {
+	if note.InvalidName(n.Name) {
+		return note.ErrInvalidName
+	}
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	date := time.Now()
+	if n.Date != nil {
+		date = *n.Date
+	}
+
+	return s.tx(func(tx *sql.Tx) error {
+		var one int
+		err := tx.QueryRow(`SELECT 1 FROM notes WHERE name = ?`, n.Name).Scan(&one)
+		if err == nil {
+			return note.ErrNoteExist
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO notes (name, id, date, encrypted) VALUES (?, ?, ?, ?)`,
+			n.Name, n.Id, date.UnixNano(), n.Encrypted)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO revisions (name, id, date, data) VALUES (?, ?, ?, ?)`,
+			n.Name, n.Id, date.UnixNano(), n.Data)
+		if err != nil {
+			return err
+		}
+		if err := setLabels(tx, "tags", "tag", n.Name, n.Tags); err != nil {
+			return err
+		}
+		if err := setLabels(tx, "groups", "grp", n.Name, n.Groups); err != nil {
+			return err
+		}
+		return index(tx, n.Name, n.Data, n.Encrypted)
+	})
+}
+
+func (s *SQLite) Get(name string) (note.Note, error) {
+	if note.InvalidName(name) {
+		return note.Note{}, note.ErrInvalidName
+	}
+	r, err := s.query(`SELECT `+columns+`, r.data FROM notes n
+		JOIN revisions r ON r.name = n.name AND r.id = n.id
+		WHERE n.name = ?`, true, name)
+	if err != nil {
+		return note.Note{}, err
+	}
+	if len(r) == 0 {
+		return note.Note{}, note.ErrNotFound
+	}
+	if err := r[0].Check(); err != nil {
+		return r[0], err
+	}
+	return r[0], nil
+}
+
+func (s *SQLite) Update(name string, data []byte) error {
+	newNote, err := note.NewNote(name, "", data)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if n.Id == newNote.Id {
+		return note.ErrNotModified
+	}
+
+	return s.tx(func(tx *sql.Tx) error {
+		// going back to the content of an older revision makes it the
+		// newest one again
+		_, err := tx.Exec(`INSERT OR REPLACE INTO revisions (name, id, date, data) VALUES (?, ?, ?, ?)`,
+			name, newNote.Id, newNote.Date.UnixNano(), data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE notes SET id = ?, date = ? WHERE name = ?`,
+			newNote.Id, newNote.Date.UnixNano(), name)
+		if err != nil {
+			return err
+		}
+		return index(tx, name, data, n.Encrypted)
+	})
+}
+
+func (s *SQLite) Delete(n *note.Note) error {
+	return s.tx(func(tx *sql.Tx) error {
+		var rowid int64
+		err := tx.QueryRow(`SELECT rowid FROM notes WHERE name = ? AND id = ?`, n.Name, n.Id).Scan(&rowid)
+		if errors.Is(err, sql.ErrNoRows) {
+			return note.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM search WHERE rowid = ?`, rowid)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM notes WHERE rowid = ?`, rowid)
+		return err
+	})
+}
+
+func (s *SQLite) List(name string) ([]note.Note, error) {
+	if note.InvalidName(name) {
+		return nil, note.ErrInvalidName
+	}
+	return s.query(`SELECT `+columns+` FROM notes n
+		WHERE instr(n.name, ?) > 0
+		ORDER BY n.date DESC`, false, name)
+}
+
+// Search finds notes whose content contains query, ignoring case. Queries of
+// three characters or more use the full text index, best matches first.
+func (s *SQLite) Search(query string) ([]note.Note, error) {
+	if utf8.RuneCountInString(query) < 3 {
+		return s.query(`SELECT `+columns+`, r.data FROM search s
+			JOIN notes n ON n.rowid = s.rowid
+			JOIN revisions r ON r.name = n.name AND r.id = n.id
+			WHERE s.body LIKE ? ESCAPE '\'
+			ORDER BY n.date DESC`, true, "%"+escapeLike(query)+"%")
+	}
+	return s.query(`SELECT `+columns+`, r.data FROM search s
+		JOIN notes n ON n.rowid = s.rowid
+		JOIN revisions r ON r.name = n.name AND r.id = n.id
+		WHERE search MATCH ?
+		ORDER BY s.rank`, true, `"`+strings.ReplaceAll(query, `"`, `""`)+`"`)
+}
+
+func (s *SQLite) Tag(name string, tags []string) error {
+	n := note.Note{Name: name, Tags: tags}
+	if err := n.CheckLabels(); err != nil {
+		return err
+	}
+	return s.tx(func(tx *sql.Tx) error {
+		var one int
+		err := tx.QueryRow(`SELECT 1 FROM notes WHERE name = ?`, name).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return note.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return setLabels(tx, "tags", "tag", name, tags)
+	})
+}
+
+// Ready checks that the database can be read and its folder written.
+func (s *SQLite) Ready() error {
+	var c int
+	err := s.db.QueryRow(`SELECT count(*) FROM notes`).Scan(&c)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".ready")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Stats returns the number of notes and the size of the database files.
+func (s *SQLite) Stats() (int, int64, error) {
+	var c int
+	err := s.db.QueryRow(`SELECT count(*) FROM notes`).Scan(&c)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, p := range []string{s.path, s.path + "-wal"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, 0, err
+		}
+		size += fi.Size()
+	}
+	return c, size, nil
+}
+
+func (s *SQLite) Share(g note.Grant) error {
+	if err := g.Check(); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO grants (name, grp, user, scope) VALUES (?, ?, ?, ?)`,
+		g.Name, g.Group, g.User, string(g.Scope))
+	return err
+}
+
+func (s *SQLite) Unshare(g note.Grant) error {
+	res, err := s.db.Exec(`DELETE FROM grants WHERE name = ? AND grp = ? AND user = ?`,
+		g.Name, g.Group, g.User)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil || c == 0 {
+		return note.ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Grants() ([]note.Grant, error) {
+	rows, err := s.db.Query(`SELECT name, grp, user, scope FROM grants ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := []note.Grant{}
+	for rows.Next() {
+		g := note.Grant{}
+		if err := rows.Scan(&g.Name, &g.Group, &g.User, &g.Scope); err != nil {
+			return nil, err
+		}
+		r = append(r, g)
+	}
+	return r, rows.Err()
+}
+
+// AddSignature keeps sig, which must sign the current revision of name.
+// Signatures of every revision are kept.
+func (s *SQLite) AddSignature(name string, sig note.Signature) error {
+	if sig.Name != name {
+		return note.ErrInvalidSignature
+	}
+	if err := sig.Verify(); err != nil {
+		return err
+	}
+	id := ""
+	err := s.db.QueryRow(`SELECT id FROM notes WHERE name = ?`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return note.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if id != sig.Id {
+		return note.ErrStaleSignature
+	}
+	_, err = s.db.Exec(`INSERT INTO signatures (id, name, author, key, date, sig) VALUES (?, ?, ?, ?, ?, ?)`,
+		sig.Id, sig.Name, sig.Author, sig.Key, sig.Date.Format(time.RFC3339), sig.Sig)
+	return err
+}
+
+// Signatures returns the signatures of every revision of name, oldest first.
+func (s *SQLite) Signatures(name string) ([]note.Signature, error) {
+	rows, err := s.db.Query(`SELECT id, name, author, key, date, sig FROM signatures
+		WHERE name = ? ORDER BY rowid`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := []note.Signature{}
+	for rows.Next() {
+		sig := note.Signature{}
+		var date string
+		if err := rows.Scan(&sig.Id, &sig.Name, &sig.Author, &sig.Key, &date, &sig.Sig); err != nil {
+			return nil, err
+		}
+		ti, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return nil, err
+		}
+		sig.Date = &ti
+		r = append(r, sig)
+	}
+	return r, rows.Err()
+}
+
+// query returns the notes selected by q, which starts with columns and is
+// followed by the data column when withData is set.
+func (s *SQLite) query(q string, withData bool, args ...any) ([]note.Note, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := []note.Note{}
+	for rows.Next() {
+		n := note.Note{}
+		var date int64
+		var tags, groups sql.NullString
+		dest := []any{&n.Name, &n.Id, &date, &n.Encrypted, &tags, &groups}
+		if withData {
+			dest = append(dest, &n.Data)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		ti := time.Unix(0, date)
+		n.Date = &ti
+		n.Tags = splitLabels(tags.String)
+		n.Groups = splitLabels(groups.String)
+		r = append(r, n)
+	}
+	return r, rows.Err()
+}
+
+func (s *SQLite) tx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setLabels replaces the labels of the note name kept in col of table.
+func setLabels(tx *sql.Tx, table, col, name string, labels []string) error {
+	_, err := tx.Exec(`DELETE FROM `+table+` WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	for i, l := range labels {
+		_, err := tx.Exec(`INSERT INTO `+table+` (name, pos, `+col+`) VALUES (?, ?, ?)`, name, i, l)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index makes data the searchable content of the note name, kept in the
+// search row with the rowid of the note. Encrypted notes are left out.
+func index(tx *sql.Tx, name string, data []byte, encrypted bool) error {
+	var rowid int64
+	err := tx.QueryRow(`SELECT rowid FROM notes WHERE name = ?`, name).Scan(&rowid)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM search WHERE rowid = ?`, rowid)
+	if err != nil || encrypted {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO search (rowid, body) VALUES (?, ?)`, rowid, string(data))
+	return err
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+func splitLabels(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ";")
+}
@@ -0,0 +1,19 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+func TestBackend(t *testing.T) {
+	notetest.Run(t, func(t *testing.T) note.Backend {
+		s := NewBackend(filepath.Join(t.TempDir(), "notes.db"))
+		if err := s.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
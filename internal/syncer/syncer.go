@@ -0,0 +1,299 @@
+// package syncer reconciles two backends using note content ids. The id each
+// note had on both sides after the last sync is kept in a state file, so a
+// side whose id differs from the state has changed since then.
+package syncer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+var ErrNoConflict = errors.New("note is not in conflict")
+
+type Side int
+
+const (
+	Local Side = iota
+	Remote
+)
+
+type Action int
+
+const (
+	Push Action = iota
+	Pull
+	DeleteLocal
+	DeleteRemote
+	Conflict
+)
+
+func (a Action) String() string {
+	switch a {
+	case Push:
+		return "push"
+	case Pull:
+		return "pull"
+	case DeleteLocal:
+		return "delete local"
+	case DeleteRemote:
+		return "delete remote"
+	case Conflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Change is a note that was synced, or left untouched on conflict.
+type Change struct {
+	Name   string
+	Action Action
+}
+
+type entry struct {
+	id   string
+	name string
+}
+
+func (e *entry) String() string {
+	return fmt.Sprintf("%s,%s", e.id, e.name)
+}
+
+func (e *entry) Parse(s string) error {
+	id, name, ok := strings.Cut(s, ",")
+	if !ok {
+		return fmt.Errorf("invalid sync state string")
+	}
+	e.id = id
+	e.name = name
+	return nil
+}
+
+type Syncer struct {
+	local  note.Backend
+	remote note.Backend
+	url    string
+	state  string
+}
+
+// New returns a Syncer between local and the remote at url keeping its state
+// in the file state. A state recorded with another url is not used: notes
+// missing on a new remote are pushed instead of deleted locally.
+func New(local, remote note.Backend, url, state string) *Syncer {
+	return &Syncer{local: local, remote: remote, url: url, state: state}
+}
+
+// Sync applies changes made on each side since the last sync to the other
+// one. Notes changed on both sides are reported as Conflict and left as they
+// are until resolved.
+func (s *Syncer) Sync() ([]Change, error) {
+	state, err := s.loadState()
+	if err != nil {
+		return nil, err
+	}
+	l, err := ids(s.local)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ids(s.remote)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, m := range []map[string]string{l, r, state} {
+		for k := range m {
+			if !slices.Contains(names, k) {
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		li, ri, si := l[name], r[name], state[name]
+		var a Action
+		switch {
+		case li == ri:
+			setState(state, name, li)
+			continue
+		case li == si && ri == "":
+			a = DeleteLocal
+		case li == si:
+			a = Pull
+		case ri == si && li == "":
+			a = DeleteRemote
+		case ri == si:
+			a = Push
+		default:
+			changes = append(changes, Change{Name: name, Action: Conflict})
+			continue
+		}
+
+		err := s.apply(name, a)
+		if err != nil {
+			s.saveState(state)
+			return changes, fmt.Errorf("%s %s: %w", a, name, err)
+		}
+		if a == Pull || a == DeleteLocal {
+			setState(state, name, ri)
+		} else {
+			setState(state, name, li)
+		}
+		changes = append(changes, Change{Name: name, Action: a})
+	}
+	return changes, s.saveState(state)
+}
+
+// Resolve ends the conflict of name keeping the version of side, the other
+// side is overwritten.
+func (s *Syncer) Resolve(name string, keep Side) error {
+	state, err := s.loadState()
+	if err != nil {
+		return err
+	}
+	l, err := ids(s.local)
+	if err != nil {
+		return err
+	}
+	r, err := ids(s.remote)
+	if err != nil {
+		return err
+	}
+	li, ri := l[name], r[name]
+	if li == ri || li == state[name] || ri == state[name] {
+		return ErrNoConflict
+	}
+
+	a := Push
+	if keep == Remote {
+		a = Pull
+		if ri == "" {
+			a = DeleteLocal
+		}
+	} else if li == "" {
+		a = DeleteRemote
+	}
+	err = s.apply(name, a)
+	if err != nil {
+		return err
+	}
+	if keep == Remote {
+		setState(state, name, ri)
+	} else {
+		setState(state, name, li)
+	}
+	return s.saveState(state)
+}
+
+func (s *Syncer) apply(name string, a Action) error {
+	switch a {
+	case Push:
+		return copyNote(s.local, s.remote, name)
+	case Pull:
+		return copyNote(s.remote, s.local, name)
+	case DeleteLocal:
+		return deleteNote(s.local, name)
+	case DeleteRemote:
+		return deleteNote(s.remote, name)
+	}
+	return nil
+}
+
+func copyNote(from, to note.Backend, name string) error {
+	src, err := from.Get(name)
+	if err != nil {
+		return err
+	}
+	_, err = to.Get(name)
+	if errors.Is(err, note.ErrNotFound) {
+		n, err := note.NewNote(name, "", src.Data)
+		if err != nil {
+			return err
+		}
+		n.Tags = src.Tags
+		n.Groups = src.Groups
+		n.Encrypted = src.Encrypted
+		return to.Create(n)
+	}
+	if err != nil && !errors.Is(err, note.ErrIntegrityFail) {
+		return err
+	}
+	err = to.Update(name, src.Data)
+	if errors.Is(err, note.ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+func deleteNote(b note.Backend, name string) error {
+	n, err := b.Get(name)
+	if err != nil && !errors.Is(err, note.ErrIntegrityFail) {
+		if errors.Is(err, note.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return b.Delete(&n)
+}
+
+// ids returns the content id of every note in b by name.
+func ids(b note.Backend) (map[string]string, error) {
+	list, err := b.List("")
+	if err != nil && !errors.Is(err, note.ErrNotFound) {
+		return nil, err
+	}
+	r := map[string]string{}
+	for _, n := range list {
+		r[n.Name] = n.Id
+	}
+	return r, nil
+}
+
+func setState(state map[string]string, name, id string) {
+	if id == "" {
+		delete(state, name)
+		return
+	}
+	state[name] = id
+}
+
+// remoteEntry returns the state entry recording the remote, notes can't have
+// empty names.
+func (s *Syncer) remoteEntry() *entry {
+	return &entry{id: fmt.Sprintf("%x", sha256.Sum256([]byte(s.url)))}
+}
+
+func (s *Syncer) loadState() (map[string]string, error) {
+	entries, err := dbline.Open[*entry](s.state)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	r := map[string]string{}
+	if !slices.Contains(entries, *s.remoteEntry()) {
+		// missing or recorded against another remote
+		return r, nil
+	}
+	for _, e := range entries {
+		if e.name != "" {
+			r[e.name] = e.id
+		}
+	}
+	return r, nil
+}
+
+func (s *Syncer) saveState(state map[string]string) error {
+	entries := []*entry{s.remoteEntry()}
+	for name, id := range state {
+		entries = append(entries, &entry{id: id, name: name})
+	}
+	return dbline.Save(s.state, entries)
+}
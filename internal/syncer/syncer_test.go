@@ -0,0 +1,194 @@
+package syncer
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/note"
+)
+
+func newStore(t *testing.T) note.Backend {
+	t.Helper()
+	l := local.NewBackendAt(t.TempDir())
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// set makes data the content of name in b, an empty data deletes it.
+func set(t *testing.T, b note.Backend, name, data string) {
+	t.Helper()
+	old, err := b.Get(name)
+	switch {
+	case errors.Is(err, note.ErrNotFound):
+		if data == "" {
+			return
+		}
+		var n *note.Note
+		n, err = note.NewNote(name, "", []byte(data))
+		if err == nil {
+			err = b.Create(n)
+		}
+	case err != nil:
+	case data == "":
+		err = b.Delete(&old)
+	case data != string(old.Data):
+		err = b.Update(name, []byte(data))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// content returns the content of name in b, empty if it doesn't exist.
+func content(t *testing.T, b note.Backend, name string) string {
+	t.Helper()
+	n, err := b.Get(name)
+	if errors.Is(err, note.ErrNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(n.Data)
+}
+
+// newSynced returns a Syncer whose sides had base as content of todo at the
+// last sync, and then local and remote. Empty contents are missing notes.
+func newSynced(t *testing.T, base, l, r string) *Syncer {
+	t.Helper()
+	s := New(newStore(t), newStore(t), "https://a", filepath.Join(t.TempDir(), "sync"))
+	set(t, s.local, "todo", base)
+	set(t, s.remote, "todo", base)
+	if _, err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	set(t, s.local, "todo", l)
+	set(t, s.remote, "todo", r)
+	return s
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name         string
+		base, l, r   string
+		changes      []Change
+		wantL, wantR string
+	}{
+		{"unchanged", "a", "a", "a", nil, "a", "a"},
+		{"local edit", "a", "b", "a", []Change{{"todo", Push}}, "b", "b"},
+		{"remote edit", "a", "a", "b", []Change{{"todo", Pull}}, "b", "b"},
+		{"local new", "", "b", "", []Change{{"todo", Push}}, "b", "b"},
+		{"remote new", "", "", "b", []Change{{"todo", Pull}}, "b", "b"},
+		{"local delete", "a", "", "a", []Change{{"todo", DeleteRemote}}, "", ""},
+		{"remote delete", "a", "a", "", []Change{{"todo", DeleteLocal}}, "", ""},
+		{"same edit", "a", "b", "b", nil, "b", "b"},
+		{"same new", "", "b", "b", nil, "b", "b"},
+		{"both delete", "a", "", "", nil, "", ""},
+		{"both edit", "a", "b", "c", []Change{{"todo", Conflict}}, "b", "c"},
+		{"both new", "", "b", "c", []Change{{"todo", Conflict}}, "b", "c"},
+		{"local edit remote delete", "a", "b", "", []Change{{"todo", Conflict}}, "b", ""},
+		{"local delete remote edit", "a", "", "c", []Change{{"todo", Conflict}}, "", "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSynced(t, tt.base, tt.l, tt.r)
+			changes, err := s.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("changes %v, want %v", changes, tt.changes)
+			}
+			if l, r := content(t, s.local, "todo"), content(t, s.remote, "todo"); l != tt.wantL || r != tt.wantR {
+				t.Errorf("local %q remote %q, want %q %q", l, r, tt.wantL, tt.wantR)
+			}
+
+			// a second sync only reports conflicts left
+			changes, err = s.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range changes {
+				if c.Action != Conflict {
+					t.Errorf("second sync: %v", changes)
+				}
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		base, l, r string
+		keep       Side
+		want       string
+	}{
+		{"keep local edit", "a", "b", "c", Local, "b"},
+		{"keep remote edit", "a", "b", "c", Remote, "c"},
+		{"keep local delete", "a", "", "c", Local, ""},
+		{"keep remote delete", "a", "b", "", Remote, ""},
+		{"keep local over remote delete", "a", "b", "", Local, "b"},
+		{"keep remote over local delete", "a", "", "c", Remote, "c"},
+		{"keep local new", "", "b", "c", Local, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSynced(t, tt.base, tt.l, tt.r)
+			if err := s.Resolve("todo", tt.keep); err != nil {
+				t.Fatal(err)
+			}
+			if l, r := content(t, s.local, "todo"), content(t, s.remote, "todo"); l != tt.want || r != tt.want {
+				t.Errorf("local %q remote %q, want %q", l, r, tt.want)
+			}
+			changes, err := s.Sync()
+			if err != nil || len(changes) != 0 {
+				t.Errorf("sync after resolve: %v, %v", changes, err)
+			}
+		})
+	}
+}
+
+func TestResolveNoConflict(t *testing.T) {
+	for _, c := range []struct{ base, l, r string }{
+		{"a", "a", "a"},
+		{"a", "b", "a"},
+		{"a", "a", "b"},
+		{"a", "b", "b"},
+		{"", "", ""},
+	} {
+		s := newSynced(t, c.base, c.l, c.r)
+		if err := s.Resolve("todo", Local); !errors.Is(err, ErrNoConflict) {
+			t.Errorf("resolve %v: %v, want %v", c, err, ErrNoConflict)
+		}
+	}
+}
+
+func TestSyncOtherRemote(t *testing.T) {
+	for _, tt := range []struct {
+		url  string
+		want Action
+	}{
+		{"https://b", Push},
+		{"https://a", DeleteLocal},
+	} {
+		s := newSynced(t, "a", "a", "a")
+		o := New(s.local, newStore(t), tt.url, s.state)
+		changes, err := o.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Change{{Name: "todo", Action: tt.want}}
+		if !slices.Equal(changes, want) {
+			t.Errorf("empty remote %s: %v, want %v", tt.url, changes, want)
+		}
+		if tt.want == Push && content(t, s.local, "todo") != "a" {
+			t.Errorf("empty remote %s deleted local note", tt.url)
+		}
+	}
+}
@@ -0,0 +1,608 @@
+// Package markdown renders the common subset of Markdown used in notes to
+// HTML: headings, paragraphs, lists, block quotes, code, rules, emphasis,
+// links and images. Raw HTML is escaped and only http, https and mailto URLs
+// are kept, so the output is safe to serve to a browser.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const punct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// maxNesting is the deepest nesting of blocks and spans rendered, deeper
+// content is written as text.
+const maxNesting = 32
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 48em; margin: 2em auto; padding: 0 1em; font: 16px/1.5 sans-serif; color: #222; }
+pre, code { font-family: monospace; background: #f4f4f4; }
+pre { padding: .5em; overflow-x: auto; }
+blockquote { margin-left: 0; padding-left: 1em; border-left: 3px solid #ccc; color: #555; }
+img { max-width: 100%; }
+a { color: #0645ad; }
+</style>
+</head>
+<body>
+{{.Body}}
+</body>
+</html>
+`))
+
+// ToHTML renders src as an HTML fragment.
+func ToHTML(src []byte) []byte {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(text, "\n"), false, 0)
+	return []byte(b.String())
+}
+
+// Document renders src as a standalone HTML page with a minimal stylesheet.
+func Document(title string, src []byte) []byte {
+	var buf bytes.Buffer
+	err := page.Execute(&buf, struct {
+		Title string
+		Body  template.HTML
+	}{title, template.HTML(ToHTML(src))})
+	if err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// renderBlocks writes the block elements in lines. Paragraphs of tight list
+// items are written without <p>.
+func renderBlocks(b *strings.Builder, lines []string, tight bool, depth int) {
+	if depth >= maxNesting {
+		writeTag(b, "p", html.EscapeString(strings.Join(lines, "\n")))
+		return
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trim := strings.TrimSpace(line)
+		switch {
+		case trim == "":
+			i++
+		case isFence(trim):
+			i = renderFence(b, lines, i)
+		case indent(line) >= 4:
+			i = renderIndentedCode(b, lines, i)
+		case heading(trim) > 0:
+			n := heading(trim)
+			text := strings.TrimRight(strings.TrimSpace(trim[n:]), "#")
+			writeTag(b, "h"+strconv.Itoa(n), inline(strings.TrimSpace(text)))
+			i++
+		case isRule(trim):
+			b.WriteString("<hr>\n")
+			i++
+		case strings.HasPrefix(trim, ">"):
+			i = renderQuote(b, lines, i, depth)
+		case isItem(line):
+			i = renderList(b, lines, i, depth)
+		default:
+			i = renderParagraph(b, lines, i, tight)
+		}
+	}
+}
+
+func renderFence(b *strings.Builder, lines []string, i int) int {
+	trim := strings.TrimSpace(lines[i])
+	fence := trim[:3]
+	lang := strings.TrimSpace(strings.TrimLeft(trim, fence[:1]))
+
+	var code []string
+	j := i + 1
+	for ; j < len(lines); j++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[j]), fence) {
+			break
+		}
+		code = append(code, lines[j])
+	}
+
+	b.WriteString("<pre><code")
+	if lang != "" {
+		b.WriteString(` class="language-` + html.EscapeString(strings.Fields(lang)[0]) + `"`)
+	}
+	b.WriteString(">")
+	for _, v := range code {
+		b.WriteString(html.EscapeString(v) + "\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return j + 1
+}
+
+func renderIndentedCode(b *strings.Builder, lines []string, i int) int {
+	var code []string
+	j := i
+	for ; j < len(lines); j++ {
+		if strings.TrimSpace(lines[j]) != "" && indent(lines[j]) < 4 {
+			break
+		}
+		code = append(code, strip(lines[j], 4))
+	}
+	for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+		code = code[:len(code)-1]
+	}
+
+	b.WriteString("<pre><code>")
+	for _, v := range code {
+		b.WriteString(html.EscapeString(v) + "\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return j
+}
+
+func renderQuote(b *strings.Builder, lines []string, i int, depth int) int {
+	var quote []string
+	j := i
+	for ; j < len(lines); j++ {
+		trim := strings.TrimSpace(lines[j])
+		if !strings.HasPrefix(trim, ">") {
+			break
+		}
+		trim = strings.TrimPrefix(trim, ">")
+		quote = append(quote, strings.TrimPrefix(trim, " "))
+	}
+
+	b.WriteString("<blockquote>\n")
+	renderBlocks(b, quote, false, depth+1)
+	b.WriteString("</blockquote>\n")
+	return j
+}
+
+func renderList(b *strings.Builder, lines []string, i int, depth int) int {
+	ordered, start, width, _ := item(lines[i])
+	var items [][]string
+	tight := true
+
+	j := i
+	for j < len(lines) {
+		line := lines[j]
+		if o, _, w, content := item(line); w > 0 && o == ordered && indent(line) < width {
+			items = append(items, []string{content})
+			width = w
+			j++
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			// a blank line ends the list unless the item continues after it
+			if j+1 < len(lines) && (indent(lines[j+1]) >= width || sameList(lines[j+1], ordered, width)) {
+				tight = false
+				items[len(items)-1] = append(items[len(items)-1], "")
+				j++
+				continue
+			}
+			break
+		}
+		if indent(line) >= width {
+			items[len(items)-1] = append(items[len(items)-1], strip(line, width))
+			j++
+			continue
+		}
+		last := items[len(items)-1]
+		if strings.TrimSpace(last[len(last)-1]) != "" && !startsBlock(line) {
+			items[len(items)-1] = append(last, strings.TrimSpace(line))
+			j++
+			continue
+		}
+		break
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if ordered && start != 1 {
+		b.WriteString(` start="` + strconv.Itoa(start) + `"`)
+	}
+	b.WriteString(">\n")
+	for _, v := range items {
+		b.WriteString("<li>")
+		renderBlocks(b, v, tight, depth+1)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return j
+}
+
+func sameList(line string, ordered bool, width int) bool {
+	o, _, w, _ := item(line)
+	return w > 0 && o == ordered && indent(line) < width
+}
+
+func renderParagraph(b *strings.Builder, lines []string, i int, tight bool) int {
+	var para []string
+	j := i
+	for ; j < len(lines); j++ {
+		trim := strings.TrimSpace(lines[j])
+		if trim == "" {
+			break
+		}
+		if len(para) > 0 && setext(trim) > 0 {
+			writeTag(b, "h"+strconv.Itoa(setext(trim)), inline(strings.Join(para, "\n")))
+			return j + 1
+		}
+		if len(para) > 0 && startsBlock(lines[j]) {
+			break
+		}
+		para = append(para, strings.TrimLeft(lines[j], " \t"))
+	}
+
+	text := inline(strings.Join(para, "\n"))
+	if tight {
+		b.WriteString(text)
+	} else {
+		writeTag(b, "p", text)
+	}
+	return j
+}
+
+func writeTag(b *strings.Builder, tag, content string) {
+	b.WriteString("<" + tag + ">" + content + "</" + tag + ">\n")
+}
+
+func startsBlock(line string) bool {
+	trim := strings.TrimSpace(line)
+	return isFence(trim) || heading(trim) > 0 || isRule(trim) ||
+		strings.HasPrefix(trim, ">") || isItem(line)
+}
+
+func isFence(trim string) bool {
+	return strings.HasPrefix(trim, "```") || strings.HasPrefix(trim, "~~~")
+}
+
+// heading returns the level of an ATX heading or zero.
+func heading(trim string) int {
+	n := 0
+	for n < len(trim) && trim[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(trim) && trim[n] != ' ') {
+		return 0
+	}
+	return n
+}
+
+// setext returns the level of the heading underlined by trim or zero.
+func setext(trim string) int {
+	if strings.Trim(trim, "=") == "" {
+		return 1
+	}
+	if len(trim) >= 2 && strings.Trim(trim, "-") == "" {
+		return 2
+	}
+	return 0
+}
+
+func isRule(trim string) bool {
+	s := strings.ReplaceAll(trim, " ", "")
+	if len(s) < 3 {
+		return false
+	}
+	return strings.Trim(s, "-") == "" || strings.Trim(s, "*") == "" || strings.Trim(s, "_") == ""
+}
+
+func isItem(line string) bool {
+	_, _, w, _ := item(line)
+	return w > 0 && !isRule(strings.TrimSpace(line))
+}
+
+// item parses a list item line, width is the column where its content
+// starts or zero if line is not an item.
+func item(line string) (ordered bool, start int, width int, content string) {
+	n := indent(line)
+	s := strings.TrimLeft(line, " \t")
+	if s == "" {
+		return
+	}
+	m := 0
+	if strings.ContainsRune("-*+", rune(s[0])) {
+		m = 1
+	} else {
+		for m < len(s) && m < 9 && s[m] >= '0' && s[m] <= '9' {
+			m++
+		}
+		if m == 0 || m >= len(s) || (s[m] != '.' && s[m] != ')') {
+			return
+		}
+		start, _ = strconv.Atoi(s[:m])
+		ordered = true
+		m++
+	}
+	if m < len(s) && s[m] != ' ' {
+		return false, 0, 0, ""
+	}
+	if m == len(s) {
+		return ordered, start, n + m + 1, ""
+	}
+	return ordered, start, n + m + 1, s[m+1:]
+}
+
+func indent(line string) int {
+	n := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4 - n%4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// strip removes up to n columns of indentation from line.
+func strip(line string, n int) string {
+	col := 0
+	for i, c := range line {
+		if col >= n || (c != ' ' && c != '\t') {
+			return line[i:]
+		}
+		if c == '\t' {
+			col += 4 - col%4
+		} else {
+			col++
+		}
+	}
+	return ""
+}
+
+// inline renders the span elements of s.
+func inline(s string) string {
+	return (&inliner{s: s}).render()
+}
+
+// inliner renders the spans of s. Closing delimiters are found with tables
+// built in one pass or with scans that are never repeated, so notes made of
+// unclosed delimiters take linear time.
+type inliner struct {
+	s     string
+	depth int
+
+	brackets []int32       // index of the ] closing the [ at each index, or 0
+	parens   []int32       // same for ( and )
+	ticks    map[int][]int // starts of the backtick runs of each length
+	noCloser map[string]bool
+}
+
+// nested renders s, a span inside the text of in.
+func (in *inliner) nested(s string) string {
+	return (&inliner{s: s, depth: in.depth + 1}).render()
+}
+
+func (in *inliner) render() string {
+	s := in.s
+	if in.depth >= maxNesting {
+		return html.EscapeString(s)
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(punct, s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			end, n := in.codeSpan(i)
+			if end > 0 {
+				b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(s[i+n:end])) + "</code>")
+				i = end + n
+			} else {
+				// a run that opens no span is text
+				b.WriteString(s[i : i+n])
+				i += n
+			}
+			continue
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if text, url, end := in.linkAt(i + 1); end > 0 {
+				if safeURL(url) {
+					b.WriteString(`<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(text) + `">`)
+				} else {
+					b.WriteString(html.EscapeString(text))
+				}
+				i = end
+				continue
+			}
+		case c == '[':
+			if text, url, end := in.linkAt(i); end > 0 {
+				if safeURL(url) {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `">` + in.nested(text) + "</a>")
+				} else {
+					b.WriteString(in.nested(text))
+				}
+				i = end
+				continue
+			}
+		case c == '<':
+			if end := strings.IndexAny(s[i+1:], " \n<>"); end >= 0 && s[i+1+end] == '>' {
+				url := s[i+1 : i+1+end]
+				if strings.Contains(url, ":") && safeURL(url) {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + "</a>")
+					i += end + 2
+					continue
+				}
+			}
+		case c == '*' || c == '_' || c == '~':
+			if out, end := in.emphasis(i); end > 0 {
+				b.WriteString(out)
+				i = end
+				continue
+			}
+		case c == ' ' && strings.HasPrefix(s[i:], "  \n"):
+			b.WriteString("<br>\n")
+			i += 3
+			continue
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// codeSpan returns the start of the run of backticks closing the span
+// opened at i, or zero, and the number of backticks.
+func (in *inliner) codeSpan(i int) (int, int) {
+	s := in.s
+	if in.ticks == nil {
+		in.ticks = map[int][]int{}
+		for j := 0; j < len(s); {
+			if s[j] != '`' {
+				j++
+				continue
+			}
+			k := j
+			for k < len(s) && s[k] == '`' {
+				k++
+			}
+			in.ticks[k-j] = append(in.ticks[k-j], j)
+			j = k
+		}
+	}
+	n := 0
+	for i+n < len(s) && s[i+n] == '`' {
+		n++
+	}
+	runs := in.ticks[n]
+	k := sort.SearchInts(runs, i+n)
+	if k == len(runs) {
+		return 0, n
+	}
+	return runs[k], n
+}
+
+// linkAt parses [text](url "title") starting at i and returns the index
+// after it, or zero if there is no link.
+func (in *inliner) linkAt(i int) (text string, url string, end int) {
+	s := in.s
+	if in.brackets == nil {
+		in.brackets = match(s, '[', ']', true)
+		in.parens = match(s, '(', ')', false)
+	}
+	j := int(in.brackets[i])
+	if j == 0 || j+1 >= len(s) || s[j+1] != '(' {
+		return "", "", 0
+	}
+	k := int(in.parens[j+1])
+	if k == 0 {
+		return "", "", 0
+	}
+	dest := strings.TrimSpace(s[j+2 : k])
+	if sp := strings.IndexAny(dest, " \t\n"); sp >= 0 {
+		dest = dest[:sp]
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return s[i+1 : j], dest, k + 1
+}
+
+// match pairs every open byte of s with its close byte. Bytes after a
+// backslash are skipped if escapes is set.
+func match(s string, open, close byte, escapes bool) []int32 {
+	r := make([]int32, len(s))
+	var stack []int32
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case open:
+			stack = append(stack, int32(i))
+		case close:
+			if len(stack) > 0 {
+				r[stack[len(stack)-1]] = int32(i)
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return r
+}
+
+// emphasis renders the *em*, **strong** or ~~del~~ span opened at i.
+func (in *inliner) emphasis(i int) (string, int) {
+	s := in.s
+	c := s[i]
+	n := 0
+	for i+n < len(s) && s[i+n] == c && n < 3 {
+		n++
+	}
+	if c == '~' && n != 2 {
+		return "", 0
+	}
+	if c == '_' && i > 0 && isWord(s[i-1]) {
+		return "", 0
+	}
+	if i+n >= len(s) || s[i+n] == ' ' || s[i+n] == '\n' {
+		return "", 0
+	}
+
+	// whether j closes a marker doesn't depend on the opener, a marker
+	// that found no closer won't find one further on
+	marker := strings.Repeat(string(c), n)
+	if in.noCloser[marker] {
+		return "", 0
+	}
+	for j := i + n; j < len(s); j++ {
+		if !strings.HasPrefix(s[j:], marker) || s[j-1] == ' ' || s[j-1] == '\\' {
+			continue
+		}
+		if j+n < len(s) && s[j+n] == c {
+			continue
+		}
+		if c == '_' && j+n < len(s) && isWord(s[j+n]) {
+			continue
+		}
+		inner := in.nested(s[i+n : j])
+		switch {
+		case c == '~':
+			inner = "<del>" + inner + "</del>"
+		case n == 1:
+			inner = "<em>" + inner + "</em>"
+		case n == 2:
+			inner = "<strong>" + inner + "</strong>"
+		default:
+			inner = "<em><strong>" + inner + "</strong></em>"
+		}
+		return inner, j + n
+	}
+	if in.noCloser == nil {
+		in.noCloser = map[string]bool{}
+	}
+	in.noCloser[marker] = true
+	return "", 0
+}
+
+func isWord(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// safeURL reports if url is relative or uses an allowed scheme.
+func safeURL(url string) bool {
+	i := strings.IndexAny(url, ":/?#")
+	if i < 0 || url[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(url[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"heading", "# Title", "<h1>Title</h1>\n"},
+		{"setext", "Title\n---", "<h2>Title</h2>\n"},
+		{"spans", "a *em* **st** ~~del~~ `c`", "<p>a <em>em</em> <strong>st</strong> <del>del</del> <code>c</code></p>\n"},
+		{"list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"quote", "> q", "<blockquote>\n<p>q</p>\n</blockquote>\n"},
+		{"fence", "```\n<b>\n```", "<pre><code>&lt;b&gt;\n</code></pre>\n"},
+		{"rule", "---", "<hr>\n"},
+		{"link", `[x](/rel "t")`, "<p><a href=\"/rel\">x</a></p>\n"},
+		{"autolink", "<https://a.b/c>", "<p><a href=\"https://a.b/c\">https://a.b/c</a></p>\n"},
+		{"image", "![i](https://x/y.png)", "<p><img src=\"https://x/y.png\" alt=\"i\"></p>\n"},
+		{"escape", `\*a\*`, "<p>*a*</p>\n"},
+		{"intraword underscore", "snake_case_name", "<p>snake_case_name</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(ToHTML([]byte(tt.src))); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"inline html", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>\n"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"upper case scheme", "[x](JaVaScRiPt:alert(1))", "<p>x</p>\n"},
+		{"spaced scheme", "[x]( javascript:alert(1))", "<p>x</p>\n"},
+		{"control before scheme", "[x](\x01javascript:alert(1))", "<p>x</p>\n"},
+		{"tab in scheme", "[x](java\tscript:alert(1))", "<p><a href=\"java\">x</a></p>\n"},
+		{"vbscript", "[x](vbscript:msgbox)", "<p>x</p>\n"},
+		{"data image", "![i](data:image/png;base64,AA)", "<p>i</p>\n"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{"entity scheme", "[x](&#106;avascript:1)", "<p><a href=\"&amp;#106;avascript:1\">x</a></p>\n"},
+		{"quote in href", `[x](http://a"onmouseover="x)`, "<p><a href=\"http://a&#34;onmouseover=&#34;x\">x</a></p>\n"},
+		{"quote in alt", `![a"b](http://x/y.png)`, "<p><img src=\"http://x/y.png\" alt=\"a&#34;b\"></p>\n"},
+		{"html in link text", "[<b>x</b>](/a)", "<p><a href=\"/a\">&lt;b&gt;x&lt;/b&gt;</a></p>\n"},
+		{"html in code", "`<i>`", "<p><code>&lt;i&gt;</code></p>\n"},
+		{"mailto", "[m](mailto:a@b.c)", "<p><a href=\"mailto:a@b.c\">m</a></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(ToHTML([]byte(tt.src))); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentTitle(t *testing.T) {
+	doc := string(Document(`</title><script>x</script>`, []byte("body")))
+	if strings.Contains(doc, "<script>") || !strings.Contains(doc, "<p>body</p>") {
+		t.Errorf("document %q", doc)
+	}
+}
+
+// TestNesting checks that content nested past maxNesting is written as
+// text instead of recursing further.
+func TestNesting(t *testing.T) {
+	deep := 10 * maxNesting
+	tests := []struct {
+		name, src, tag string
+	}{
+		{"quotes", strings.Repeat("> ", deep) + "x", "<blockquote>"},
+		{"lists", func() string {
+			var b strings.Builder
+			for i := 0; i < deep; i++ {
+				b.WriteString(strings.Repeat("  ", i) + "- x\n")
+			}
+			return b.String()
+		}(), "<ul>"},
+		{"emphasis", strings.Repeat("*a ", deep) + "b" + strings.Repeat(" a*", deep), "<em>"},
+		{"links", strings.Repeat("[", deep) + "x" + strings.Repeat("](/a)", deep), "<a "},
+	}
+	for _, tt := range tests {
+		got := string(ToHTML([]byte(tt.src)))
+		if n := strings.Count(got, tt.tag); n == 0 || n > maxNesting {
+			t.Errorf("%s: %d %s elements, want 1 to %d", tt.name, n, tt.tag, maxNesting)
+		}
+	}
+}
+
+// TestPathological renders inputs that take quadratic time with naive
+// delimiter matching. At this size a quadratic renderer takes minutes.
+func TestPathological(t *testing.T) {
+	const n = 1 << 17
+	tests := []struct {
+		name, src string
+	}{
+		{"unclosed emphasis", strings.Repeat("*a ", n)},
+		{"unclosed strong", strings.Repeat("**a ", n)},
+		{"unclosed underscores", strings.Repeat("_a ", n)},
+		{"unclosed strike", strings.Repeat("~~a ", n)},
+		{"mixed", strings.Repeat("*a **b _c ", n/3)},
+		{"closed at the end", strings.Repeat("*a ", n) + "b*"},
+		{"backticks", strings.Repeat("`a ``b ", n/2)},
+		{"brackets", strings.Repeat("[a ", n)},
+		{"links without urls", strings.Repeat("[a](", n)},
+		{"autolinks", strings.Repeat("<a ", n)},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		ToHTML([]byte(tt.src))
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: %d bytes took %v", tt.name, len(tt.src), d)
+		}
+	}
+}
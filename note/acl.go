@@ -0,0 +1,81 @@
+package note
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidGrant = errors.New("invalid share")
+
+// Grant gives User access to the note Name, or to every note in Group.
+// Scope is ScopeRead or ScopeWrite.
+type Grant struct {
+	Name  string `json:"name,omitempty"`
+	Group string `json:"group,omitempty"`
+	User  string `json:"user"`
+	Scope Scope  `json:"scope"`
+}
+
+// Sharer is implemented by backends that keep an access control list for
+// their notes.
+type Sharer interface {
+	Share(g Grant) error
+	Unshare(g Grant) error
+	Grants() ([]Grant, error)
+}
+
+func (g *Grant) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s", g.Name, g.Group, g.User, g.Scope)
+}
+
+func (g *Grant) Parse(s string) error {
+	item := strings.Split(s, ",")
+	if len(item) != 4 {
+		return fmt.Errorf("invalid grant string")
+	}
+	g.Name = item[0]
+	g.Group = item[1]
+	g.User = item[2]
+	g.Scope = Scope(item[3])
+	return g.Check()
+}
+
+func (g *Grant) Check() error {
+	if (g.Name == "") == (g.Group == "") || g.User == "" {
+		return ErrInvalidGrant
+	}
+	if InvalidName(g.Name) || InvalidName(g.User) || strings.Contains(g.Name+g.User, ",") {
+		return ErrInvalidGrant
+	}
+	if g.Group != "" && InvalidLabel(g.Group) {
+		return ErrInvalidGrant
+	}
+	if g.Scope != ScopeRead && g.Scope != ScopeWrite {
+		return ErrInvalidScope
+	}
+	return nil
+}
+
+// Same reports if g and o give access to the same user over the same target.
+func (g *Grant) Same(o Grant) bool {
+	return g.Name == o.Name && g.Group == o.Group && g.User == o.User
+}
+
+// Access returns the widest scope grants give user over n, or an empty scope
+// if n is not shared with user.
+func Access(grants []Grant, n *Note, user string) Scope {
+	var s Scope
+	for _, g := range grants {
+		if g.User != user {
+			continue
+		}
+		if (g.Name != "" && g.Name == n.Name) || (g.Group != "" && slices.Contains(n.Groups, g.Group)) {
+			if !s.Allows(g.Scope) {
+				s = g.Scope
+			}
+		}
+	}
+	return s
+}
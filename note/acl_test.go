@@ -0,0 +1,86 @@
+package note
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGrantCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		g    Grant
+		err  error
+	}{
+		{"note", Grant{Name: "todo", User: "bob", Scope: ScopeRead}, nil},
+		{"note in folder", Grant{Name: "work/plan", User: "bob", Scope: ScopeWrite}, nil},
+		{"group", Grant{Group: "team", User: "bob", Scope: ScopeRead}, nil},
+		{"name and group", Grant{Name: "todo", Group: "team", User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"no target", Grant{User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"no user", Grant{Name: "todo", Scope: ScopeRead}, ErrInvalidGrant},
+		{"invalid name", Grant{Name: "a b", User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"reserved name", Grant{Name: "_/tokens", User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"shared name", Grant{Name: "~amy/todo", User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"comma in name", Grant{Name: "a,b", User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"comma in user", Grant{Name: "todo", User: "bob,amy", Scope: ScopeRead}, ErrInvalidGrant},
+		{"invalid group", Grant{Group: "a;b", User: "bob", Scope: ScopeRead}, ErrInvalidGrant},
+		{"admin scope", Grant{Name: "todo", User: "bob", Scope: ScopeAdmin}, ErrInvalidScope},
+		{"no scope", Grant{Name: "todo", User: "bob"}, ErrInvalidScope},
+		{"scope alias", Grant{Name: "todo", User: "bob", Scope: "rw"}, ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.g.Check(); !errors.Is(err, tt.err) {
+				t.Errorf("Check() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGrantParse(t *testing.T) {
+	for _, g := range []Grant{
+		{Name: "todo", User: "bob", Scope: ScopeRead},
+		{Group: "team", User: "amy", Scope: ScopeWrite},
+	} {
+		got := Grant{}
+		if err := got.Parse(g.String()); err != nil || got != g {
+			t.Errorf("Parse(%q) = %+v, %v", g.String(), got, err)
+		}
+	}
+	for _, s := range []string{"", "todo,,bob", "todo,,bob,read,x", "todo,team,bob,read", "todo,,bob,admin"} {
+		g := Grant{}
+		if err := g.Parse(s); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", s, g)
+		}
+	}
+}
+
+func TestAccess(t *testing.T) {
+	grants := []Grant{
+		{Name: "todo", User: "bob", Scope: ScopeRead},
+		{Group: "team", User: "bob", Scope: ScopeWrite},
+		{Group: "team", User: "amy", Scope: ScopeRead},
+		{Name: "plan", User: "amy", Scope: ScopeWrite},
+		{Group: "other", User: "amy", Scope: ScopeRead},
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		user   string
+		want   Scope
+	}{
+		{"todo", nil, "bob", ScopeRead},
+		{"todo", []string{"team"}, "bob", ScopeWrite},
+		{"todo", []string{"team"}, "amy", ScopeRead},
+		{"plan", []string{"team"}, "amy", ScopeWrite},
+		{"plan", nil, "bob", ""},
+		{"todo", nil, "amy", ""},
+		{"todo", []string{"team"}, "eve", ""},
+		{"todo", []string{"teams"}, "bob", ScopeRead},
+	}
+	for _, tt := range tests {
+		n := &Note{Name: tt.name, Groups: tt.groups}
+		if got := Access(grants, n, tt.user); got != tt.want {
+			t.Errorf("Access(%s %q, %s) = %q, want %q", tt.name, tt.groups, tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package note
+
+import "errors"
+
+// codes are the stable machine readable names of note errors, they are part
+// of the REST API and must not change.
+var codes = []struct {
+	code string
+	err  error
+}{
+	{"invalid_name", ErrInvalidName},
+	{"integrity_fail", ErrIntegrityFail},
+	{"note_exist", ErrNoteExist},
+	{"not_modified", ErrNotModified},
+	{"not_found", ErrNotFound},
+	{"invalid_label", ErrInvalidLabel},
+	{"invalid_scope", ErrInvalidScope},
+	{"invalid_grant", ErrInvalidGrant},
+	{"invalid_signature", ErrInvalidSignature},
+	{"stale_signature", ErrStaleSignature},
+}
+
+// ErrorCode returns the code of err, or an empty string if err is not a note
+// error.
+func ErrorCode(err error) string {
+	for _, v := range codes {
+		if errors.Is(err, v.err) {
+			return v.code
+		}
+	}
+	return ""
+}
+
+// CodeError returns the error with code, or nil if code is unknown.
+func CodeError(code string) error {
+	for _, v := range codes {
+		if v.code == code {
+			return v.err
+		}
+	}
+	return nil
+}
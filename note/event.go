@@ -0,0 +1,31 @@
+package note
+
+import (
+	"context"
+	"time"
+)
+
+// EventType is the kind of change of an Event. Notes can't be renamed in
+// place, a rename is a delete of the old name and a create of the new one.
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a change made to a note. Id is the content id after the change,
+// or before it for deletes.
+type Event struct {
+	Type EventType  `json:"type"`
+	Id   string     `json:"id,omitempty"`
+	Name string     `json:"name"`
+	Date *time.Time `json:"date,omitempty"`
+}
+
+// Watcher is implemented by backends that can stream note changes. Watch
+// calls f for every event until ctx is done or the stream fails.
+type Watcher interface {
+	Watch(ctx context.Context, f func(Event)) error
+}
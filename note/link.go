@@ -0,0 +1,22 @@
+package note
+
+import "time"
+
+// Link is a public read-only URL to a note, anyone knowing it can read the
+// note until it expires or is revoked.
+type Link struct {
+	Id      string     `json:"id,omitempty"`
+	Name    string     `json:"name,omitempty"`
+	Date    *time.Time `json:"date,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	// Secret and URL are only set when the link is created.
+	Secret string `json:"secret,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// Linker is implemented by backends that can publish notes with links.
+type Linker interface {
+	CreateLink(name string, ttl time.Duration) (Link, error)
+	RevokeLink(id string) error
+	Links() ([]Link, error)
+}
@@ -14,8 +14,13 @@ var (
 	ErrNoteExist     = errors.New("note name already exist")
 	ErrNotModified   = errors.New("note not modified")
 	ErrNotFound      = errors.New("note not found")
+	ErrInvalidLabel  = errors.New("invalid tag or group name")
 )
 
+// ReservedPrefix starts the paths of the server endpoints, notes can't use
+// it.
+const ReservedPrefix = "_/"
+
 type Backend interface {
 	Init() error
 	Create(n *Note) error
@@ -26,6 +31,12 @@ type Backend interface {
 	Search(query string) ([]Note, error)
 }
 
+// Tagger is implemented by backends that can change the tags of a note
+// without changing its content.
+type Tagger interface {
+	Tag(name string, tags []string) error
+}
+
 type Note struct {
 	Id     string     `json:"id,omitempty"`
 	Name   string     `json:"name,omitempty"`
@@ -34,6 +45,8 @@ type Note struct {
 	Groups []string   `json:"groups,omitempty"`
 	Size   int        `json:"size,omitempty"`
 	Data   []byte     `json:"data,omitempty"`
+	// Encrypted notes hold a crypt envelope, they are left out of searches.
+	Encrypted bool `json:"encrypted,omitempty"`
 }
 
 func NewNote(name string, title string, data []byte) (*Note, error) {
@@ -53,14 +66,28 @@ func NewNote(name string, title string, data []byte) (*Note, error) {
 }
 
 func (n *Note) String() string {
-	return fmt.Sprintf("%s,%s,%s", n.Id, n.Date.Format(time.DateTime), n.Name)
+	s := fmt.Sprintf("%s,%s,%s", n.Id, n.Date.Format(time.DateTime), n.Name)
+	if len(n.Tags) > 0 || len(n.Groups) > 0 || n.Encrypted {
+		s += "," + strings.Join(n.Tags, ";") + "," + strings.Join(n.Groups, ";")
+	}
+	if n.Encrypted {
+		s += ",encrypted"
+	}
+	return s
 }
 
 func (n *Note) Parse(s string) error {
 	item := strings.Split(s, ",")
-	if len(item) != 3 {
+	if len(item) < 3 || len(item) == 4 || len(item) > 6 {
 		return fmt.Errorf("invalid note string")
 	}
+	if len(item) >= 5 {
+		n.Tags = splitLabels(item[3])
+		n.Groups = splitLabels(item[4])
+	}
+	if len(item) == 6 {
+		n.Encrypted = item[5] == "encrypted"
+	}
 
 	ti, err := time.Parse(time.DateTime, item[1])
 	if err != nil {
@@ -81,12 +108,38 @@ func (n *Note) Check() error {
 	if InvalidName(n.Name) {
 		return ErrInvalidName
 	}
+	return n.CheckLabels()
+}
+
+func (n *Note) CheckLabels() error {
+	for _, l := range [][]string{n.Tags, n.Groups} {
+		for _, v := range l {
+			if InvalidLabel(v) {
+				return ErrInvalidLabel
+			}
+		}
+	}
 	return nil
 }
 
+// InvalidName reports if name can't be used for a note. Names starting with
+// _/ are kept for the server endpoints and names starting with ~ for notes
+// shared by other users, ~owner/name.
 func InvalidName(name string) bool {
 	if strings.ContainsAny(name, " <>:\"|?*") || strings.Contains(name, "..") {
 		return true
 	}
-	return false
+	return strings.HasPrefix(name, ReservedPrefix) || strings.HasPrefix(name, "~")
+}
+
+// InvalidLabel reports if s can't be used as a tag or group name.
+func InvalidLabel(s string) bool {
+	return s == "" || InvalidName(s) || strings.ContainsAny(s, ",;")
+}
+
+func splitLabels(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ";")
 }
@@ -0,0 +1,79 @@
+package note
+
+import "testing"
+
+func TestInvalidName(t *testing.T) {
+	tests := []struct {
+		name    string
+		invalid bool
+	}{
+		{"todo", false},
+		{"work/plan", false},
+		{".hidden", false},
+		{"a~b", false},
+		{"work/~draft", false},
+		{"_x", false},
+		{"a b", true},
+		{"a:b", true},
+		{"a|b", true},
+		{"a?", true},
+		{"<a>", true},
+		{`"a"`, true},
+		{"a*", true},
+		{"..", true},
+		{"a/../b", true},
+		{"_/tokens", true},
+		{"_/", true},
+		{"~", true},
+		{"~bob/todo", true},
+		{"~todo", true},
+	}
+	for _, tt := range tests {
+		if got := InvalidName(tt.name); got != tt.invalid {
+			t.Errorf("InvalidName(%q) = %v, want %v", tt.name, got, tt.invalid)
+		}
+	}
+}
+
+func TestInvalidLabel(t *testing.T) {
+	for _, s := range []string{"", "a,b", "a;b", "a b", "~team", "_/x"} {
+		if !InvalidLabel(s) {
+			t.Errorf("InvalidLabel(%q) = false", s)
+		}
+	}
+	for _, s := range []string{"team", "work/q1", "a-b"} {
+		if InvalidLabel(s) {
+			t.Errorf("InvalidLabel(%q) = true", s)
+		}
+	}
+}
+
+func TestNoteParse(t *testing.T) {
+	n, err := NewNote("todo", "", []byte("milk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, labels := range []struct {
+		tags, groups []string
+		encrypted    bool
+	}{
+		{nil, nil, false},
+		{[]string{"a", "b"}, nil, false},
+		{nil, []string{"team"}, false},
+		{nil, nil, true},
+	} {
+		n.Tags, n.Groups, n.Encrypted = labels.tags, labels.groups, labels.encrypted
+		got := Note{}
+		if err := got.Parse(n.String()); err != nil {
+			t.Fatalf("Parse(%q): %v", n.String(), err)
+		}
+		if got.String() != n.String() {
+			t.Errorf("Parse(%q) read back as %q", n.String(), got.String())
+		}
+	}
+	for _, s := range []string{"", "id,2024-01-01 00:00:00", "id,yesterday,todo", "id,2024-01-01 00:00:00,todo,a"} {
+		if err := (&Note{}).Parse(s); err == nil {
+			t.Errorf("Parse(%q) gave no error", s)
+		}
+	}
+}
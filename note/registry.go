@@ -0,0 +1,63 @@
+package note
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+var ErrUnknownStore = errors.New("unknown store")
+
+// Opener returns the backend configured by u, callers still have to call
+// Init on it.
+type Opener func(u *url.URL) (Backend, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = map[string]Opener{}
+)
+
+// Register makes the backend opened by o available to Open for store URLs
+// with scheme. It is meant to be called from the init function of the
+// backend package and panics if scheme is already registered.
+func Register(scheme string, o Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	if o == nil {
+		panic("note: Register opener is nil")
+	}
+	if _, dup := openers[scheme]; dup {
+		panic("note: Register called twice for " + scheme)
+	}
+	openers[scheme] = o
+}
+
+// Open returns the backend for store, a URL whose scheme selects one of the
+// registered backends, like file:///home/me/notes.
+func Open(store string) (Backend, error) {
+	u, err := url.Parse(store)
+	if err != nil {
+		return nil, err
+	}
+	openersMu.RLock()
+	o, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q, use one of %v", ErrUnknownStore, u.Scheme, Stores())
+	}
+	return o(u)
+}
+
+// Stores returns the registered schemes, sorted.
+func Stores() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+	r := make([]string, 0, len(openers))
+	for k := range openers {
+		r = append(r, k)
+	}
+	slices.Sort(r)
+	return r
+}
@@ -0,0 +1,64 @@
+package note
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+type nopBackend struct {
+	Backend
+	u *url.URL
+}
+
+func TestRegistry(t *testing.T) {
+	Register("regtest", func(u *url.URL) (Backend, error) {
+		return &nopBackend{u: u}, nil
+	})
+	if !slices.Contains(Stores(), "regtest") || !slices.IsSorted(Stores()) {
+		t.Errorf("Stores() = %q", Stores())
+	}
+
+	tests := []struct {
+		store string
+		path  string
+		err   error
+	}{
+		{"regtest:///srv/notes", "/srv/notes", nil},
+		{"regtest://localhost/srv/{user}", "/srv/{user}", nil},
+		{"nope:///srv/notes", "", ErrUnknownStore},
+		{"/srv/notes", "", ErrUnknownStore},
+	}
+	for _, tt := range tests {
+		b, err := Open(tt.store)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Open(%q): %v, want %v", tt.store, err, tt.err)
+			continue
+		}
+		if err == nil && b.(*nopBackend).u.Path != tt.path {
+			t.Errorf("Open(%q) got path %q, want %q", tt.store, b.(*nopBackend).u.Path, tt.path)
+		}
+	}
+	if _, err := Open("regtest://%zz"); err == nil {
+		t.Error("Open of an invalid URL gave no error")
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	open := func(u *url.URL) (Backend, error) { return nil, nil }
+	Register("regdup", open)
+	for name, f := range map[string]func(){
+		"twice": func() { Register("regdup", open) },
+		"nil":   func() { Register("regnil", nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register %s didn't panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,136 @@
+package note
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleSignature   = errors.New("signature is not for the current revision")
+)
+
+// keyPrefix starts the text form of signing public keys.
+const keyPrefix = "ed25519:"
+
+// Signature is made by Author over a revision of a note, identified by its
+// content id. Notes of other users are signed with their name in the store
+// of the owner, without the ~owner/ prefix.
+type Signature struct {
+	Id     string     `json:"id"`
+	Name   string     `json:"name"`
+	Author string     `json:"author"`
+	Key    string     `json:"key"`
+	Date   *time.Time `json:"date"`
+	Sig    string     `json:"sig"`
+}
+
+// Signer is implemented by backends that keep the signatures of notes. name
+// is how the note is addressed, ~owner/name for notes of other users.
+type Signer interface {
+	AddSignature(name string, s Signature) error
+	Signatures(name string) ([]Signature, error)
+}
+
+// OwnerName returns name without its ~owner/ prefix.
+func OwnerName(name string) string {
+	if strings.HasPrefix(name, "~") {
+		if _, n, ok := strings.Cut(name, "/"); ok {
+			return n
+		}
+	}
+	return name
+}
+
+// Sign returns the signature of n by author with priv.
+func Sign(n *Note, author string, priv ed25519.PrivateKey) (Signature, error) {
+	ti := time.Now().UTC().Truncate(time.Second)
+	s := Signature{
+		Id:     n.Id,
+		Name:   OwnerName(n.Name),
+		Author: author,
+		Key:    FormatKey(priv.Public().(ed25519.PublicKey)),
+		Date:   &ti,
+	}
+	if err := s.check(); err != nil {
+		return Signature{}, err
+	}
+	s.Sig = base64.RawStdEncoding.EncodeToString(ed25519.Sign(priv, s.message()))
+	return s, nil
+}
+
+// Verify checks that the signature was made with Key. It doesn't tell if
+// Key belongs to Author.
+func (s *Signature) Verify() error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	pub, err := ParseKey(s.Key)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawStdEncoding.DecodeString(s.Sig)
+	if err != nil || !ed25519.Verify(pub, s.message(), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *Signature) check() error {
+	if len(s.Id) != 64 || s.Date == nil || InvalidName(s.Name) || s.Author == "" ||
+		strings.ContainsAny(s.Author, ",\n") {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *Signature) message() []byte {
+	return []byte(fmt.Sprintf("note-signature v1\n%s\n%s\n%s\n%s\n", s.Name, s.Id, s.Author,
+		s.Date.Format(time.RFC3339)))
+}
+
+func (s *Signature) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s,%s,%s", s.Id, s.Date.Format(time.DateTime), s.Author,
+		s.Key, s.Sig, s.Name)
+}
+
+func (s *Signature) Parse(str string) error {
+	item := strings.SplitN(str, ",", 6)
+	if len(item) != 6 {
+		return fmt.Errorf("invalid signature string")
+	}
+
+	ti, err := time.Parse(time.DateTime, item[1])
+	if err != nil {
+		return err
+	}
+	s.Id = item[0]
+	s.Date = &ti
+	s.Author = item[2]
+	s.Key = item[3]
+	s.Sig = item[4]
+	s.Name = item[5]
+	return nil
+}
+
+// FormatKey returns the text form of pub.
+func FormatKey(pub ed25519.PublicKey) string {
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(pub)
+}
+
+// ParseKey reads a key returned by FormatKey.
+func ParseKey(s string) (ed25519.PublicKey, error) {
+	v, ok := strings.CutPrefix(strings.TrimSpace(s), keyPrefix)
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, ErrInvalidSignature
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,58 @@
+package note
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrInvalidScope = errors.New("invalid token scope")
+
+// Scope limits what a token is allowed to do, every scope includes the ones
+// before it.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+var scopes = []Scope{ScopeRead, ScopeWrite, ScopeAdmin}
+
+func ParseScope(s string) (Scope, error) {
+	switch s {
+	case "ro", "read", "read-only":
+		return ScopeRead, nil
+	case "rw", "write", "read-write":
+		return ScopeWrite, nil
+	case "admin":
+		return ScopeAdmin, nil
+	}
+	return "", ErrInvalidScope
+}
+
+// Allows reports if a token with scope s can perform an operation that needs
+// scope need.
+func (s Scope) Allows(need Scope) bool {
+	i, j := slices.Index(scopes, s), slices.Index(scopes, need)
+	return i >= 0 && j >= 0 && i >= j
+}
+
+type Token struct {
+	Id      string     `json:"id,omitempty"`
+	User    string     `json:"user,omitempty"`
+	Scope   Scope      `json:"scope,omitempty"`
+	Date    *time.Time `json:"date,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	// Secret is only set when the token is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+// TokenManager is implemented by backends that can manage the API tokens of
+// the authenticated user.
+type TokenManager interface {
+	CreateToken(scope Scope, ttl time.Duration) (Token, error)
+	RevokeToken(id string) error
+	Tokens() ([]Token, error)
+}
@@ -0,0 +1,57 @@
+package note
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Scope
+		err  error
+	}{
+		{"ro", ScopeRead, nil},
+		{"read", ScopeRead, nil},
+		{"read-only", ScopeRead, nil},
+		{"rw", ScopeWrite, nil},
+		{"write", ScopeWrite, nil},
+		{"read-write", ScopeWrite, nil},
+		{"admin", ScopeAdmin, nil},
+		{"", "", ErrInvalidScope},
+		{"Admin", "", ErrInvalidScope},
+		{"root", "", ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		got, err := ParseScope(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("ParseScope(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		s, need Scope
+		want    bool
+	}{
+		{ScopeRead, ScopeRead, true},
+		{ScopeRead, ScopeWrite, false},
+		{ScopeRead, ScopeAdmin, false},
+		{ScopeWrite, ScopeRead, true},
+		{ScopeWrite, ScopeWrite, true},
+		{ScopeWrite, ScopeAdmin, false},
+		{ScopeAdmin, ScopeRead, true},
+		{ScopeAdmin, ScopeWrite, true},
+		{ScopeAdmin, ScopeAdmin, true},
+		{"", ScopeRead, false},
+		{"root", ScopeRead, false},
+		{ScopeAdmin, "", false},
+		{ScopeAdmin, "root", false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Allows(tt.need); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.s, tt.need, got, tt.want)
+		}
+	}
+}
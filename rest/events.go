@@ -0,0 +1,115 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+// keepAlive is how often a comment is sent on idle event streams so proxies
+// don't close them.
+const keepAlive = 30 * time.Second
+
+// published is an event of the store of user.
+type published struct {
+	user  string
+	event note.Event
+}
+
+// broker fans out note events to the subscribers of the same store. Slow
+// subscribers miss events instead of blocking the handlers.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan published]string
+}
+
+func newBroker() *broker {
+	return &broker{subs: map[chan published]string{}}
+}
+
+// subscribe returns a channel with the events of the store of user, or every
+// event if all is set.
+func (b *broker) subscribe(user string, all bool) chan published {
+	ch := make(chan published, 64)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if all {
+		user = "*"
+	}
+	b.subs[ch] = user
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan published) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+func (b *broker) publish(user string, e note.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, u := range b.subs {
+		if u != user && u != "*" {
+			continue
+		}
+		select {
+		case ch <- published{user, e}:
+		default:
+		}
+	}
+}
+
+// emit publishes a change to the note addressed by r, in the store of its
+// owner, and queues it for the webhooks.
+func (a *api) emit(r *http.Request, t note.EventType, n note.Note) {
+	user := owner(r)
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if strings.HasPrefix(name, "~") {
+		_, name, _ = strings.Cut(name[1:], "/")
+	} else if t == note.EventCreated {
+		name = n.Name
+	}
+	ti := time.Now()
+	e := note.Event{Type: t, Id: n.Id, Name: name, Date: &ti}
+	a.events.publish(user, e)
+	a.hooks.push(published{user, e})
+}
+
+// eventsHandler streams the changes of the store of the caller as
+// Server-Sent Events until the client goes away.
+func (a *api) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+		return
+	}
+	ch := a.events.subscribe(session(r).User, false)
+	defer a.events.unsubscribe(ch)
+
+	cors(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	tick := time.NewTicker(keepAlive)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case p := <-ch:
+			data, _ := json.Marshal(p.event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", p.event.Type, data)
+		}
+		f.Flush()
+	}
+}
@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/internal/https"
+	"github.com/serboupal/note/note"
+)
+
+func TestBroker(t *testing.T) {
+	b := newBroker()
+	amy, bob, all := b.subscribe("amy", false), b.subscribe("bob", false), b.subscribe("bob", true)
+	b.publish("amy", note.Event{Type: note.EventCreated, Name: "todo"})
+	for name, ch := range map[string]chan published{"amy": amy, "all": all} {
+		select {
+		case p := <-ch:
+			if p.user != "amy" || p.event.Name != "todo" {
+				t.Errorf("%s got %+v", name, p)
+			}
+		default:
+			t.Errorf("%s got no event", name)
+		}
+	}
+	select {
+	case p := <-bob:
+		t.Errorf("bob got event of amy %+v", p)
+	default:
+	}
+
+	// slow subscribers miss events, publish never blocks
+	for i := 0; i < cap(amy)+10; i++ {
+		b.publish("amy", note.Event{Type: note.EventUpdated, Name: "todo"})
+	}
+	if len(amy) != cap(amy) {
+		t.Errorf("%d queued events, want %d", len(amy), cap(amy))
+	}
+
+	b.unsubscribe(amy)
+	b.publish("amy", note.Event{Type: note.EventDeleted, Name: "todo"})
+	if len(amy) != cap(amy) {
+		t.Errorf("unsubscribed channel got events")
+	}
+}
+
+// watch subscribes to the events of the owner of secret and returns them as
+// they arrive.
+func watch(t *testing.T, a *api, url, secret string) <-chan note.Event {
+	t.Helper()
+	h := https.NewBackend(url, secret)
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	before := subscribers(a)
+	ch := make(chan note.Event, 16)
+	go h.Watch(ctx, func(e note.Event) { ch <- e })
+	deadline := time.Now().Add(5 * time.Second)
+	for subscribers(a) == before {
+		if time.Now().After(deadline) {
+			t.Fatal("watch not subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ch
+}
+
+func subscribers(a *api) int {
+	a.events.mu.Lock()
+	defer a.events.mu.Unlock()
+	return len(a.events.subs)
+}
+
+func TestEvents(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	amy, bob := addUser(t, a, "amy"), addUser(t, a, "bob")
+	events := watch(t, a, srv.URL, amy)
+
+	// changes of other stores are not seen
+	if resp := request(t, srv, bob, "POST", "/", note.Note{Name: "other", Data: []byte("b")}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("create as bob: %s", resp.Status)
+	}
+	for _, step := range []struct {
+		method, path string
+		body         any
+	}{
+		{"POST", "/", note.Note{Name: "todo", Data: []byte("a")}},
+		{"PUT", "/todo", note.Note{Data: []byte("b")}},
+		{"DELETE", "/todo", nil},
+	} {
+		if resp := request(t, srv, amy, step.method, step.path, step.body, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %s", step.method, step.path, resp.Status)
+		}
+	}
+
+	a1, _ := note.NewNote("todo", "", []byte("a"))
+	b1, _ := note.NewNote("todo", "", []byte("b"))
+	for _, want := range []note.Event{
+		{Type: note.EventCreated, Name: "todo", Id: a1.Id},
+		{Type: note.EventUpdated, Name: "todo", Id: b1.Id},
+		{Type: note.EventDeleted, Name: "todo", Id: b1.Id},
+	} {
+		select {
+		case e := <-events:
+			if e.Type != want.Type || e.Name != want.Name || e.Id != want.Id || e.Date == nil {
+				t.Errorf("event %+v, want %+v", e, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want.Type)
+		}
+	}
+}
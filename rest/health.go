@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"os"
+)
+
+var ErrNotReady = errors.New("backend not ready")
+
+// readier is implemented by backends that can check they are usable.
+type readier interface {
+	Ready() error
+}
+
+// healthHandler answers as long as the process serves requests.
+func (a *api) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// readyHandler checks that the shared store and the users database can be
+// read and written.
+func (a *api) readyHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.ready()
+	if err != nil {
+		a.error(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+func (a *api) ready() error {
+	b, ok := a.backend.(readier)
+	if !ok {
+		return ErrNotReady
+	}
+	err := b.Ready()
+	if err != nil {
+		return errors.Join(ErrNotReady, err)
+	}
+
+	_, err = a.users.list()
+	if err != nil {
+		return errors.Join(ErrNotReady, err)
+	}
+	f, err := os.CreateTemp(a.users.dir, ".ready")
+	if err != nil {
+		return errors.Join(ErrNotReady, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
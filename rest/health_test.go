@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/note"
+)
+
+// notReady hides the Ready method of a backend.
+type notReady struct {
+	note.Backend
+}
+
+func TestHealth(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(t *testing.T, a *api)
+		ready int
+	}{
+		{"ready", func(t *testing.T, a *api) {}, http.StatusOK},
+		{"backend without check", func(t *testing.T, a *api) {
+			a.backend = notReady{a.backend}
+		}, http.StatusServiceUnavailable},
+		{"store removed", func(t *testing.T, a *api) {
+			store := t.TempDir()
+			a.backend = local.NewBackendAt(store)
+			if err := a.backend.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.RemoveAll(store); err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusServiceUnavailable},
+		{"users removed", func(t *testing.T, a *api) {
+			if err := os.RemoveAll(a.users.dir); err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAPI(t)
+			srv := newTestServer(t, a, "shared")
+			tt.setup(t, a)
+			// no token needed, load balancers probe them
+			if resp := request(t, srv, "", "GET", "/_/healthz", nil, nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("healthz: %s", resp.Status)
+			}
+			if resp := request(t, srv, "", "GET", "/_/readyz", nil, nil); resp.StatusCode != tt.ready {
+				t.Errorf("readyz: %s, want %d", resp.Status, tt.ready)
+			}
+		})
+	}
+}
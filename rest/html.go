@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/serboupal/note/markdown"
+	"github.com/serboupal/note/note"
+)
+
+// htmlHandler renders the note name as a web page for GET /{name}.html.
+func (a *api) htmlHandler(w http.ResponseWriter, r *http.Request, name string) {
+	b, name, err := a.targetName(r, name, note.ScopeRead)
+	if err != nil {
+		a.targetError(w, r, err)
+		return
+	}
+	n, err := b.Get(name)
+	if err != nil {
+		a.targetError(w, r, err)
+		return
+	}
+	a.html(w, n)
+}
+
+// isPage reports if GET path asks for the page of a note, {name}.html, and
+// not for a note actually named like that.
+func (a *api) isPage(r *http.Request, path string) (string, bool) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(path, "/"), ".html")
+	if !ok || name == "" {
+		return "", false
+	}
+	b, full, err := a.targetName(r, name+".html", note.ScopeRead)
+	if err == nil {
+		if _, err := b.Get(full); err == nil {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// html writes n rendered from Markdown as a standalone page.
+func (a *api) html(w http.ResponseWriter, n note.Note) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src https:")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Write(markdown.Document(n.Name, n.Data))
+}
@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/serboupal/note/note"
+)
+
+func TestHTMLPage(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	amy, bob := addUser(t, a, "amy"), addUser(t, a, "bob")
+	for _, n := range []note.Note{
+		{Name: "todo", Data: []byte("# Todo\n\n<script>alert(1)</script>")},
+		{Name: "page.html", Data: []byte("*stored as html*")},
+	} {
+		request(t, srv, amy, "POST", "/", n, nil)
+	}
+	request(t, srv, amy, "POST", "/_/shares", note.Grant{Name: "todo", User: "bob", Scope: "read"}, nil)
+
+	tests := []struct {
+		secret, path string
+		want         int
+		typ, body    string
+	}{
+		{amy, "/todo.html", http.StatusOK, "text/html", "<h1>Todo</h1>"},
+		{amy, "/page.html", http.StatusOK, "application/json", `"name":"page.html"`},
+		{amy, "/page.html.html", http.StatusOK, "text/html", "<em>stored as html</em>"},
+		{amy, "/missing.html", http.StatusNotFound, "application/json", "not_found"},
+		{bob, "/~amy/todo.html", http.StatusOK, "text/html", "<h1>Todo</h1>"},
+		{bob, "/~amy/page.html.html", http.StatusNotFound, "application/json", "not_found"},
+		{"", "/todo.html", http.StatusUnauthorized, "application/json", "unauthorized"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.secret != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.secret)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want || !strings.HasPrefix(resp.Header.Get("Content-Type"), tt.typ) ||
+			!strings.Contains(string(body), tt.body) {
+			t.Errorf("GET %s: %s %s %q", tt.path, resp.Status, resp.Header.Get("Content-Type"), body)
+		}
+		if tt.typ == "text/html" {
+			if strings.Contains(string(body), "<script>") {
+				t.Errorf("GET %s: script not escaped", tt.path)
+			}
+			if resp.Header.Get("Content-Security-Policy") == "" {
+				t.Errorf("GET %s: no Content-Security-Policy", tt.path)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package rest
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited = errors.New("too many requests")
+	ErrLockedOut   = errors.New("too many failed authentications")
+)
+
+// sweepEvery is how often idle entries are dropped from the limiter maps.
+const sweepEvery = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket per key, refilled at rate tokens per second up
+// to burst. A zero rate disables it.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// allow takes a token for key, or returns how long to wait for one.
+func (l *limiter) allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepEvery {
+		return
+	}
+	l.swept = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+type failures struct {
+	count int
+	first time.Time
+	until time.Time
+}
+
+// lockout blocks a key for lock once it fails max times within lock. A zero
+// max disables it.
+type lockout struct {
+	mu    sync.Mutex
+	max   int
+	lock  time.Duration
+	fails map[string]*failures
+	swept time.Time
+}
+
+func newLockout(max int, lock time.Duration) *lockout {
+	return &lockout{max: max, lock: lock, fails: map[string]*failures{}}
+}
+
+// locked returns how long key stays locked out.
+func (l *lockout) locked(key string) time.Duration {
+	if l.max <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.fails[key]
+	if !ok {
+		return 0
+	}
+	return time.Until(f.until)
+}
+
+func (l *lockout) fail(key string) {
+	if l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	f, ok := l.fails[key]
+	if !ok || now.Sub(f.first) > l.lock {
+		f = &failures{first: now}
+		l.fails[key] = f
+	}
+	f.count++
+	if f.count >= l.max {
+		f.until = now.Add(l.lock)
+	}
+}
+
+func (l *lockout) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.fails, key)
+}
+
+func (l *lockout) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepEvery {
+		return
+	}
+	l.swept = now
+	for k, f := range l.fails {
+		if now.Sub(f.first) > l.lock && now.After(f.until) {
+			delete(l.fails, k)
+		}
+	}
+}
+
+// clientIP returns the address of the client. Behind a trusted proxy it is
+// the last address of X-Forwarded-For not added by one of them, otherwise
+// the peer, so every client of a proxy doesn't share its limits.
+func (a *api) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !a.trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !a.trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// trusted reports if ip is one of the proxies set in Config.TrustedProxies.
+func (a *api) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range a.proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// throttle checks the lockout and rate limit of the client ip and answers
+// 429 when exceeded.
+func (a *api) throttle(w http.ResponseWriter, r *http.Request) bool {
+	ip := a.clientIP(r)
+	if d := a.lockout.locked(ip); d > 0 {
+		a.tooMany(w, r, d, ErrLockedOut)
+		return false
+	}
+	if ok, d := a.ipLimit.allow(ip); !ok {
+		a.tooMany(w, r, d, ErrRateLimited)
+		return false
+	}
+	return true
+}
+
+func (a *api) tooMany(w http.ResponseWriter, r *http.Request, wait time.Duration, err error) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	a.error(w, r, http.StatusTooManyRequests, err)
+}
+
+// limit applies throttle to handlers that are not behind auth.
+func (a *api) limit(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.throttle(w, r) {
+			return
+		}
+		f(w, r)
+	}
+}
@@ -0,0 +1,155 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok || wait <= 0 || wait > time.Second/2 {
+		t.Fatalf("request over the burst: %v, wait %v", ok, wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Errorf("other key limited")
+	}
+
+	// a second later two tokens are back
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d after refill refused", i)
+		}
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Errorf("refill above the rate")
+	}
+
+	// never more than the burst
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Hour)
+	for i := 0; i < 4; i++ {
+		ok, _ := l.allow("a")
+		if ok != (i < 3) {
+			t.Errorf("request %d after long idle: %v", i, ok)
+		}
+	}
+
+	if ok, _ := newLimiter(0, 0).allow("a"); !ok {
+		t.Errorf("disabled limiter refused")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := newLockout(3, time.Minute)
+	for i := 0; i < 2; i++ {
+		l.fail("a")
+	}
+	if d := l.locked("a"); d > 0 {
+		t.Fatalf("locked before max failures")
+	}
+	l.fail("a")
+	if d := l.locked("a"); d <= 0 || d > time.Minute {
+		t.Fatalf("locked for %v, want up to a minute", d)
+	}
+	if d := l.locked("b"); d > 0 {
+		t.Errorf("other key locked")
+	}
+
+	// the lock expires
+	l.fails["a"].until = time.Now().Add(-time.Second)
+	if d := l.locked("a"); d > 0 {
+		t.Errorf("locked after expiry for %v", d)
+	}
+
+	// failures older than the lock duration are forgotten
+	l = newLockout(2, time.Minute)
+	l.fail("a")
+	l.fails["a"].first = time.Now().Add(-2 * time.Minute)
+	l.fail("a")
+	if d := l.locked("a"); d > 0 {
+		t.Errorf("locked by old failures")
+	}
+	l.fail("a")
+	l.reset("a")
+	if d := l.locked("a"); d > 0 {
+		t.Errorf("locked after reset")
+	}
+}
+
+func TestTokenLimit(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	a.tokenLimit = newLimiter(0.001, 2)
+	amy, bob := addUser(t, a, "amy"), addUser(t, a, "bob")
+	for i := 0; i < 2; i++ {
+		if resp := request(t, srv, amy, "GET", "/_/tokens", nil, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: %s", i, resp.Status)
+		}
+	}
+	resp := request(t, srv, amy, "GET", "/_/tokens", nil, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("request over the limit: %s, Retry-After %q", resp.Status, resp.Header.Get("Retry-After"))
+	}
+	// same address, other token
+	if resp := request(t, srv, bob, "GET", "/_/tokens", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("other token: %s", resp.Status)
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	a.lockout = newLockout(2, time.Minute)
+	for i := 0; i < 2; i++ {
+		if resp := request(t, srv, "wrong", "GET", "/todo", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("bad token: %s", resp.Status)
+		}
+	}
+	resp := request(t, srv, "shared", "POST", "/", note.Note{Name: "todo", Data: []byte("a")}, nil)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("locked out client: %s", resp.Status)
+	}
+
+	for key := range a.lockout.fails {
+		a.lockout.fails[key].until = time.Now()
+	}
+	resp = request(t, srv, "shared", "POST", "/", note.Note{Name: "todo", Data: []byte("a")}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("after lockout: %s", resp.Status)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	a := &api{proxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	for _, c := range []struct {
+		remote, forwarded, want string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		// only trusted proxies can set the client address
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := a.clientIP(r); got != c.want {
+			t.Errorf("client of %s via %q: %s, want %s", c.remote, c.forwarded, got, c.want)
+		}
+	}
+}
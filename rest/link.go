@@ -0,0 +1,274 @@
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+var ErrNoLink = errors.New("link not found")
+
+// link is the server side record of a public link, like tokens only the
+// SHA-256 of the secret is stored. The link only serves the note with
+// content noteId, it follows the updates made through the server and goes
+// away with the note, so a new note with the same name is never exposed.
+type link struct {
+	note.Link
+	hash   string
+	user   string
+	noteId string
+}
+
+func (l *link) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s", l.Id, l.hash, l.user, l.Name,
+		l.Date.Format(time.DateTime), l.Expires.Format(time.DateTime), l.noteId)
+}
+
+func (l *link) Parse(s string) error {
+	item := strings.Split(s, ",")
+	if len(item) != 6 && len(item) != 7 {
+		return fmt.Errorf("invalid link string")
+	}
+	if len(item) == 7 {
+		l.noteId = item[6]
+	}
+
+	ti, err := time.Parse(time.DateTime, item[4])
+	if err != nil {
+		return err
+	}
+	exp, err := time.Parse(time.DateTime, item[5])
+	if err != nil {
+		return err
+	}
+	l.Id = item[0]
+	l.hash = item[1]
+	l.user = item[2]
+	l.Name = item[3]
+	l.Date = &ti
+	l.Expires = &exp
+	return nil
+}
+
+func (u *users) links() ([]link, error) {
+	r, err := dbline.Open[*link](filepath.Join(u.dir, "links.db"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (u *users) userLinks(user string) ([]note.Link, error) {
+	links, err := u.links()
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Link{}
+	for _, v := range links {
+		if v.user == user {
+			r = append(r, v.Link)
+		}
+	}
+	return r, nil
+}
+
+// saveLinks replaces the links of the server, dropping the expired ones.
+func (u *users) saveLinks(links []link) error {
+	now := time.Now()
+	links = slices.DeleteFunc(links, func(l link) bool { return now.After(*l.Expires) })
+	return dbline.Save(filepath.Join(u.dir, "links.db"), ptrs(links))
+}
+
+func (u *users) newLink(user, name, noteId string, ttl time.Duration) (note.Link, error) {
+	if ttl <= 0 {
+		return note.Link{}, ErrTokenExpired
+	}
+	buf := make([]byte, 40)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return note.Link{}, err
+	}
+	ti := time.Now().UTC().Truncate(time.Second)
+	exp := ti.Add(ttl)
+	l := link{
+		Link: note.Link{
+			Id:      fmt.Sprintf("%x", buf[:8]),
+			Name:    name,
+			Date:    &ti,
+			Expires: &exp,
+		},
+		user:   user,
+		noteId: noteId,
+	}
+	secret := fmt.Sprintf("%x", buf[8:])
+	l.hash = hashToken(secret)
+
+	links, err := u.links()
+	if err != nil {
+		return note.Link{}, err
+	}
+	err = u.saveLinks(append(links, l))
+	if err != nil {
+		return note.Link{}, err
+	}
+	l.Secret = secret
+	return l.Link, nil
+}
+
+func (u *users) revokeLink(user, id string) error {
+	links, err := u.links()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(links, func(l link) bool { return l.Id == id && l.user == user })
+	if i < 0 {
+		return ErrNoLink
+	}
+	return u.saveLinks(slices.Delete(links, i, i+1))
+}
+
+// moveLinks points the links of the note name of user with content from to
+// its new content to.
+func (u *users) moveLinks(user, name, from, to string) error {
+	links, err := u.links()
+	if err != nil {
+		return err
+	}
+	moved := false
+	for i, l := range links {
+		if l.user == user && l.Name == name && l.noteId == from {
+			links[i].noteId = to
+			moved = true
+		}
+	}
+	if !moved {
+		return nil
+	}
+	return u.saveLinks(links)
+}
+
+// dropLinks revokes the links of the note name of user.
+func (u *users) dropLinks(user, name string) error {
+	links, err := u.links()
+	if err != nil {
+		return err
+	}
+	n := len(links)
+	links = slices.DeleteFunc(links, func(l link) bool { return l.user == user && l.Name == name })
+	if len(links) == n {
+		return nil
+	}
+	return u.saveLinks(links)
+}
+
+// lookupLink returns the unexpired link matching secret.
+func (u *users) lookupLink(secret string) (link, error) {
+	links, err := u.links()
+	if err != nil {
+		return link{}, err
+	}
+	h := []byte(hashToken(secret))
+	for _, l := range links {
+		if subtle.ConstantTimeCompare([]byte(l.hash), h) == 1 {
+			if time.Now().After(*l.Expires) {
+				return link{}, ErrNoLink
+			}
+			return l, nil
+		}
+	}
+	return link{}, ErrNoLink
+}
+
+func (a *api) linkRouter(w http.ResponseWriter, r *http.Request) {
+	user := session(r).User
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, apiPrefix+"links"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		list, err := a.users.userLinks(user)
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, list)
+	case r.Method == http.MethodPost && id == "":
+		req := note.Link{}
+		if code, err := a.decode(w, r, &req, maxBodySize); err != nil {
+			a.error(w, r, code, err)
+			return
+		}
+		b, err := a.storeOf(user)
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		n, err := b.Get(req.Name)
+		if err != nil {
+			a.targetError(w, r, err)
+			return
+		}
+		if req.Expires == nil {
+			a.error(w, r, http.StatusBadRequest, ErrTokenExpired)
+			return
+		}
+		l, err := a.users.newLink(user, req.Name, n.Id, time.Until(*req.Expires))
+		if err != nil {
+			a.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		a.response(w, r, l)
+	case r.Method == http.MethodDelete && id != "":
+		err := a.users.revokeLink(user, id)
+		if err != nil {
+			if errors.Is(err, ErrNoLink) {
+				a.error(w, r, http.StatusNotFound, err)
+				return
+			}
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, nil)
+	default:
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+	}
+}
+
+// publicHandler serves notes published with a link, it is not behind auth.
+func (a *api) publicHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+		return
+	}
+	secret := strings.TrimPrefix(r.URL.Path, apiPrefix+"public/")
+	l, err := a.users.lookupLink(secret)
+	if err != nil {
+		a.error(w, r, http.StatusNotFound, ErrNoLink)
+		return
+	}
+	b, err := a.storeOf(l.user)
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	n, err := b.Get(l.Name)
+	if err != nil {
+		a.error(w, r, http.StatusNotFound, err)
+		return
+	}
+	if n.Id != l.noteId {
+		// changed outside the server, it may not be the published note
+		a.error(w, r, http.StatusNotFound, ErrNoLink)
+		return
+	}
+	a.html(w, n)
+}
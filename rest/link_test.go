@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+// public returns the status and body of the page of the link with secret.
+func public(t *testing.T, srv *httptest.Server, secret string) (int, string) {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + "/_/public/" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestLinks(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	amy := addUser(t, a, "amy")
+	bob := addUser(t, a, "bob")
+	request(t, srv, amy, "POST", "/", note.Note{Name: "todo", Data: []byte("buy milk")}, nil)
+
+	exp := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	for _, tt := range []struct {
+		l    note.Link
+		want int
+	}{
+		{note.Link{Name: "todo"}, http.StatusBadRequest},
+		{note.Link{Name: "todo", Expires: &past}, http.StatusBadRequest},
+		{note.Link{Name: "missing", Expires: &exp}, http.StatusNotFound},
+	} {
+		if resp := request(t, srv, amy, "POST", "/_/links", tt.l, nil); resp.StatusCode != tt.want {
+			t.Errorf("link %+v: %s, want %d", tt.l, resp.Status, tt.want)
+		}
+	}
+
+	l := note.Link{}
+	if resp := request(t, srv, amy, "POST", "/_/links", note.Link{Name: "todo", Expires: &exp}, &l); resp.StatusCode != http.StatusOK || l.Secret == "" {
+		t.Fatalf("link: %s %+v", resp.Status, l)
+	}
+	if code, body := public(t, srv, l.Secret); code != http.StatusOK || !strings.Contains(body, "buy milk") {
+		t.Errorf("public page: %d %q", code, body)
+	}
+	if code, _ := public(t, srv, "wrong"); code != http.StatusNotFound {
+		t.Errorf("wrong secret: %d", code)
+	}
+
+	// updates made through the server are followed
+	request(t, srv, amy, "PUT", "/todo", note.Note{Data: []byte("buy bread")}, nil)
+	if code, body := public(t, srv, l.Secret); code != http.StatusOK || !strings.Contains(body, "buy bread") {
+		t.Errorf("public page after update: %d %q", code, body)
+	}
+	// a change made outside the server may not be the published note
+	b, err := a.storeOf("amy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Update("todo", []byte("private")); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := public(t, srv, l.Secret); code != http.StatusNotFound {
+		t.Errorf("note changed outside the server: %d", code)
+	}
+
+	if resp := request(t, srv, bob, "DELETE", "/_/links/"+l.Id, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoke link of another user: %s", resp.Status)
+	}
+	if resp := request(t, srv, amy, "DELETE", "/_/links/"+l.Id, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("revoke: %s", resp.Status)
+	}
+	if code, _ := public(t, srv, l.Secret); code != http.StatusNotFound {
+		t.Errorf("revoked link: %d", code)
+	}
+}
+
+func TestLinkDroppedWithNote(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	amy := addUser(t, a, "amy")
+	request(t, srv, amy, "POST", "/", note.Note{Name: "todo", Data: []byte("old")}, nil)
+	exp := time.Now().Add(time.Hour)
+	l := note.Link{}
+	request(t, srv, amy, "POST", "/_/links", note.Link{Name: "todo", Expires: &exp}, &l)
+
+	request(t, srv, amy, "DELETE", "/todo", nil, nil)
+	request(t, srv, amy, "POST", "/", note.Note{Name: "todo", Data: []byte("new")}, nil)
+	if code, body := public(t, srv, l.Secret); code != http.StatusNotFound {
+		t.Errorf("link of a deleted note serves its successor: %d %q", code, body)
+	}
+}
+
+func TestUpdateMissing(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	resp := request(t, srv, "shared", "PUT", "/missing", note.Note{Data: []byte("x")}, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("update missing note: %s", resp.Status)
+	}
+}
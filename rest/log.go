@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+const accessKey ctxKey = 2
+
+// access is filled while a request is served and logged when it ends.
+type access struct {
+	user   string
+	status int
+	bytes  int
+}
+
+type recorder struct {
+	http.ResponseWriter
+	a *access
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.a.status == 0 {
+		r.a.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.a.status == 0 {
+		r.a.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.a.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers work through the recorder.
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func newLogger(cfg Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// withAccessLog logs every request and records it in the metrics.
+func (a *api) withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		acc := &access{}
+		next.ServeHTTP(&recorder{w, acc}, r.WithContext(context.WithValue(r.Context(), accessKey, acc)))
+		if acc.status == 0 {
+			acc.status = http.StatusOK
+		}
+		latency := time.Since(start)
+
+		a.metrics.observe(r.Method, acc.status, latency)
+		a.log.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", acc.status),
+			slog.Int("bytes", acc.bytes),
+			slog.Duration("latency", latency),
+			slog.String("remote", r.RemoteAddr),
+			slog.String("user", acc.user),
+			slog.String("request_id", requestID(r)),
+		)
+	})
+}
+
+// setAccessUser records the authenticated user of r for the access log.
+func setAccessUser(r *http.Request, user string) {
+	if acc, ok := r.Context().Value(accessKey).(*access); ok {
+		acc.user = user
+	}
+}
@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/serboupal/note/note"
+)
+
+// syncBuffer is a bytes.Buffer written by the server and read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the JSON log records written so far.
+func (b *syncBuffer) lines(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var r []map[string]any
+	s := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for s.Scan() {
+		m := map[string]any{}
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		r = append(r, m)
+	}
+	return r
+}
+
+func TestAccessLog(t *testing.T) {
+	a := newTestAPI(t)
+	out := &syncBuffer{}
+	a.log = slog.New(slog.NewJSONHandler(out, nil))
+	srv := newTestServer(t, a, "shared")
+	secret := addUser(t, a, "amy")
+
+	resp := request(t, srv, secret, "POST", "/", note.Note{Name: "todo", Data: []byte("a")}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create: %s", resp.Status)
+	}
+	ids := []string{resp.Header.Get("X-Request-Id")}
+	resp = request(t, srv, secret, "GET", "/missing", nil, nil)
+	ids = append(ids, resp.Header.Get("X-Request-Id"))
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Fatalf("request ids %q", ids)
+	}
+
+	want := []struct {
+		method, path, user string
+		status             float64
+	}{
+		{"POST", "/", "amy", 200},
+		{"GET", "/missing", "amy", 404},
+	}
+	var got []map[string]any
+	for _, l := range out.lines(t) {
+		if l["msg"] == "request" {
+			got = append(got, l)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d access log lines, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		l := got[i]
+		if l["method"] != w.method || l["path"] != w.path || l["user"] != w.user || l["status"] != w.status {
+			t.Errorf("log %d: %v, want %+v", i, l, w)
+		}
+		if l["request_id"] != ids[i] {
+			t.Errorf("log %d request id %v, want %s", i, l["request_id"], ids[i])
+		}
+		if _, ok := l["latency"]; !ok {
+			t.Errorf("log %d without latency", i)
+		}
+	}
+	if got[1]["bytes"].(float64) == 0 {
+		t.Errorf("error body not counted: %v", got[1])
+	}
+}
@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+// buckets are the upper bounds in seconds of the latency histogram.
+var buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+type requestKey struct {
+	method string
+	code   int
+}
+
+// totalsTTL is how long the totals of the stores are reused.
+const totalsTTL = time.Minute
+
+// metrics keeps request counters and latencies, exposed in Prometheus text
+// format on /_/metrics.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	counts   []uint64
+	sum      float64
+	count    uint64
+
+	totalsMu sync.Mutex
+	totals   totals
+}
+
+// totals is the size of every store of the server.
+type totals struct {
+	users int
+	notes int
+	size  int64
+	date  *time.Time
+}
+
+// stats is implemented by backends that can report their size.
+type stats interface {
+	Stats() (notes int, bytes int64, err error)
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: map[requestKey]uint64{},
+		counts:   make([]uint64, len(buckets)),
+	}
+}
+
+func (m *metrics) observe(method string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{method, code}]++
+	s := d.Seconds()
+	for i, b := range buckets {
+		if s <= b {
+			m.counts[i]++
+		}
+	}
+	m.sum += s
+	m.count++
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	fmt.Fprintln(w, "# HELP note_http_requests_total HTTP requests served.")
+	fmt.Fprintln(w, "# TYPE note_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "note_http_requests_total{method=%q,code=\"%d\"} %d\n", k.method, k.code, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP note_http_request_duration_seconds HTTP request latencies.")
+	fmt.Fprintln(w, "# TYPE note_http_request_duration_seconds histogram")
+	for i, b := range buckets {
+		le := strconv.FormatFloat(b, 'g', -1, 64)
+		fmt.Fprintf(w, "note_http_request_duration_seconds_bucket{le=%q} %d\n", le, m.counts[i])
+	}
+	fmt.Fprintf(w, "note_http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "note_http_request_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(w, "note_http_request_duration_seconds_count %d\n", m.count)
+}
+
+// metricsHandler serves the metrics to requests with NOTE_METRICS_TOKEN, they
+// cover every user of the server. It is disabled when the token is not set.
+func (a *api) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.metricsToken == "" || r.Method != http.MethodGet {
+		a.error(w, r, http.StatusNotFound, nil)
+		return
+	}
+	secret := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(a.metricsToken), []byte(secret)) != 1 {
+		a.log.Warn("auth failed", "remote", r.RemoteAddr, "request_id", requestID(r))
+		a.lockout.fail(a.clientIP(r))
+		a.error(w, r, http.StatusUnauthorized, nil)
+		return
+	}
+	a.lockout.reset(a.clientIP(r))
+
+	t, err := a.totals()
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	a.metrics.write(w)
+	fmt.Fprintln(w, "# HELP note_users Accounts on the server.")
+	fmt.Fprintln(w, "# TYPE note_users gauge")
+	fmt.Fprintf(w, "note_users %d\n", t.users)
+	fmt.Fprintln(w, "# HELP note_notes Notes stored.")
+	fmt.Fprintln(w, "# TYPE note_notes gauge")
+	fmt.Fprintf(w, "note_notes %d\n", t.notes)
+	fmt.Fprintln(w, "# HELP note_store_bytes Size of the stored notes and indexes.")
+	fmt.Fprintln(w, "# TYPE note_store_bytes gauge")
+	fmt.Fprintf(w, "note_store_bytes %d\n", t.size)
+}
+
+// totals returns the size of the stores, computed again at most every
+// totalsTTL since it walks the store of every user.
+func (a *api) totals() (totals, error) {
+	m := a.metrics
+	m.totalsMu.Lock()
+	defer m.totalsMu.Unlock()
+	if m.totals.date != nil && time.Since(*m.totals.date) < totalsTTL {
+		return m.totals, nil
+	}
+
+	all, err := a.users.list()
+	if err != nil {
+		return totals{}, err
+	}
+	t := totals{users: len(all)}
+	stores := []note.Backend{a.backend}
+	for _, u := range all {
+		b, err := a.storeOf(u.Name)
+		if err != nil {
+			return totals{}, err
+		}
+		stores = append(stores, b)
+	}
+	for _, b := range stores {
+		s, ok := b.(stats)
+		if !ok {
+			continue
+		}
+		n, sz, err := s.Stats()
+		if err != nil && !errors.Is(err, note.ErrNotFound) {
+			return totals{}, err
+		}
+		t.notes += n
+		t.size += sz
+	}
+	now := time.Now()
+	t.date = &now
+	m.totals = t
+	return t, nil
+}
@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+func TestObserve(t *testing.T) {
+	m := newMetrics()
+	m.observe("GET", 200, 20*time.Millisecond)
+	m.observe("GET", 200, 3*time.Second)
+	m.observe("PUT", 404, time.Millisecond)
+	var b strings.Builder
+	m.write(&b)
+	for _, want := range []string{
+		`note_http_requests_total{method="GET",code="200"} 2`,
+		`note_http_requests_total{method="PUT",code="404"} 1`,
+		`note_http_request_duration_seconds_bucket{le="0.005"} 1`,
+		`note_http_request_duration_seconds_bucket{le="0.01"} 1`,
+		`note_http_request_duration_seconds_bucket{le="0.025"} 2`,
+		`note_http_request_duration_seconds_bucket{le="2.5"} 2`,
+		`note_http_request_duration_seconds_bucket{le="5"} 3`,
+		`note_http_request_duration_seconds_bucket{le="+Inf"} 3`,
+		`note_http_request_duration_seconds_count 3`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("metrics without %s:\n%s", want, b.String())
+		}
+	}
+}
+
+// scrape returns the status and body of /_/metrics with secret.
+func scrape(t *testing.T, url, secret string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url+"/_/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	if code, _ := scrape(t, srv.URL, ""); code != http.StatusNotFound {
+		t.Errorf("metrics without NOTE_METRICS_TOKEN: %d, want 404", code)
+	}
+
+	a.metricsToken = "scraper"
+	secret := addUser(t, a, "amy")
+	for _, s := range []string{"shared", secret} {
+		resp := request(t, srv, s, "POST", "/", note.Note{Name: "todo", Data: []byte("a")}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("create: %s", resp.Status)
+		}
+	}
+	for _, s := range []string{"", "shared", secret} {
+		if code, _ := scrape(t, srv.URL, s); code != http.StatusUnauthorized {
+			t.Errorf("metrics with token %q: %d, want 401", s, code)
+		}
+	}
+
+	code, body := scrape(t, srv.URL, "scraper")
+	if code != http.StatusOK {
+		t.Fatalf("metrics: %d", code)
+	}
+	for _, want := range []string{
+		`note_http_requests_total{method="POST",code="200"} 2`,
+		`note_http_requests_total{method="GET",code="401"} 3`,
+		"note_users 1",
+		"note_notes 2",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics without %s:\n%s", want, body)
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package rest
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/serboupal/note/internal/local"
 	"github.com/serboupal/note/note"
@@ -14,38 +21,161 @@ import (
 
 var ErrNotImplemented = errors.New("not implemented")
 var ErrInvalidQuery = errors.New("invalid query")
+var ErrForbidden = errors.New("token scope does not allow this operation")
+var ErrTooLarge = errors.New("note too large")
+var ErrBodyTooLarge = errors.New("request body too large")
+
+type ctxKey int
+
+const tokenKey ctxKey = 0
+
+// Config holds the settings of the server.
+type Config struct {
+	LogLevel  slog.Level
+	LogFormat string
+
+	// RateLimit is the requests per second allowed to each client address
+	// and each token, up to RateBurst at once. Zero disables it.
+	RateLimit float64
+	RateBurst int
+
+	// MaxAuthFailures failed authentications within Lockout block the
+	// client address for Lockout. Zero disables it.
+	MaxAuthFailures int
+	Lockout         time.Duration
+
+	// TrustedProxies are the networks of the proxies in front of the
+	// server, whose X-Forwarded-For header gives the client address.
+	TrustedProxies []netip.Prefix
+
+	// MaxNoteSize is the largest note content accepted, in bytes.
+	MaxNoteSize int64
+
+	// Store is the URL of the store of each user, with {user} replaced by
+	// the user name, like sqlite:///srv/note/{user}.db. The store used with
+	// NOTE_HTTPS_TOKEN gets ~ as user name. Notes are kept in the server
+	// config dir by default.
+	Store string
+}
+
+// apiPrefix starts the paths of every endpoint other than notes, list and
+// search.
+const apiPrefix = "/" + note.ReservedPrefix
+
+// DefaultMaxNoteSize is used when Config.MaxNoteSize is not set.
+const DefaultMaxNoteSize = 1 << 20
 
 type api struct {
-	backend note.Backend
-	token   string
+	backend      note.Backend
+	token        string
+	metricsToken string
+	users        *users
+	log          *slog.Logger
+	metrics      *metrics
+	events       *broker
+	hooks        *hookQueue
+
+	ipLimit    *limiter
+	tokenLimit *limiter
+	lockout    *lockout
+	proxies    []netip.Prefix
+
+	maxNoteSize int64
+	storeURL    string
+
+	mu       sync.Mutex
+	backends map[string]note.Backend
 }
 
-func Serve() {
+func Serve(cfg Config) {
+	log := newLogger(cfg)
+	u, err := newUsers()
+	if err != nil {
+		log.Error("loading users", "err", err)
+		os.Exit(1)
+		return
+	}
+	all, err := u.list()
+	if err != nil {
+		log.Error("loading users", "err", err)
+		os.Exit(1)
+		return
+	}
+
 	tkn := os.Getenv("NOTE_HTTPS_TOKEN")
-	if tkn == "" {
-		fmt.Fprintf(os.Stderr, "please set NOTE_HTTPS_TOKEN\n")
+	if tkn == "" && len(all) == 0 {
+		log.Error("please set NOTE_HTTPS_TOKEN or add a user with serve user add")
 		os.Exit(1)
 		return
 	}
 	api := api{
-		token: tkn,
+		token:        tkn,
+		metricsToken: os.Getenv("NOTE_METRICS_TOKEN"),
+		users:        u,
+		log:          log,
+		metrics:      newMetrics(),
+		events:       newBroker(),
+		hooks:        newHookQueue(),
+		backends:     map[string]note.Backend{},
+
+		ipLimit:    newLimiter(cfg.RateLimit, cfg.RateBurst),
+		tokenLimit: newLimiter(cfg.RateLimit, cfg.RateBurst),
+		lockout:    newLockout(cfg.MaxAuthFailures, cfg.Lockout),
+		proxies:    cfg.TrustedProxies,
+
+		maxNoteSize: cfg.MaxNoteSize,
+		storeURL:    cfg.Store,
+	}
+	if api.maxNoteSize <= 0 {
+		api.maxNoteSize = DefaultMaxNoteSize
+	}
+	if api.storeURL != "" && !strings.Contains(api.storeURL, "{user}") {
+		log.Error("store URL must contain {user}", "store", api.storeURL)
+		os.Exit(1)
+		return
+	}
+	api.backend, err = api.open("")
+	if err != nil {
+		log.Error("opening store", "err", err)
+		os.Exit(1)
+		return
 	}
-	api.backend = local.NewBackend(".note")
 
-	err := api.backend.Init()
+	err = api.backend.Init()
 	if err != nil {
-		fmt.Fprint(os.Stderr, "Error initializing backend\n")
+		log.Error("initializing backend", "err", err)
+		os.Exit(1)
+		return
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", api.auth(api.tmpRouter))
+	go api.dispatch(context.Background())
+
+	addr := "0.0.0.0:48374"
+	log.Info("listening", "addr", addr)
+	err = http.ListenAndServe(addr, api.handler())
+	log.Error("server stopped", "err", err)
+	os.Exit(1)
+}
 
-	http.ListenAndServe("0.0.0.0:48374", mux)
+// handler returns every route of the server.
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix+"healthz", a.healthHandler)
+	mux.HandleFunc(apiPrefix+"readyz", a.readyHandler)
+	mux.HandleFunc(apiPrefix+"metrics", a.limit(a.metricsHandler))
+	mux.HandleFunc("/", a.auth(a.tmpRouter))
+	mux.HandleFunc(apiPrefix+"public/", a.limit(a.publicHandler))
+	mux.Handle(apiPrefix+"ui/", uiHandler())
+	return withRequestID(a.withAccessLog(mux))
 }
 
 // when go 1.22 releases, change this to new http.muxer
 func (a *api) tmpRouter(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
+	if r.Method != http.MethodOptions && strings.HasPrefix(path, apiPrefix) {
+		a.apiRouter(w, r)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		if path == "/" {
@@ -55,6 +185,10 @@ func (a *api) tmpRouter(w http.ResponseWriter, r *http.Request) {
 			a.searchHandler(w, r)
 			return
 		}
+		if name, ok := a.isPage(r, path); ok {
+			a.htmlHandler(w, r, name)
+			return
+		}
 		a.getHandler(w, r)
 		return
 	case http.MethodPost:
@@ -79,11 +213,34 @@ func (a *api) tmpRouter(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// apiRouter serves the endpoints under /_/, apart from the note routes. Note
+// names can't start with _/ so they never clash.
+func (a *api) apiRouter(w http.ResponseWriter, r *http.Request) {
+	path := "/" + strings.TrimPrefix(r.URL.Path, apiPrefix)
+	switch {
+	case path == "/tokens" || strings.HasPrefix(path, "/tokens/"):
+		a.tokenRouter(w, r)
+	case path == "/links" || strings.HasPrefix(path, "/links/"):
+		a.linkRouter(w, r)
+	case path == "/shares" || path == "/shared":
+		a.shareRouter(w, r)
+	case strings.HasPrefix(path, "/signatures/"):
+		a.signatureRouter(w, r)
+	case r.Method == http.MethodGet && path == "/events":
+		a.eventsHandler(w, r)
+	default:
+		a.error(w, r, http.StatusNotFound, nil)
+	}
+}
+
 func (a *api) listHandler(w http.ResponseWriter, r *http.Request) {
 	filter := r.URL.Query().Get("filter")
-	var list []note.Note
-	var err error
-	list, err = a.backend.List(filter)
+	b, err := a.store(r)
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	list, err := b.List(filter)
 	if err != nil {
 		if errors.Is(err, note.ErrNotFound) {
 			a.error(w, r, http.StatusNotFound, err)
@@ -96,13 +253,38 @@ func (a *api) listHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) createHandler(w http.ResponseWriter, r *http.Request) {
-	n := note.Note{}
-	err := json.NewDecoder(r.Body).Decode(&n)
+	in := note.Note{}
+	if code, err := a.decodeNote(w, r, &in); err != nil {
+		a.error(w, r, code, err)
+		return
+	}
+	if in.Name == "" {
+		a.error(w, r, http.StatusBadRequest, note.ErrInvalidName)
+		return
+	}
+	// id, size and date are computed here, a client supplied id must match
+	n, err := note.NewNote(in.Name, "", in.Data)
+	if err != nil {
+		a.error(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if in.Id != "" && in.Id != n.Id {
+		a.error(w, r, http.StatusBadRequest, note.ErrIntegrityFail)
+		return
+	}
+	n.Tags, n.Groups, n.Encrypted = in.Tags, in.Groups, in.Encrypted
+	err = n.Check()
 	if err != nil {
 		a.error(w, r, http.StatusBadRequest, err)
 		return
 	}
-	err = a.backend.Create(&n)
+
+	b, err := a.store(r)
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	err = b.Create(n)
 	if err != nil {
 		if errors.Is(err, note.ErrNoteExist) {
 			a.error(w, r, http.StatusConflict, err)
@@ -111,12 +293,53 @@ func (a *api) createHandler(w http.ResponseWriter, r *http.Request) {
 		a.error(w, r, http.StatusBadRequest, err)
 		return
 	}
+	a.emit(r, note.EventCreated, *n)
 	a.response(w, r, nil)
 }
 
+// maxBodySize is the largest body accepted by endpoints other than notes.
+const maxBodySize = 64 << 10
+
+// decode reads the JSON body of r into v, refusing bodies over limit bytes.
+// It returns the status to answer on error.
+func (a *api) decode(w http.ResponseWriter, r *http.Request, v any, limit int64) (int, error) {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+		}
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
+// decodeNote reads the note in the body of r, refusing bodies that can't
+// hold a note of the max size.
+func (a *api) decodeNote(w http.ResponseWriter, r *http.Request, n *note.Note) (int, error) {
+	// data is base64 encoded, leave room for the other fields
+	limit := a.maxNoteSize/3*4 + 4 + maxBodySize
+	if code, err := a.decode(w, r, n, limit); err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			err = ErrTooLarge
+		}
+		return code, err
+	}
+	if int64(len(n.Data)) > a.maxNoteSize {
+		return http.StatusRequestEntityTooLarge, ErrTooLarge
+	}
+	return 0, nil
+}
+
 func (a *api) getHandler(w http.ResponseWriter, r *http.Request) {
-	name := strings.TrimPrefix(r.URL.Path, "/")
-	n, err := a.backend.Get(name)
+	b, name, err := a.target(r, note.ScopeRead)
+	if err != nil {
+		a.targetError(w, r, err)
+		return
+	}
+	n, err := b.Get(name)
+	// keep the ~owner/ prefix of shared notes
+	n.Name = strings.TrimPrefix(r.URL.Path, "/")
 	if err != nil {
 		if errors.Is(err, note.ErrNotFound) {
 			a.error(w, r, http.StatusNotFound, err)
@@ -132,55 +355,105 @@ func (a *api) getHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) updateHandler(w http.ResponseWriter, r *http.Request) {
-	name := strings.TrimPrefix(r.URL.Path, "/")
-	if _, err := a.backend.Get(name); err != nil {
+	b, name, err := a.target(r, note.ScopeWrite)
+	if err != nil {
+		a.targetError(w, r, err)
+		return
+	}
+	old, err := b.Get(name)
+	if err != nil {
+		if errors.Is(err, note.ErrNotFound) {
+			a.error(w, r, http.StatusNotFound, err)
+			return
+		}
 		a.error(w, r, http.StatusBadRequest, note.ErrInvalidName)
 		return
 	}
 
 	n := note.Note{}
-	err := json.NewDecoder(r.Body).Decode(&n)
-	if err != nil {
-		a.error(w, r, http.StatusBadRequest, err)
+	if code, err := a.decodeNote(w, r, &n); err != nil {
+		a.error(w, r, code, err)
 		return
 	}
-	err = a.backend.Update(name, n.Data)
-	if err != nil {
-		a.error(w, r, http.StatusInternalServerError, err)
-		return
+	if n.Data != nil {
+		err = b.Update(name, n.Data)
+		if err != nil && !(n.Tags != nil && errors.Is(err, note.ErrNotModified)) {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if n.Tags != nil {
+		t, ok := b.(note.Tagger)
+		if !ok {
+			a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+			return
+		}
+		err = t.Tag(name, n.Tags)
+		if err != nil {
+			a.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if nn, err := b.Get(name); err == nil {
+		a.emit(r, note.EventUpdated, nn)
+		if err := a.users.moveLinks(owner(r), name, old.Id, nn.Id); err != nil {
+			a.log.Error("updating links", "name", name, "request_id", requestID(r), "err", err)
+		}
 	}
 	a.response(w, r, nil)
 }
 
 func (a *api) deleteHandler(w http.ResponseWriter, r *http.Request) {
-	var err error
-	name := strings.TrimPrefix(r.URL.Path, "/")
+	// grants never give admin scope, so only owners can delete
+	b, name, err := a.target(r, note.ScopeAdmin)
+	if err != nil {
+		a.targetError(w, r, err)
+		return
+	}
 	n := note.Note{}
 
-	if n, err = a.backend.Get(name); err != nil {
+	if n, err = b.Get(name); err != nil {
 		if !errors.Is(err, note.ErrIntegrityFail) {
 			a.error(w, r, http.StatusBadRequest, err)
 			return
 		}
 	}
 
-	err = a.backend.Delete(&n)
+	// clients sending the note they read only delete that version
+	read := note.Note{}
+	if code, err := a.decodeNote(w, r, &read); err != nil && !errors.Is(err, io.EOF) {
+		a.error(w, r, code, err)
+		return
+	}
+	if read.Id != "" && read.Id != n.Id {
+		a.error(w, r, http.StatusNotFound, note.ErrNotFound)
+		return
+	}
+
+	err = b.Delete(&n)
 	if err != nil {
 		a.error(w, r, http.StatusInternalServerError, err)
 		return
 	}
+	a.emit(r, note.EventDeleted, n)
+	if err := a.users.dropLinks(owner(r), name); err != nil {
+		a.log.Error("revoking links", "name", name, "request_id", requestID(r), "err", err)
+	}
 	a.response(w, r, nil)
 }
 
 func (a *api) searchHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("query")
-	var list []note.Note
-	var err error
 	if query == "" {
 		a.error(w, r, http.StatusBadRequest, ErrInvalidQuery)
 		return
 	}
-	list, err = a.backend.Search(query)
+	b, err := a.store(r)
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	list, err := b.Search(query)
 	if err != nil {
 		a.error(w, r, http.StatusInternalServerError, err)
 		return
@@ -190,11 +463,26 @@ func (a *api) searchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) error(w http.ResponseWriter, r *http.Request, code int, err error) {
-	fmt.Printf("%d, %s, %v\n", code, r.URL.Path, err)
-	w.WriteHeader(code)
+	level := slog.LevelDebug
+	if code >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	a.log.Log(r.Context(), level, "request failed", "status", code, "path", r.URL.Path,
+		"request_id", requestID(r), "err", err)
+	body := apiError{
+		Code:      errorCode(code, err),
+		Message:   http.StatusText(code),
+		RequestId: requestID(r),
+	}
 	if err != nil {
-		w.Write([]byte(err.Error()))
+		body.Message = err.Error()
 	}
+	ret, _ := json.Marshal(body)
+
+	cors(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(ret)
 }
 
 func (a *api) response(w http.ResponseWriter, r *http.Request, data any) {
@@ -202,31 +490,123 @@ func (a *api) response(w http.ResponseWriter, r *http.Request, data any) {
 }
 
 func (a *api) raw_response(w http.ResponseWriter, r *http.Request, code int, data any) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-	w.Header().Add("Access-Control-Allow-Methods", "GET, OPTIONS, POST, PUT, DELETE, PATCH")
 	if data == nil {
+		cors(w)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(code)
 	ret, err := json.Marshal(data)
 	if err != nil {
 		a.error(w, r, http.StatusInternalServerError, err)
 		return
 	}
+	cors(w)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(ret)
 }
 
+func cors(w http.ResponseWriter) {
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+	w.Header().Add("Access-Control-Allow-Methods", "GET, OPTIONS, POST, PUT, DELETE, PATCH")
+	w.Header().Add("Access-Control-Expose-Headers", "X-Request-Id")
+}
+
 func (a *api) auth(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bearer := r.Header.Get("Authorization")
-		token := strings.TrimPrefix(bearer, "Bearer ")
-		if a.token != token && r.Method != http.MethodOptions {
-			fmt.Println("auth failed", r.RemoteAddr)
+		if r.Method == http.MethodOptions {
+			f(w, r)
+			return
+		}
+		if !a.throttle(w, r) {
+			return
+		}
+		t, err := a.authenticate(r)
+		if err != nil {
+			a.log.Warn("auth failed", "remote", r.RemoteAddr, "request_id", requestID(r), "err", err)
+			a.lockout.fail(a.clientIP(r))
 			a.error(w, r, http.StatusUnauthorized, nil)
 			return
 		}
-		f(w, r)
+		a.lockout.reset(a.clientIP(r))
+		if ok, d := a.tokenLimit.allow("token:" + t.Id); !ok {
+			a.tooMany(w, r, d, ErrRateLimited)
+			return
+		}
+		if !t.Scope.Allows(needScope(r)) {
+			a.error(w, r, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		setAccessUser(r, t.User)
+		f(w, r.WithContext(context.WithValue(r.Context(), tokenKey, t)))
+	}
+}
+
+// authenticate returns the token used in r. NOTE_HTTPS_TOKEN has no user and
+// gives write access to the shared store.
+func (a *api) authenticate(r *http.Request) (note.Token, error) {
+	bearer := r.Header.Get("Authorization")
+	secret := strings.TrimPrefix(bearer, "Bearer ")
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(a.token), []byte(secret)) == 1 {
+		return note.Token{Scope: note.ScopeWrite}, nil
+	}
+	return a.users.lookup(secret)
+}
+
+func needScope(r *http.Request) note.Scope {
+	if r.URL.Path == apiPrefix+"tokens" || strings.HasPrefix(r.URL.Path, apiPrefix+"tokens/") {
+		return note.ScopeAdmin
+	}
+	if r.Method == http.MethodGet {
+		return note.ScopeRead
+	}
+	return note.ScopeWrite
+}
+
+func session(r *http.Request) note.Token {
+	t, _ := r.Context().Value(tokenKey).(note.Token)
+	return t
+}
+
+// store returns the backend of the user authenticated in r. Requests made
+// with NOTE_HTTPS_TOKEN use the shared store.
+func (a *api) store(r *http.Request) (note.Backend, error) {
+	return a.storeOf(session(r).User)
+}
+
+// open returns the store of user, "" for the shared store.
+func (a *api) open(user string) (note.Backend, error) {
+	if a.storeURL == "" {
+		if user == "" {
+			return local.NewBackend(".note"), nil
+		}
+		return local.NewBackendAt(a.users.root(user)), nil
+	}
+	if user == "" {
+		user = "~"
+	}
+	return note.Open(strings.ReplaceAll(a.storeURL, "{user}", url.PathEscape(user)))
+}
+
+// storeOf returns the initialized store of user. Stores failing to open or
+// init are not kept, the next request tries again.
+func (a *api) storeOf(user string) (note.Backend, error) {
+	if user == "" {
+		return a.backend, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if b, ok := a.backends[user]; ok {
+		return b, nil
+	}
+	b, err := a.open(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Init(); err != nil {
+		return nil, err
 	}
+	a.backends[user] = b
+	return b, nil
 }
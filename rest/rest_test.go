@@ -0,0 +1,221 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/internal/https"
+	"github.com/serboupal/note/internal/local"
+	"github.com/serboupal/note/internal/notetest"
+	"github.com/serboupal/note/note"
+)
+
+// newTestServer serves every route of a, with secret as NOTE_HTTPS_TOKEN
+// for the shared store.
+func newTestServer(t *testing.T, a *api, secret string) *httptest.Server {
+	t.Helper()
+	a.token = secret
+	a.ipLimit = newLimiter(0, 0)
+	a.tokenLimit = newLimiter(0, 0)
+	a.lockout = newLockout(0, 0)
+	a.maxNoteSize = DefaultMaxNoteSize
+	a.backend = local.NewBackendAt(t.TempDir())
+	if err := a.backend.Init(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(a.handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestHTTPSBackend holds the client and the server together to the backend
+// contract.
+func TestHTTPSBackend(t *testing.T) {
+	notetest.Run(t, func(t *testing.T) note.Backend {
+		srv := newTestServer(t, newTestAPI(t), "secret")
+		h := https.NewBackend(srv.URL, "secret", https.WithRetries(0))
+		if err := h.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	})
+}
+
+// request sends body as JSON to path with secret as bearer token. The
+// response body is read into out when it is not nil.
+func request(t *testing.T, srv *httptest.Server, secret, method, path string, body, out any) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+// addUser creates the account name and returns its admin token.
+func addUser(t *testing.T, a *api, name string) string {
+	t.Helper()
+	secret, err := a.users.add(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestNamespaces(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	secrets := map[string]string{
+		"":    "shared",
+		"amy": addUser(t, a, "amy"),
+		"bob": addUser(t, a, "bob"),
+	}
+	for user, secret := range secrets {
+		resp := request(t, srv, secret, "POST", "/", note.Note{Name: "todo", Data: []byte("of " + user)}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("create as %q: %s", user, resp.Status)
+		}
+	}
+	for user, secret := range secrets {
+		n := note.Note{}
+		request(t, srv, secret, "GET", "/todo", nil, &n)
+		if string(n.Data) != "of "+user {
+			t.Errorf("%q reads %q", user, n.Data)
+		}
+		list := []note.Note{}
+		request(t, srv, secret, "GET", "/", nil, &list)
+		if len(list) != 1 {
+			t.Errorf("%q lists %d notes", user, len(list))
+		}
+	}
+
+	if err := a.users.remove("amy"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := request(t, srv, secrets["amy"], "GET", "/todo", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("removed user: %s", resp.Status)
+	}
+	if _, err := a.users.add("amy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.NewBackendAt(a.users.root("amy")).Get("todo"); err == nil {
+		t.Error("new account with the name of a removed one sees its notes")
+	}
+}
+
+func TestInvalidUser(t *testing.T) {
+	u := &users{dir: t.TempDir()}
+	for _, name := range []string{"", "a b", "a/b", `a\b`, "a,b", "~amy", "_/x", ".."} {
+		if _, err := u.add(name); err != ErrInvalidUser {
+			t.Errorf("add(%q): %v, want %v", name, err, ErrInvalidUser)
+		}
+	}
+	if _, err := u.add("amy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.add("amy"); err != ErrUserExist {
+		t.Errorf("add twice: %v, want %v", err, ErrUserExist)
+	}
+	if err := u.remove("bob"); err != ErrNoUser {
+		t.Errorf("remove missing: %v, want %v", err, ErrNoUser)
+	}
+}
+
+// send sends the raw body to path and returns the status and error code.
+func send(t *testing.T, srv *httptest.Server, secret, method, path string, body []byte) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	e := apiError{}
+	json.NewDecoder(resp.Body).Decode(&e)
+	return resp.StatusCode, e.Code
+}
+
+func TestValidation(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	a.maxNoteSize = 1000
+	admin := addUser(t, a, "amy")
+	marshal := func(v any) []byte {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	big := bytes.Repeat([]byte("a"), 1001)
+	if status, _ := send(t, srv, "shared", "POST", "/", marshal(note.Note{Name: "todo", Data: []byte("a")})); status != http.StatusOK {
+		t.Fatalf("create: %d", status)
+	}
+	for _, c := range []struct {
+		name, secret, method, path string
+		body                       []byte
+		status                     int
+		code                       string
+	}{
+		{"note too large", "", "POST", "/", marshal(note.Note{Name: "big", Data: big}), http.StatusRequestEntityTooLarge, "too_large"},
+		{"update too large", "", "PUT", "/todo", marshal(note.Note{Data: big}), http.StatusRequestEntityTooLarge, "too_large"},
+		{"body too large", "", "POST", "/", marshal(note.Note{Name: "big", Tags: []string{string(bytes.Repeat([]byte("t"), 70<<10))}}), http.StatusRequestEntityTooLarge, "too_large"},
+		{"other body too large", admin, "POST", "/_/tokens", marshal(map[string]string{"scope": string(bytes.Repeat([]byte("s"), 70<<10))}), http.StatusRequestEntityTooLarge, "too_large"},
+		{"invalid json", "", "POST", "/", []byte(`{"name":`), http.StatusBadRequest, "bad_request"},
+		{"no name", "", "POST", "/", marshal(note.Note{Data: []byte("a")}), http.StatusBadRequest, "invalid_name"},
+		{"invalid name", "", "POST", "/", marshal(note.Note{Name: "a<b", Data: []byte("a")}), http.StatusBadRequest, "invalid_name"},
+		{"wrong id", "", "POST", "/", marshal(note.Note{Id: "abc", Name: "other", Data: []byte("a")}), http.StatusBadRequest, "integrity_fail"},
+		{"invalid label", "", "POST", "/", marshal(note.Note{Name: "other", Data: []byte("a"), Tags: []string{"a,b"}}), http.StatusBadRequest, "invalid_label"},
+	} {
+		if c.secret == "" {
+			c.secret = "shared"
+		}
+		status, code := send(t, srv, c.secret, c.method, c.path, c.body)
+		if status != c.status || code != c.code {
+			t.Errorf("%s: %d %s, want %d %s", c.name, status, code, c.status, c.code)
+		}
+	}
+
+	// the server computes the metadata, a client can't lie about it
+	date := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := marshal(note.Note{Name: "other", Data: []byte("abc"), Size: 99, Date: &date})
+	if status, _ := send(t, srv, "shared", "POST", "/", body); status != http.StatusOK {
+		t.Fatalf("create: %d", status)
+	}
+	n := note.Note{}
+	request(t, srv, "shared", "GET", "/other", nil, &n)
+	want, _ := note.NewNote("other", "", []byte("abc"))
+	if n.Size == 99 || n.Id != want.Id || n.Date.Year() == 2000 {
+		t.Errorf("stored metadata: %+v", n)
+	}
+}
@@ -0,0 +1,340 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+// sharer records that owner shared notes with user, so the notes shared with
+// a user are found without reading the grants of every store.
+type sharer struct {
+	user  string
+	owner string
+}
+
+func (s *sharer) String() string {
+	return fmt.Sprintf("%s,%s", s.user, s.owner)
+}
+
+func (s *sharer) Parse(str string) error {
+	user, owner, ok := strings.Cut(str, ",")
+	if !ok {
+		return fmt.Errorf("invalid sharer string")
+	}
+	s.user = user
+	s.owner = owner
+	return nil
+}
+
+func (u *users) sharersPath() string {
+	return filepath.Join(u.dir, "shared.db")
+}
+
+// sharers returns the index of grants by grantee, os.ErrNotExist if it was
+// never built.
+func (u *users) sharers() ([]sharer, error) {
+	return dbline.Open[*sharer](u.sharersPath())
+}
+
+// setSharer records whether owner shares notes with user.
+func (u *users) setSharer(owner, user string, shared bool) error {
+	all, err := u.sharers()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	e := sharer{user: user, owner: owner}
+	i := slices.Index(all, e)
+	if (i >= 0) == shared {
+		return nil
+	}
+	if shared {
+		return dbline.AppendEntry(u.sharersPath(), &e)
+	}
+	return dbline.Save(u.sharersPath(), ptrs(slices.Delete(all, i, i+1)))
+}
+
+// dropSharer removes every entry of name, as owner or as grantee.
+func (u *users) dropSharer(name string) error {
+	all, err := u.sharers()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	all = slices.DeleteFunc(all, func(s sharer) bool { return s.user == name || s.owner == name })
+	return dbline.Save(u.sharersPath(), ptrs(all))
+}
+
+// owners returns the users sharing notes with user. The index is built from
+// the grants of every store the first time.
+func (a *api) owners(user string) ([]string, error) {
+	all, err := a.users.sharers()
+	if os.IsNotExist(err) {
+		all, err = a.indexSharers()
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := []string{}
+	for _, s := range all {
+		if s.user == user {
+			r = append(r, s.owner)
+		}
+	}
+	return r, nil
+}
+
+func (a *api) indexSharers() ([]sharer, error) {
+	users, err := a.users.list()
+	if err != nil {
+		return nil, err
+	}
+	all := []sharer{}
+	for _, u := range users {
+		b, err := a.storeOf(u.Name)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := b.(note.Sharer)
+		if !ok {
+			continue
+		}
+		grants, err := s.Grants()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range grants {
+			e := sharer{user: g.User, owner: u.Name}
+			if !slices.Contains(all, e) {
+				all = append(all, e)
+			}
+		}
+	}
+	return all, dbline.Save(a.users.sharersPath(), ptrs(all))
+}
+
+// target returns the backend and note name addressed by r. Notes of other
+// users are addressed as ~owner/name and need a grant allowing scope need.
+func (a *api) target(r *http.Request, need note.Scope) (note.Backend, string, error) {
+	return a.targetName(r, strings.TrimPrefix(r.URL.Path, "/"), need)
+}
+
+func (a *api) targetName(r *http.Request, name string, need note.Scope) (note.Backend, string, error) {
+	if !strings.HasPrefix(name, "~") {
+		b, err := a.store(r)
+		return b, name, err
+	}
+
+	user := session(r).User
+	owner, name, ok := strings.Cut(name[1:], "/")
+	if !ok || user == "" {
+		return nil, "", ErrForbidden
+	}
+	if owner == user {
+		b, err := a.storeOf(owner)
+		return b, name, err
+	}
+	if _, err := a.users.get(owner); err != nil {
+		return nil, "", note.ErrNotFound
+	}
+
+	b, err := a.storeOf(owner)
+	if err != nil {
+		return nil, "", err
+	}
+	s, ok := b.(note.Sharer)
+	if !ok {
+		return nil, "", ErrForbidden
+	}
+	grants, err := s.Grants()
+	if err != nil {
+		return nil, "", err
+	}
+	list, err := b.List(name)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, n := range list {
+		if n.Name != name {
+			continue
+		}
+		scope := note.Access(grants, &n, user)
+		if scope == "" {
+			return nil, "", note.ErrNotFound
+		}
+		if !scope.Allows(need) {
+			return nil, "", ErrForbidden
+		}
+		return b, name, nil
+	}
+	return nil, "", note.ErrNotFound
+}
+
+// owner returns the user owning the note addressed by r.
+func owner(r *http.Request) string {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if strings.HasPrefix(name, "~") {
+		user, _, _ := strings.Cut(name[1:], "/")
+		return user
+	}
+	return session(r).User
+}
+
+func (a *api) targetError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, note.ErrNotFound) {
+		a.error(w, r, http.StatusNotFound, err)
+	} else if errors.Is(err, ErrForbidden) {
+		a.error(w, r, http.StatusForbidden, err)
+	} else {
+		a.error(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func (a *api) shareRouter(w http.ResponseWriter, r *http.Request) {
+	user := session(r).User
+	if user == "" {
+		a.error(w, r, http.StatusForbidden, ErrForbidden)
+		return
+	}
+	if r.URL.Path == apiPrefix+"shared" {
+		if r.Method != http.MethodGet {
+			a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+			return
+		}
+		a.sharedHandler(w, r)
+		return
+	}
+
+	b, err := a.store(r)
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s, ok := b.(note.Sharer)
+	if !ok {
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		grants, err := s.Grants()
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, grants)
+	case http.MethodPost, http.MethodDelete:
+		g := note.Grant{}
+		if code, err := a.decode(w, r, &g, maxBodySize); err != nil {
+			a.error(w, r, code, err)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			err = s.Unshare(g)
+			if err != nil {
+				a.targetError(w, r, err)
+				return
+			}
+			if err := a.unindexGrant(user, s, g.User); err != nil {
+				a.error(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			a.response(w, r, nil)
+			return
+		}
+
+		if g.User == user {
+			a.error(w, r, http.StatusBadRequest, note.ErrInvalidGrant)
+			return
+		}
+		if _, err := a.users.get(g.User); err != nil {
+			a.error(w, r, http.StatusNotFound, err)
+			return
+		}
+		err = s.Share(g)
+		if err != nil {
+			a.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := a.indexGrant(user, g.User, true); err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, nil)
+	default:
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+	}
+}
+
+// unindexGrant drops owner from the sharers of user once none of its grants
+// are for user.
+func (a *api) unindexGrant(owner string, s note.Sharer, user string) error {
+	grants, err := s.Grants()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(grants, func(g note.Grant) bool { return g.User == user }) {
+		return nil
+	}
+	return a.indexGrant(owner, user, false)
+}
+
+// indexGrant records whether owner shares notes with user, building the
+// index first if needed so it isn't left with this entry only.
+func (a *api) indexGrant(owner, user string, shared bool) error {
+	if _, err := a.owners(user); err != nil {
+		return err
+	}
+	return a.users.setSharer(owner, user, shared)
+}
+
+// sharedHandler lists the notes other users shared with the authenticated
+// user, named ~owner/name so they can be requested like any other note.
+func (a *api) sharedHandler(w http.ResponseWriter, r *http.Request) {
+	user := session(r).User
+	owners, err := a.owners(user)
+	if err != nil {
+		a.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := []note.Note{}
+	for _, owner := range owners {
+		b, err := a.storeOf(owner)
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s, ok := b.(note.Sharer)
+		if !ok {
+			continue
+		}
+		grants, err := s.Grants()
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		notes, err := b.List("")
+		if err != nil && !errors.Is(err, note.ErrNotFound) {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		for _, n := range notes {
+			if note.Access(grants, &n, user) != "" {
+				n.Name = "~" + owner + "/" + n.Name
+				list = append(list, n)
+			}
+		}
+	}
+	a.response(w, r, list)
+}
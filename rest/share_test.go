@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/serboupal/note/note"
+)
+
+func sharedNames(list []note.Note) []string {
+	r := []string{}
+	for _, n := range list {
+		r = append(r, n.Name)
+	}
+	slices.Sort(r)
+	return r
+}
+
+func TestShares(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	amy, bob, eve := addUser(t, a, "amy"), addUser(t, a, "bob"), addUser(t, a, "eve")
+	for _, n := range []note.Note{
+		{Name: "todo", Data: []byte("milk")},
+		{Name: "plan", Data: []byte("ship"), Groups: []string{"team"}},
+		{Name: "private", Data: []byte("mine")},
+	} {
+		if resp := request(t, srv, amy, "POST", "/", n, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("create %s: %s", n.Name, resp.Status)
+		}
+	}
+
+	for _, tt := range []struct {
+		g    note.Grant
+		want int
+	}{
+		{note.Grant{Name: "todo", User: "bob", Scope: "read"}, http.StatusOK},
+		{note.Grant{Group: "team", User: "bob", Scope: "write"}, http.StatusOK},
+		{note.Grant{Name: "todo", User: "amy", Scope: "read"}, http.StatusBadRequest},
+		{note.Grant{Name: "todo", User: "nobody", Scope: "read"}, http.StatusNotFound},
+		{note.Grant{Name: "todo", User: "eve", Scope: "admin"}, http.StatusBadRequest},
+		{note.Grant{Name: "~bob/todo", User: "eve", Scope: "read"}, http.StatusBadRequest},
+	} {
+		if resp := request(t, srv, amy, "POST", "/_/shares", tt.g, nil); resp.StatusCode != tt.want {
+			t.Errorf("share %+v: %s, want %d", tt.g, resp.Status, tt.want)
+		}
+	}
+
+	tests := []struct {
+		secret, method, path string
+		body                 any
+		want                 int
+	}{
+		{bob, "GET", "/~amy/todo", nil, http.StatusOK},
+		{bob, "GET", "/~amy/plan", nil, http.StatusOK},
+		{bob, "PUT", "/~amy/todo", note.Note{Data: []byte("x")}, http.StatusForbidden},
+		{bob, "PUT", "/~amy/plan", note.Note{Data: []byte("ship it")}, http.StatusOK},
+		{bob, "DELETE", "/~amy/plan", nil, http.StatusForbidden},
+		{bob, "GET", "/~amy/private", nil, http.StatusNotFound},
+		{bob, "GET", "/~amy/missing", nil, http.StatusNotFound},
+		{bob, "GET", "/~nobody/todo", nil, http.StatusNotFound},
+		{eve, "GET", "/~amy/todo", nil, http.StatusNotFound},
+		{amy, "GET", "/~amy/private", nil, http.StatusOK},
+		{"shared", "GET", "/~amy/todo", nil, http.StatusForbidden},
+		{amy, "POST", "/", note.Note{Name: "~bob/todo", Data: []byte("x")}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := request(t, srv, tt.secret, tt.method, tt.path, tt.body, nil); resp.StatusCode != tt.want {
+			t.Errorf("%s %s: %s, want %d", tt.method, tt.path, resp.Status, tt.want)
+		}
+	}
+
+	list := []note.Note{}
+	request(t, srv, bob, "GET", "/_/shared", nil, &list)
+	if got := sharedNames(list); !slices.Equal(got, []string{"~amy/plan", "~amy/todo"}) {
+		t.Errorf("shared with bob: %q", got)
+	}
+	list = []note.Note{}
+	request(t, srv, eve, "GET", "/_/shared", nil, &list)
+	if len(list) != 0 {
+		t.Errorf("shared with eve: %q", sharedNames(list))
+	}
+
+	request(t, srv, amy, "DELETE", "/_/shares", note.Grant{Name: "todo", User: "bob", Scope: "read"}, nil)
+	if resp := request(t, srv, bob, "GET", "/~amy/todo", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unshared note: %s", resp.Status)
+	}
+	owners, err := a.owners("bob")
+	if err != nil || !slices.Equal(owners, []string{"amy"}) {
+		t.Errorf("bob still has a group share of amy: %q, %v", owners, err)
+	}
+	request(t, srv, amy, "DELETE", "/_/shares", note.Grant{Group: "team", User: "bob", Scope: "write"}, nil)
+	if owners, err := a.owners("bob"); err != nil || len(owners) != 0 {
+		t.Errorf("owners of bob after unsharing everything: %q, %v", owners, err)
+	}
+}
+
+// TestSharersIndex checks that grants made before the index existed are
+// found, and that removed users leave it.
+func TestSharersIndex(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	addUser(t, a, "amy")
+	bob := addUser(t, a, "bob")
+	b, err := a.storeOf("amy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := note.NewNote("todo", "", []byte("milk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Create(n); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.(note.Sharer).Share(note.Grant{Name: "todo", User: "bob", Scope: "read"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a.users.sharersPath()); !os.IsNotExist(err) {
+		t.Fatalf("index exists before it is needed: %v", err)
+	}
+
+	list := []note.Note{}
+	request(t, srv, bob, "GET", "/_/shared", nil, &list)
+	if len(list) != 1 || list[0].Name != "~amy/todo" {
+		t.Errorf("shared with bob: %+v", list)
+	}
+	if err := a.users.remove("amy"); err != nil {
+		t.Fatal(err)
+	}
+	if owners, err := a.owners("bob"); err != nil || len(owners) != 0 {
+		t.Errorf("removed user still indexed: %q, %v", owners, err)
+	}
+}
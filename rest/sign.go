@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/serboupal/note/note"
+)
+
+// signatureRouter serves /_/signatures/{name}, listing the signatures of a
+// note with GET and adding one with POST.
+func (a *api) signatureRouter(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, apiPrefix+"signatures/")
+	need := note.ScopeRead
+	if r.Method == http.MethodPost {
+		need = note.ScopeWrite
+	} else if r.Method != http.MethodGet {
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+		return
+	}
+
+	b, name, err := a.targetName(r, name, need)
+	if err != nil {
+		a.targetError(w, r, err)
+		return
+	}
+	s, ok := b.(note.Signer)
+	if !ok {
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		list, err := s.Signatures(name)
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, list)
+		return
+	}
+
+	sig := note.Signature{}
+	if code, err := a.decode(w, r, &sig, maxBodySize); err != nil {
+		a.error(w, r, code, err)
+		return
+	}
+	if _, err := b.Get(name); err != nil && !errors.Is(err, note.ErrIntegrityFail) {
+		a.targetError(w, r, err)
+		return
+	}
+	err = s.AddSignature(name, sig)
+	if errors.Is(err, note.ErrStaleSignature) {
+		a.error(w, r, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		a.error(w, r, http.StatusBadRequest, err)
+		return
+	}
+	a.response(w, r, nil)
+}
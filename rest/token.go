@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+var (
+	ErrNoToken      = errors.New("token not found")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// token is the server side record of an API token, only the SHA-256 of the
+// secret is stored.
+type token struct {
+	note.Token
+	hash string
+}
+
+func (t *token) String() string {
+	exp := ""
+	if t.Expires != nil {
+		exp = t.Expires.Format(time.DateTime)
+	}
+	return fmt.Sprintf("%s,%s,%s,%s,%s,%s", t.Id, t.hash, t.User, t.Scope,
+		t.Date.Format(time.DateTime), exp)
+}
+
+func (t *token) Parse(s string) error {
+	item := strings.Split(s, ",")
+	if len(item) != 6 {
+		return fmt.Errorf("invalid token string")
+	}
+
+	ti, err := time.Parse(time.DateTime, item[4])
+	if err != nil {
+		return err
+	}
+	if item[5] != "" {
+		exp, err := time.Parse(time.DateTime, item[5])
+		if err != nil {
+			return err
+		}
+		t.Expires = &exp
+	}
+	scope, err := note.ParseScope(item[3])
+	if err != nil {
+		return err
+	}
+	t.Id = item[0]
+	t.hash = item[1]
+	t.User = item[2]
+	t.Scope = scope
+	t.Date = &ti
+	return nil
+}
+
+func (t *token) expired(now time.Time) bool {
+	return t.Expires != nil && now.After(*t.Expires)
+}
+
+func (u *users) tokens() ([]token, error) {
+	r, err := dbline.Open[*token](filepath.Join(u.dir, "tokens.db"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// userTokens returns the tokens of name, or every token if name is empty.
+func (u *users) userTokens(name string) ([]note.Token, error) {
+	tokens, err := u.tokens()
+	if err != nil {
+		return nil, err
+	}
+	r := []note.Token{}
+	for _, v := range tokens {
+		if name == "" || v.User == name {
+			r = append(r, v.Token)
+		}
+	}
+	return r, nil
+}
+
+// newToken creates a token for name, a zero ttl never expires. The secret is
+// only returned here.
+func (u *users) newToken(name string, scope note.Scope, ttl time.Duration) (note.Token, error) {
+	if _, err := u.get(name); err != nil {
+		return note.Token{}, err
+	}
+	scope, err := note.ParseScope(string(scope))
+	if err != nil {
+		return note.Token{}, err
+	}
+
+	buf := make([]byte, 40)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return note.Token{}, err
+	}
+	ti := time.Now().UTC().Truncate(time.Second)
+	t := token{
+		Token: note.Token{
+			Id:    fmt.Sprintf("%x", buf[:8]),
+			User:  name,
+			Scope: scope,
+			Date:  &ti,
+		},
+	}
+	if ttl > 0 {
+		exp := ti.Add(ttl)
+		t.Expires = &exp
+	}
+	secret := fmt.Sprintf("%x", buf[8:])
+	t.hash = hashToken(secret)
+
+	err = dbline.AppendEntry(filepath.Join(u.dir, "tokens.db"), &t)
+	if err != nil {
+		return note.Token{}, err
+	}
+	t.Secret = secret
+	return t.Token, nil
+}
+
+// revokeToken deletes token id. If name is not empty the token must belong
+// to that user.
+func (u *users) revokeToken(name, id string) error {
+	tokens, err := u.tokens()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(tokens, func(t token) bool {
+		return t.Id == id && (name == "" || t.User == name)
+	})
+	if i < 0 {
+		return ErrNoToken
+	}
+	return dbline.Save(filepath.Join(u.dir, "tokens.db"), ptrs(slices.Delete(tokens, i, i+1)))
+}
+
+// lookup returns the token matching secret. It reads the tokens file on every
+// call so tokens can be managed while the server is running.
+func (u *users) lookup(secret string) (note.Token, error) {
+	if secret == "" {
+		return note.Token{}, ErrNoToken
+	}
+	tokens, err := u.tokens()
+	if err != nil {
+		return note.Token{}, err
+	}
+	h := []byte(hashToken(secret))
+	found := -1
+	for i, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.hash), h) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return note.Token{}, ErrNoToken
+	}
+	if tokens[found].expired(time.Now()) {
+		return note.Token{}, ErrTokenExpired
+	}
+	return tokens[found].Token, nil
+}
+
+func hashToken(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
+
+// CreateToken creates a token for user valid for ttl, zero never expires.
+func CreateToken(user string, scope note.Scope, ttl time.Duration) (note.Token, error) {
+	u, err := newUsers()
+	if err != nil {
+		return note.Token{}, err
+	}
+	return u.newToken(user, scope, ttl)
+}
+
+// RevokeToken deletes the token with id.
+func RevokeToken(id string) error {
+	u, err := newUsers()
+	if err != nil {
+		return err
+	}
+	return u.revokeToken("", id)
+}
+
+// Tokens returns the tokens of user, or all of them when user is empty.
+func Tokens(user string) ([]note.Token, error) {
+	u, err := newUsers()
+	if err != nil {
+		return nil, err
+	}
+	return u.userTokens(user)
+}
+
+func (a *api) tokenRouter(w http.ResponseWriter, r *http.Request) {
+	user := session(r).User
+	if user == "" {
+		a.error(w, r, http.StatusForbidden, ErrForbidden)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, apiPrefix+"tokens"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		list, err := a.users.userTokens(user)
+		if err != nil {
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, list)
+	case r.Method == http.MethodPost && id == "":
+		req := note.Token{}
+		if code, err := a.decode(w, r, &req, maxBodySize); err != nil {
+			a.error(w, r, code, err)
+			return
+		}
+		var ttl time.Duration
+		if req.Expires != nil {
+			ttl = time.Until(*req.Expires)
+			if ttl <= 0 {
+				a.error(w, r, http.StatusBadRequest, ErrTokenExpired)
+				return
+			}
+		}
+		t, err := a.users.newToken(user, req.Scope, ttl)
+		if err != nil {
+			a.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		a.response(w, r, t)
+	case r.Method == http.MethodDelete && id != "":
+		err := a.users.revokeToken(user, id)
+		if err != nil {
+			if errors.Is(err, ErrNoToken) {
+				a.error(w, r, http.StatusNotFound, err)
+				return
+			}
+			a.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.response(w, r, nil)
+	default:
+		a.error(w, r, http.StatusNotImplemented, ErrNotImplemented)
+	}
+}
@@ -0,0 +1,235 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+func TestTokenExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ti := now.Add(d)
+		return &ti
+	}
+	tests := []struct {
+		name    string
+		expires *time.Time
+		want    bool
+	}{
+		{"never", nil, false},
+		{"later", at(time.Second), false},
+		{"now", at(0), false},
+		{"before", at(-time.Second), true},
+	}
+	for _, tt := range tests {
+		tk := token{Token: note.Token{Expires: tt.expires}}
+		if got := tk.expired(now); got != tt.want {
+			t.Errorf("%s: expired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenParse(t *testing.T) {
+	ti := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	exp := ti.Add(time.Hour)
+	for _, tk := range []token{
+		{Token: note.Token{Id: "a1", User: "amy", Scope: note.ScopeRead, Date: &ti}, hash: "h"},
+		{Token: note.Token{Id: "b2", User: "bob", Scope: note.ScopeAdmin, Date: &ti, Expires: &exp}, hash: "h"},
+	} {
+		got := token{}
+		if err := got.Parse(tk.String()); err != nil {
+			t.Fatalf("Parse(%q): %v", tk.String(), err)
+		}
+		if got.String() != tk.String() || got.hash != tk.hash || got.Scope != tk.Scope {
+			t.Errorf("Parse(%q) = %+v", tk.String(), got)
+		}
+	}
+	for _, s := range []string{"", "a1,h,amy,read,2024-01-01 12:00:00", "a1,h,amy,root,2024-01-01 12:00:00,",
+		"a1,h,amy,read,yesterday,"} {
+		if err := (&token{}).Parse(s); err == nil {
+			t.Errorf("Parse(%q) gave no error", s)
+		}
+	}
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	u := &users{dir: t.TempDir()}
+	if _, err := u.newToken("amy", note.ScopeRead, 0); err == nil {
+		t.Error("token created for a missing user")
+	}
+	if _, err := u.add("amy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.newToken("amy", "root", 0); !errors.Is(err, note.ErrInvalidScope) {
+		t.Errorf("invalid scope: %v, want %v", err, note.ErrInvalidScope)
+	}
+
+	forever, err := u.newToken("amy", note.ScopeRead, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour, err := u.newToken("amy", note.ScopeWrite, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forever.Expires != nil || hour.Expires == nil || !hour.Expires.Equal(hour.Date.Add(time.Hour)) {
+		t.Errorf("expires %v and %v", forever.Expires, hour.Expires)
+	}
+	if forever.Secret == "" || forever.Secret == hour.Secret {
+		t.Errorf("secrets %q and %q", forever.Secret, hour.Secret)
+	}
+
+	for _, tk := range []note.Token{forever, hour} {
+		got, err := u.lookup(tk.Secret)
+		if err != nil || got.Id != tk.Id || got.Scope != tk.Scope || got.User != "amy" || got.Secret != "" {
+			t.Errorf("lookup %s: %+v, %v", tk.Id, got, err)
+		}
+	}
+	if _, err := u.lookup(""); !errors.Is(err, ErrNoToken) {
+		t.Errorf("lookup empty secret: %v, want %v", err, ErrNoToken)
+	}
+	if _, err := u.lookup("nope"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("lookup wrong secret: %v, want %v", err, ErrNoToken)
+	}
+	if tokens, _ := u.tokens(); strings.Contains(tokens[len(tokens)-1].String(), hour.Secret) {
+		t.Error("secret kept in the tokens file")
+	}
+
+	expire(t, u, hour.Id)
+	if _, err := u.lookup(hour.Secret); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("lookup expired: %v, want %v", err, ErrTokenExpired)
+	}
+
+	if err := u.revokeToken("bob", forever.Id); !errors.Is(err, ErrNoToken) {
+		t.Errorf("revoke token of another user: %v, want %v", err, ErrNoToken)
+	}
+	if err := u.revokeToken("amy", forever.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.lookup(forever.Secret); !errors.Is(err, ErrNoToken) {
+		t.Errorf("lookup revoked: %v, want %v", err, ErrNoToken)
+	}
+}
+
+// expire moves the expiry of token id to the past.
+func expire(t *testing.T, u *users, id string) {
+	t.Helper()
+	tokens, err := u.tokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	for i := range tokens {
+		if tokens[i].Id == id {
+			tokens[i].Expires = &past
+		}
+	}
+	if err := dbline.Save(filepath.Join(u.dir, "tokens.db"), ptrs(tokens)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	addUser(t, a, "amy")
+	secrets := map[note.Scope]string{}
+	for _, s := range []note.Scope{note.ScopeRead, note.ScopeWrite, note.ScopeAdmin} {
+		tk, err := a.users.newToken("amy", s, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets[s] = tk.Secret
+	}
+	expired, err := a.users.newToken("amy", note.ScopeAdmin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire(t, a.users, expired.Id)
+	for _, secret := range []string{secrets[note.ScopeWrite], "shared"} {
+		resp := request(t, srv, secret, "POST", "/", note.Note{Name: "todo", Data: []byte("milk")}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal(resp.Status)
+		}
+	}
+
+	tests := []struct {
+		method, path string
+		secret       string
+		want         int
+	}{
+		{"GET", "/todo", secrets[note.ScopeRead], http.StatusOK},
+		{"GET", "/todo", secrets[note.ScopeWrite], http.StatusOK},
+		{"GET", "/todo", "shared", http.StatusOK},
+		{"GET", "/todo", "", http.StatusUnauthorized},
+		{"GET", "/todo", "wrong", http.StatusUnauthorized},
+		{"GET", "/todo", expired.Secret, http.StatusUnauthorized},
+		{"POST", "/", secrets[note.ScopeRead], http.StatusForbidden},
+		{"DELETE", "/todo", secrets[note.ScopeRead], http.StatusForbidden},
+		{"PUT", "/todo", secrets[note.ScopeRead], http.StatusForbidden},
+		{"GET", "/_/tokens", secrets[note.ScopeRead], http.StatusForbidden},
+		{"GET", "/_/tokens", secrets[note.ScopeWrite], http.StatusForbidden},
+		{"GET", "/_/tokens", "shared", http.StatusForbidden},
+		{"GET", "/_/tokens", secrets[note.ScopeAdmin], http.StatusOK},
+		{"DELETE", "/_/tokens/" + expired.Id, secrets[note.ScopeWrite], http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		resp := request(t, srv, tt.secret, tt.method, tt.path, nil, nil)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestTokenEndpoints(t *testing.T) {
+	a := newTestAPI(t)
+	srv := newTestServer(t, a, "shared")
+	admin := addUser(t, a, "amy")
+	other := addUser(t, a, "bob")
+
+	exp := time.Now().Add(time.Hour)
+	created := note.Token{}
+	resp := request(t, srv, admin, "POST", "/_/tokens", note.Token{Scope: "ro", Expires: &exp}, &created)
+	if resp.StatusCode != http.StatusOK || created.Secret == "" || created.Scope != note.ScopeRead ||
+		created.User != "amy" || created.Expires == nil {
+		t.Fatalf("create: %s %+v", resp.Status, created)
+	}
+	if resp := request(t, srv, created.Secret, "GET", "/_/tokens", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("read token lists tokens: %s", resp.Status)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	for _, body := range []note.Token{{Scope: "root"}, {Scope: note.ScopeRead, Expires: &past}} {
+		if resp := request(t, srv, admin, "POST", "/_/tokens", body, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("create %+v: %s", body, resp.Status)
+		}
+	}
+
+	list := []note.Token{}
+	request(t, srv, admin, "GET", "/_/tokens", nil, &list)
+	if len(list) != 2 {
+		t.Fatalf("amy has %d tokens, want 2", len(list))
+	}
+	for _, tk := range list {
+		if tk.Secret != "" || tk.User != "amy" {
+			t.Errorf("listed %+v", tk)
+		}
+	}
+
+	if resp := request(t, srv, other, "DELETE", "/_/tokens/"+created.Id, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoke token of another user: %s", resp.Status)
+	}
+	if resp := request(t, srv, admin, "DELETE", "/_/tokens/"+created.Id, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("revoke: %s", resp.Status)
+	}
+	if resp := request(t, srv, created.Secret, "GET", "/", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revoked token: %s", resp.Status)
+	}
+}
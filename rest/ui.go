@@ -0,0 +1,25 @@
+package rest
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the web client under /_/ui/. It is not behind auth, the
+// client asks for a token and sends it to the API like any other client.
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix(apiPrefix+"ui/", http.FileServer(http.FS(sub)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-src 'self'; style-src 'self' 'unsafe-inline'; img-src https: 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		files.ServeHTTP(w, r)
+	})
+}
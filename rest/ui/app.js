@@ -0,0 +1,212 @@
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+let current = null;
+
+function token() {
+  return localStorage.getItem("note-token");
+}
+
+async function api(method, path, body) {
+  const resp = await fetch(path, {
+    method,
+    headers: { "Authorization": "Bearer " + token(), "Content-Type": "application/json" },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    logout();
+    throw new Error("unauthenticated");
+  }
+  if (!resp.ok) {
+    const body = await resp.json().catch(() => ({}));
+    const err = new Error(body.message || resp.statusText);
+    err.code = body.code;
+    throw err;
+  }
+  return resp;
+}
+
+async function json(method, path, body) {
+  const resp = await api(method, path, body);
+  const text = await resp.text();
+  return text ? JSON.parse(text) : null;
+}
+
+// note data travels as base64 encoded bytes
+function encode(text) {
+  let bin = "";
+  for (const b of new TextEncoder().encode(text)) {
+    bin += String.fromCharCode(b);
+  }
+  return btoa(bin);
+}
+
+function decode(data) {
+  return new TextDecoder().decode(Uint8Array.from(atob(data || ""), (c) => c.charCodeAt(0)));
+}
+
+function path(name) {
+  return "/" + name.split("/").map(encodeURIComponent).join("/");
+}
+
+function show(id) {
+  for (const v of ["view", "editor"]) {
+    $(v).hidden = v !== id;
+  }
+}
+
+function fail(err) {
+  $("error").textContent = err.message;
+  setTimeout(() => { $("error").textContent = ""; }, 5000);
+}
+
+async function refresh() {
+  const q = $("query").value.trim();
+  let notes;
+  try {
+    if (q && $("content").checked) {
+      notes = await json("GET", "/search?query=" + encodeURIComponent(q));
+    } else {
+      notes = await json("GET", "/?filter=" + encodeURIComponent(q));
+    }
+  } catch (err) {
+    notes = [];
+    if (err.code !== "not_found") {
+      fail(err);
+    }
+  }
+
+  const list = $("list");
+  list.replaceChildren();
+  for (const n of notes || []) {
+    const li = document.createElement("li");
+    li.textContent = n.name;
+    li.classList.toggle("active", current !== null && current.name === n.name);
+    if (n.tags) {
+      const small = document.createElement("small");
+      small.textContent = n.tags.join(", ");
+      li.append(small);
+    }
+    li.onclick = () => open(n.name).catch(fail);
+    list.append(li);
+  }
+}
+
+async function open(name) {
+  current = await json("GET", path(name));
+  const page = await api("GET", path(name) + ".html");
+  $("name").textContent = current.name;
+  $("tags").replaceChildren(...(current.tags || []).map((t) => {
+    const span = document.createElement("span");
+    span.textContent = t;
+    return span;
+  }));
+  $("page").srcdoc = await page.text();
+  show("view");
+  refresh();
+}
+
+function edit(n) {
+  current = n;
+  $("edit-name").value = n ? n.name : "";
+  $("edit-name").disabled = n !== null;
+  $("edit-tags").value = n && n.tags ? n.tags.join(", ") : "";
+  $("edit-data").value = n ? decode(n.data) : "";
+  show("editor");
+  (n ? $("edit-data") : $("edit-name")).focus();
+}
+
+async function save() {
+  const name = $("edit-name").value.trim();
+  const tags = $("edit-tags").value.split(",").map((t) => t.trim()).filter((t) => t);
+  const data = encode($("edit-data").value);
+  if (current === null) {
+    await api("POST", "/", { name, tags, data });
+  } else {
+    await api("PUT", path(name), { tags, data });
+  }
+  await open(name);
+}
+
+async function remove() {
+  if (!confirm("Delete " + current.name + "?")) {
+    return;
+  }
+  await api("DELETE", path(current.name));
+  current = null;
+  show(null);
+  refresh();
+}
+
+// watch refreshes the list when notes change, EventSource can't send the
+// token so the stream is read with fetch
+let watching = null;
+
+async function watch() {
+  const ctl = new AbortController();
+  watching = ctl;
+  try {
+    const resp = await api("GET", "/_/events");
+    const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+    ctl.signal.onabort = () => reader.cancel();
+    let buf = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        break;
+      }
+      buf += value;
+      const events = buf.split("\n\n");
+      buf = events.pop();
+      if (events.some((e) => e.includes("data:"))) {
+        refresh();
+      }
+    }
+  } catch (err) {
+    // reconnected below
+  }
+  if (!ctl.signal.aborted && token() !== null) {
+    setTimeout(watch, 5000);
+  }
+}
+
+function login() {
+  localStorage.setItem("note-token", $("token").value);
+  $("token").value = "";
+  start();
+}
+
+function logout() {
+  localStorage.removeItem("note-token");
+  if (watching) {
+    watching.abort();
+    watching = null;
+  }
+  start();
+}
+
+function start() {
+  const signed = token() !== null;
+  $("login").hidden = signed;
+  $("app").hidden = !signed;
+  if (signed) {
+    refresh();
+    if (!watching) {
+      watch();
+    }
+  } else {
+    $("token").focus();
+  }
+}
+
+$("login").onsubmit = (e) => { e.preventDefault(); login(); };
+$("logout").onclick = logout;
+$("find").onsubmit = (e) => { e.preventDefault(); refresh(); };
+$("query").oninput = () => { if (!$("content").checked) refresh(); };
+$("new").onclick = () => edit(null);
+$("edit").onclick = () => edit(current);
+$("delete").onclick = () => remove().catch(fail);
+$("cancel").onclick = () => current ? open(current.name).catch(fail) : show(null);
+$("editor").onsubmit = (e) => { e.preventDefault(); save().catch(fail); };
+
+start();
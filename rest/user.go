@@ -0,0 +1,177 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+const serverFolder = "note-server"
+
+var (
+	ErrInvalidUser = errors.New("invalid user name")
+	ErrUserExist   = errors.New("user already exist")
+	ErrNoUser      = errors.New("user not found")
+)
+
+type User struct {
+	Name string
+	Date *time.Time
+}
+
+func (u *User) String() string {
+	return fmt.Sprintf("%s,%s", u.Name, u.Date.Format(time.DateTime))
+}
+
+func (u *User) Parse(s string) error {
+	item := strings.Split(s, ",")
+	if len(item) != 2 {
+		return fmt.Errorf("invalid user string")
+	}
+
+	ti, err := time.Parse(time.DateTime, item[1])
+	if err != nil {
+		return err
+	}
+	u.Name = item[0]
+	u.Date = &ti
+	return nil
+}
+
+// users keeps the accounts and tokens of the server in its config dir. Notes
+// of every user live in their own folder under users/.
+type users struct {
+	dir string
+}
+
+func newUsers() (*users, error) {
+	cfg, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cfg, serverFolder)
+	err = os.MkdirAll(filepath.Join(dir, "users"), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &users{dir: dir}, nil
+}
+
+func (u *users) root(name string) string {
+	return filepath.Join(u.dir, "users", name)
+}
+
+func (u *users) list() ([]User, error) {
+	r, err := dbline.Open[*User](filepath.Join(u.dir, "users.db"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (u *users) get(name string) (User, error) {
+	all, err := u.list()
+	if err != nil {
+		return User{}, err
+	}
+	for _, v := range all {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return User{}, ErrNoUser
+}
+
+func (u *users) add(name string) (string, error) {
+	if invalidUser(name) {
+		return "", ErrInvalidUser
+	}
+	if _, err := u.get(name); err == nil {
+		return "", ErrUserExist
+	}
+	ti := time.Now()
+	err := dbline.AppendEntry(filepath.Join(u.dir, "users.db"), &User{Name: name, Date: &ti})
+	if err != nil {
+		return "", err
+	}
+	t, err := u.newToken(name, note.ScopeAdmin, 0)
+	return t.Secret, err
+}
+
+// remove deletes the account, its tokens and its notes, so a new account with
+// the same name starts empty.
+func (u *users) remove(name string) error {
+	all, err := u.list()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(all, func(v User) bool { return v.Name == name })
+	if i < 0 {
+		return ErrNoUser
+	}
+	err = dbline.Save(filepath.Join(u.dir, "users.db"), ptrs(slices.Delete(all, i, i+1)))
+	if err != nil {
+		return err
+	}
+
+	tokens, err := u.tokens()
+	if err != nil {
+		return err
+	}
+	tokens = slices.DeleteFunc(tokens, func(t token) bool { return t.User == name })
+	err = dbline.Save(filepath.Join(u.dir, "tokens.db"), ptrs(tokens))
+	if err != nil {
+		return err
+	}
+	err = u.dropSharer(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(u.root(name))
+}
+
+func invalidUser(name string) bool {
+	return name == "" || note.InvalidName(name) || strings.ContainsAny(name, ",/\\~")
+}
+
+func ptrs[T any](s []T) []*T {
+	r := make([]*T, len(s))
+	for i := range s {
+		r[i] = &s[i]
+	}
+	return r
+}
+
+// AddUser creates a new account on the server and returns its first token.
+func AddUser(name string) (string, error) {
+	u, err := newUsers()
+	if err != nil {
+		return "", err
+	}
+	return u.add(name)
+}
+
+// RemoveUser deletes the account and its notes and revokes all its tokens.
+func RemoveUser(name string) error {
+	u, err := newUsers()
+	if err != nil {
+		return err
+	}
+	return u.remove(name)
+}
+
+// Users returns the accounts of the server.
+func Users() ([]User, error) {
+	u, err := newUsers()
+	if err != nil {
+		return nil, err
+	}
+	return u.list()
+}
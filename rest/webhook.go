@@ -0,0 +1,409 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serboupal/note/dbline"
+	"github.com/serboupal/note/note"
+)
+
+const (
+	// webhookAttempts is how many times a delivery is tried before it is
+	// logged as failed, waiting webhookBackoff doubled after each try.
+	webhookAttempts = 5
+	webhookTimeout  = 10 * time.Second
+)
+
+var webhookBackoff = time.Second
+
+// hookBacklog is how many events wait for each webhook while it is slow or
+// down, newer events are dropped.
+var hookBacklog = 1000
+
+var (
+	ErrInvalidWebhook = errors.New("invalid webhook url")
+	ErrNoWebhook      = errors.New("webhook not found")
+)
+
+// Webhook receives the events of the store of User, or of every store when
+// User is empty. Payloads are signed with Secret.
+type Webhook struct {
+	Id     string
+	User   string
+	Secret string
+	Date   *time.Time
+	URL    string
+}
+
+func (h *Webhook) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s,%s", h.Id, h.User, h.Secret, h.Date.Format(time.DateTime), h.URL)
+}
+
+func (h *Webhook) Parse(s string) error {
+	item := strings.SplitN(s, ",", 5)
+	if len(item) != 5 {
+		return fmt.Errorf("invalid webhook string")
+	}
+
+	ti, err := time.Parse(time.DateTime, item[3])
+	if err != nil {
+		return err
+	}
+	h.Id = item[0]
+	h.User = item[1]
+	h.Secret = item[2]
+	h.Date = &ti
+	h.URL = item[4]
+	return nil
+}
+
+// Delivery is the outcome of sending an event to a webhook. Status is the
+// last HTTP status received, 0 if the hook could not be reached.
+type Delivery struct {
+	Id       string
+	Hook     string
+	Date     *time.Time
+	Event    note.EventType
+	Status   int
+	Attempts int
+	Name     string
+}
+
+func (d *Delivery) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s,%d,%d,%s", d.Id, d.Hook, d.Date.Format(time.DateTime),
+		d.Event, d.Status, d.Attempts, d.Name)
+}
+
+func (d *Delivery) Parse(s string) error {
+	item := strings.SplitN(s, ",", 7)
+	if len(item) != 7 {
+		return fmt.Errorf("invalid delivery string")
+	}
+
+	ti, err := time.Parse(time.DateTime, item[2])
+	if err != nil {
+		return err
+	}
+	status, err := strconv.Atoi(item[4])
+	if err != nil {
+		return err
+	}
+	attempts, err := strconv.Atoi(item[5])
+	if err != nil {
+		return err
+	}
+	d.Id = item[0]
+	d.Hook = item[1]
+	d.Date = &ti
+	d.Event = note.EventType(item[3])
+	d.Status = status
+	d.Attempts = attempts
+	d.Name = item[6]
+	return nil
+}
+
+// Ok reports if the webhook accepted the event.
+func (d *Delivery) Ok() bool {
+	return d.Status >= 200 && d.Status < 300
+}
+
+// payload is the body posted to webhooks.
+type payload struct {
+	note.Event
+	User string `json:"user,omitempty"`
+}
+
+func (u *users) webhooks() ([]Webhook, error) {
+	r, err := dbline.Open[*Webhook](filepath.Join(u.dir, "webhooks.db"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (u *users) addWebhook(rawURL, user, secret string) (Webhook, error) {
+	p, err := url.Parse(rawURL)
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return Webhook{}, ErrInvalidWebhook
+	}
+	if user != "" {
+		if _, err := u.get(user); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	buf := make([]byte, 40)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if secret == "" {
+		secret = fmt.Sprintf("%x", buf[8:])
+	} else if strings.Contains(secret, ",") {
+		return Webhook{}, fmt.Errorf("webhook secret can't contain commas")
+	}
+	ti := time.Now().UTC().Truncate(time.Second)
+	h := Webhook{
+		Id:     fmt.Sprintf("%x", buf[:8]),
+		User:   user,
+		Secret: secret,
+		Date:   &ti,
+		URL:    rawURL,
+	}
+	err = dbline.AppendEntry(filepath.Join(u.dir, "webhooks.db"), &h)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return h, nil
+}
+
+func (u *users) removeWebhook(id string) error {
+	hooks, err := u.webhooks()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(hooks, func(h Webhook) bool { return h.Id == id })
+	if i < 0 {
+		return ErrNoWebhook
+	}
+	return dbline.Save(filepath.Join(u.dir, "webhooks.db"), ptrs(slices.Delete(hooks, i, i+1)))
+}
+
+func (u *users) deliveries() ([]Delivery, error) {
+	r, err := dbline.Open[*Delivery](filepath.Join(u.dir, "webhooks.log"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// sign returns the value of the X-Note-Signature header for body.
+func sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return fmt.Sprintf("sha256=%x", m.Sum(nil))
+}
+
+// hookQueue holds the events waiting to be routed to the webhooks, pushes
+// never block.
+type hookQueue struct {
+	mu     sync.Mutex
+	events []published
+	ready  chan struct{}
+}
+
+func newHookQueue() *hookQueue {
+	return &hookQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *hookQueue) push(p published) {
+	q.mu.Lock()
+	q.events = append(q.events, p)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the oldest event, waiting for one until ctx is done.
+func (q *hookQueue) pop(ctx context.Context) (published, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			p := q.events[0]
+			q.events = q.events[1:]
+			q.mu.Unlock()
+			return p, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return published{}, false
+		case <-q.ready:
+		}
+	}
+}
+
+// hookJob is an event waiting to be delivered to hook.
+type hookJob struct {
+	hook  Webhook
+	event published
+}
+
+// hookWorker delivers the events of one webhook in order.
+type hookWorker struct {
+	jobs    chan hookJob
+	dropped uint64
+}
+
+// dispatch routes every event of the hook queue to the worker of each
+// matching webhook until ctx is done. A webhook whose backlog is full misses
+// the event, like slow subscribers of the broker.
+func (a *api) dispatch(ctx context.Context) {
+	var logMu sync.Mutex
+	workers := map[string]*hookWorker{}
+	for {
+		p, ok := a.hooks.pop(ctx)
+		if !ok {
+			return
+		}
+		hooks, err := a.users.webhooks()
+		if err != nil {
+			a.log.Error("loading webhooks", "err", err)
+			continue
+		}
+		for id, w := range workers {
+			if !slices.ContainsFunc(hooks, func(h Webhook) bool { return h.Id == id }) {
+				close(w.jobs)
+				delete(workers, id)
+			}
+		}
+		for _, h := range hooks {
+			if h.User != "" && h.User != p.user {
+				continue
+			}
+			w, ok := workers[h.Id]
+			if !ok {
+				w = &hookWorker{jobs: make(chan hookJob, hookBacklog)}
+				workers[h.Id] = w
+				go a.work(ctx, w.jobs, &logMu)
+			}
+			select {
+			case w.jobs <- hookJob{h, p}:
+			default:
+				w.dropped++
+				a.log.Warn("webhook backlog full, event dropped", "hook", h.Id,
+					"event", p.event.Type, "name", p.event.Name, "dropped", w.dropped)
+			}
+		}
+	}
+}
+
+// work delivers the jobs of one webhook until jobs is closed or ctx is done,
+// logging each delivery.
+func (a *api) work(ctx context.Context, jobs <-chan hookJob, logMu *sync.Mutex) {
+	for {
+		var j hookJob
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobs:
+			if !ok {
+				return
+			}
+			j = job
+		}
+		d := a.deliver(ctx, j.hook, j.event)
+		logMu.Lock()
+		err := dbline.AppendEntry(filepath.Join(a.users.dir, "webhooks.log"), &d)
+		logMu.Unlock()
+		if err != nil {
+			a.log.Error("logging webhook delivery", "err", err)
+		}
+	}
+}
+
+// deliver posts the event to h, retrying with backoff until it answers with
+// a 2xx status or the attempts run out.
+func (a *api) deliver(ctx context.Context, h Webhook, p published) Delivery {
+	body, _ := json.Marshal(payload{Event: p.event, User: p.user})
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	ti := time.Now().UTC().Truncate(time.Second)
+	d := Delivery{
+		Id:    fmt.Sprintf("%x", buf),
+		Hook:  h.Id,
+		Date:  &ti,
+		Event: p.event.Type,
+		Name:  p.event.Name,
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	wait := webhookBackoff
+	for d.Attempts < webhookAttempts {
+		d.Attempts++
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+		if err != nil {
+			a.log.Error("webhook request", "hook", h.Id, "err", err)
+			return d
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "note-webhook")
+		req.Header.Set("X-Note-Event", string(p.event.Type))
+		req.Header.Set("X-Note-Delivery", d.Id)
+		req.Header.Set("X-Note-Signature", sign(h.Secret, body))
+
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			d.Status = resp.StatusCode
+			if d.Ok() {
+				return d
+			}
+		} else {
+			d.Status = 0
+		}
+		a.log.Warn("webhook delivery failed", "hook", h.Id, "delivery", d.Id,
+			"attempt", d.Attempts, "status", d.Status, "err", err)
+
+		if d.Attempts < webhookAttempts {
+			select {
+			case <-ctx.Done():
+				return d
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+	}
+	return d
+}
+
+func AddWebhook(url, user, secret string) (Webhook, error) {
+	u, err := newUsers()
+	if err != nil {
+		return Webhook{}, err
+	}
+	return u.addWebhook(url, user, secret)
+}
+
+func RemoveWebhook(id string) error {
+	u, err := newUsers()
+	if err != nil {
+		return err
+	}
+	return u.removeWebhook(id)
+}
+
+func Webhooks() ([]Webhook, error) {
+	u, err := newUsers()
+	if err != nil {
+		return nil, err
+	}
+	return u.webhooks()
+}
+
+// Deliveries returns the webhook delivery log, oldest first.
+func Deliveries() ([]Delivery, error) {
+	u, err := newUsers()
+	if err != nil {
+		return nil, err
+	}
+	return u.deliveries()
+}
@@ -0,0 +1,302 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serboupal/note/note"
+)
+
+func newTestAPI(t *testing.T) *api {
+	t.Helper()
+	return &api{
+		users:    &users{dir: t.TempDir()},
+		log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:  newMetrics(),
+		events:   newBroker(),
+		hooks:    newHookQueue(),
+		backends: map[string]note.Backend{},
+	}
+}
+
+// startDispatch runs the webhook dispatcher of a until the test ends.
+func startDispatch(t *testing.T, a *api) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go a.dispatch(ctx)
+}
+
+// waitDeliveries returns the delivery log once it has n entries.
+func waitDeliveries(t *testing.T, a *api, n int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		d, err := a.users.deliveries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d) >= n {
+			return d
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d deliveries, want %d", len(d), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func event(t note.EventType, name string) note.Event {
+	ti := time.Now()
+	return note.Event{Type: t, Id: "id-" + name, Name: name, Date: &ti}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	got := make(chan payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Note-Signature") != sign("s3cret", body) {
+			t.Errorf("signature %q doesn't match body", r.Header.Get("X-Note-Signature"))
+		}
+		if r.Header.Get("X-Note-Event") != string(note.EventCreated) {
+			t.Errorf("event header %q", r.Header.Get("X-Note-Event"))
+		}
+		p := payload{}
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Error(err)
+		}
+		got <- p
+	}))
+	defer srv.Close()
+
+	a := newTestAPI(t)
+	if _, err := a.users.addWebhook(srv.URL, "", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	startDispatch(t, a)
+	a.hooks.push(published{"amy", event(note.EventCreated, "todo")})
+
+	p := <-got
+	if p.User != "amy" || p.Name != "todo" || p.Type != note.EventCreated || p.Id != "id-todo" {
+		t.Errorf("payload %+v", p)
+	}
+	d := waitDeliveries(t, a, 1)
+	if !d[0].Ok() || d[0].Attempts != 1 || d[0].Name != "todo" {
+		t.Errorf("delivery %+v", d[0])
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	defer func(d time.Duration) { webhookBackoff = d }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var mu sync.Mutex
+	ids := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		id := r.Header.Get("X-Note-Delivery")
+		ids[id]++
+		if ids[id] < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	a := newTestAPI(t)
+	if _, err := a.users.addWebhook(srv.URL, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	startDispatch(t, a)
+	a.hooks.push(published{"", event(note.EventUpdated, "todo")})
+
+	d := waitDeliveries(t, a, 1)
+	if d[0].Status != http.StatusOK || d[0].Attempts != 3 {
+		t.Errorf("delivery %+v, want ok after 3 attempts", d[0])
+	}
+	if len(ids) != 1 {
+		t.Errorf("retries used %d delivery ids, want 1", len(ids))
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	defer func(d time.Duration) { webhookBackoff = d }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	a := newTestAPI(t)
+	if _, err := a.users.addWebhook(srv.URL, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	startDispatch(t, a)
+	a.hooks.push(published{"", event(note.EventDeleted, "todo")})
+
+	d := waitDeliveries(t, a, 1)
+	if d[0].Ok() || d[0].Status != http.StatusInternalServerError || d[0].Attempts != webhookAttempts {
+		t.Errorf("delivery %+v, want failed after %d attempts", d[0], webhookAttempts)
+	}
+}
+
+func TestWebhookUser(t *testing.T) {
+	got := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := payload{}
+		json.NewDecoder(r.Body).Decode(&p)
+		got <- p.User
+	}))
+	defer srv.Close()
+
+	a := newTestAPI(t)
+	if _, err := a.users.add("amy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.users.addWebhook(srv.URL, "amy", ""); err != nil {
+		t.Fatal(err)
+	}
+	startDispatch(t, a)
+	a.hooks.push(published{"bob", event(note.EventCreated, "other")})
+	a.hooks.push(published{"amy", event(note.EventCreated, "mine")})
+
+	if u := <-got; u != "amy" {
+		t.Errorf("hook of amy got an event of %q", u)
+	}
+	waitDeliveries(t, a, 1)
+}
+
+func TestWebhookNoDrop(t *testing.T) {
+	var mu sync.Mutex
+	names := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := payload{}
+		json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		names[p.Name] = true
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a := newTestAPI(t)
+	if _, err := a.users.addWebhook(srv.URL, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// far more than the broker buffers, pushed before anything is read
+	const n = 500
+	for i := 0; i < n; i++ {
+		a.hooks.push(published{"", event(note.EventCreated, "n"+time.Duration(i).String())})
+	}
+	startDispatch(t, a)
+
+	waitDeliveries(t, a, n)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(names) != n {
+		t.Errorf("webhook got %d events, want %d", len(names), n)
+	}
+}
+
+func TestWebhookOrder(t *testing.T) {
+	var mu sync.Mutex
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := payload{}
+		json.NewDecoder(r.Body).Decode(&p)
+		if p.Name == "n0" {
+			// later events must wait for a slow one
+			time.Sleep(50 * time.Millisecond)
+		}
+		mu.Lock()
+		names = append(names, p.Name)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a := newTestAPI(t)
+	if _, err := a.users.addWebhook(srv.URL, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	startDispatch(t, a)
+	const n = 20
+	for i := 0; i < n; i++ {
+		a.hooks.push(published{"", event(note.EventUpdated, fmt.Sprintf("n%d", i))})
+	}
+
+	d := waitDeliveries(t, a, n)
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range names {
+		if want := fmt.Sprintf("n%d", i); names[i] != want || d[i].Name != want {
+			t.Fatalf("event %d delivered as %s, logged as %s, want %s", i, names[i], d[i].Name, want)
+		}
+	}
+}
+
+func TestWebhookBacklog(t *testing.T) {
+	defer func(n int) { hookBacklog = n }(hookBacklog)
+	hookBacklog = 2
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer srv.Close()
+	var once sync.Once
+	free := func() { once.Do(func() { close(release) }) }
+	defer free()
+
+	a := newTestAPI(t)
+	out := &syncBuffer{}
+	a.log = slog.New(slog.NewJSONHandler(out, nil))
+	if _, err := a.users.addWebhook(srv.URL, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	startDispatch(t, a)
+	a.hooks.push(published{"", event(note.EventCreated, "n0")})
+	<-started
+
+	// two wait while n0 is delivered, the rest are dropped
+	for i := 1; i <= 5; i++ {
+		a.hooks.push(published{"", event(note.EventUpdated, fmt.Sprintf("n%d", i))})
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	var dropped float64
+	for dropped != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("dropped %v events, want 3", dropped)
+		}
+		time.Sleep(10 * time.Millisecond)
+		for _, l := range out.lines(t) {
+			if l["msg"] == "webhook backlog full, event dropped" {
+				dropped = l["dropped"].(float64)
+			}
+		}
+	}
+	free()
+
+	d := waitDeliveries(t, a, 3)
+	time.Sleep(50 * time.Millisecond)
+	if d, _ = a.users.deliveries(); len(d) != 3 {
+		t.Fatalf("%d deliveries, want 3", len(d))
+	}
+	for i, want := range []string{"n0", "n1", "n2"} {
+		if d[i].Name != want {
+			t.Errorf("delivery %d of %s, want %s", i, d[i].Name, want)
+		}
+	}
+}